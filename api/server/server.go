@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
@@ -8,8 +9,10 @@ import (
 
 	"github.com/watchlist-kata/protos/user"
 	"github.com/watchlist-kata/user/internal/config"
+	"github.com/watchlist-kata/user/internal/migrate"
 	"github.com/watchlist-kata/user/internal/repository"
 	"github.com/watchlist-kata/user/internal/service"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -35,18 +38,39 @@ func main() {
 		panic(fmt.Sprintf("failed to connect to database: %v", err))
 	}
 
-	// AutoMigrate the schema
-	err = db.AutoMigrate(&repository.GormUser{})
+	// Apply schema migrations; canonical usernames and emails are filled within the migration that adds them
+	backfillCanonical := func(ctx context.Context, tx *gorm.DB) error {
+		collisions, err := repository.NewSQLRepository(tx, logger).BackfillCanonical(ctx)
+		for _, c := range collisions {
+			logger.Warn(fmt.Sprintf("canonical %s collision: user ID %d stored as %q", c.Field, c.UserID, c.Assigned))
+		}
+		return err
+	}
+	migrator, err := migrate.New(db, logger, migrate.WithHook(4, backfillCanonical))
 	if err != nil {
+		logger.Error("failed to load migrations", slog.Any("error", err))
+		panic(fmt.Sprintf("failed to load migrations: %v", err))
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
 		logger.Error("failed to migrate database schema", slog.Any("error", err))
 		panic(fmt.Sprintf("failed to migrate database schema: %v", err))
 	}
 
 	// Create repository instance
-	repo := repository.NewPostgresRepository(db, logger)
+	repo := repository.NewSQLRepository(db, logger)
+
+	// Configure password hashing
+	passwordHasher, err := service.NewPasswordHasher(cfg.PasswordHasher, cfg.PasswordPepper)
+	if err != nil {
+		logger.Error("failed to configure password hasher", slog.Any("error", err))
+		panic(fmt.Sprintf("failed to configure password hasher: %v", err))
+	}
 
 	// Create service instance
-	userService := service.NewUserService(repo, logger)
+	userService := service.NewUserService(repo, logger,
+		service.WithPasswordHashers(service.NewPasswordHashers(passwordHasher,
+			service.NewBcryptHasher(bcrypt.DefaultCost, cfg.PasswordPepper))),
+	)
 
 	// Create gRPC server
 	grpcServer := grpc.NewServer()
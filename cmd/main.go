@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/canonical"
 	"github.com/watchlist-kata/user/internal/config"
+	"github.com/watchlist-kata/user/internal/encryption"
+	"github.com/watchlist-kata/user/internal/migrate"
+	"github.com/watchlist-kata/user/internal/notify"
+	"github.com/watchlist-kata/user/internal/policy"
 	"github.com/watchlist-kata/user/internal/repository"
 	"github.com/watchlist-kata/user/internal/service"
+	"github.com/watchlist-kata/user/internal/token"
+	"github.com/watchlist-kata/user/internal/worker"
 	"github.com/watchlist-kata/user/pkg/logger"
 	"github.com/watchlist-kata/user/pkg/utils"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
+	"gorm.io/gorm"
 	"log"
+	"log/slog"
 	"net"
+	"os"
+	"strconv"
+	"time"
 )
 
 func main() {
@@ -19,6 +35,14 @@ func main() {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
+	// Подкоманда migrate управляет схемой базы данных и завершает работу, не запуская сервер
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
 	// Инициализируем кастомный логгер
 	customLogger, err := logger.NewLogger(cfg.KafkaBrokers, cfg.KafkaTopic, cfg.ServiceName, cfg.LogBufferSize)
 	if err != nil {
@@ -30,20 +54,113 @@ func main() {
 		}
 	}()
 
-	// Создание подключения к базе данных
-	db, err := utils.ConnectToDatabase(cfg)
+	// Правила канонизации имен и адресов почты, общие для хранилища и кэша
+	canonicalRules := newCanonicalRules(cfg)
+
+	// Создание хранилища пользователей. Токены, блокировки, подтверждение почты, 2FA, сеансы и журнал
+	// изменений хранятся только в базе данных, поэтому с хранилищем в памяти эти функции выключены.
+	var repo *repository.SQLRepository
+	var userRepo repository.Repository
+	switch cfg.DBDriver {
+	case config.DBDriverMemory:
+		customLogger.Warn("DB_DRIVER is memory: users are lost on restart; refresh tokens, lockout, email verification, password reset, 2FA, sessions and the audit log are disabled")
+		userRepo = repository.NewMemoryRepository(customLogger, repository.WithMemoryCanonicalRules(canonicalRules))
+	default:
+		repo, err = newSQLRepository(cfg, customLogger, canonicalRules)
+		if err != nil {
+			log.Fatalf("failed to set up database: %v", err)
+		}
+		userRepo = repo
+	}
+
+	// Кэширование чтений пользователей, которые другие сервисы запрашивают почти на каждый запрос
+	var backgroundJobs []worker.Job
+	var cache *repository.CachingRepository
+	if cfg.UserCacheSize > 0 {
+		cache = repository.NewCachingRepository(userRepo, customLogger,
+			repository.WithCacheSize(cfg.UserCacheSize),
+			repository.WithCacheTTL(cfg.UserCacheTTL),
+			repository.WithCacheCanonicalRules(canonicalRules),
+		)
+		userRepo = cache
+		backgroundJobs = append(backgroundJobs, worker.Job{
+			Name:     "report user cache statistics",
+			Interval: cfg.UserCacheStatsInterval,
+			Run:      cache.LogStats,
+		})
+	}
+
+	// Выбор алгоритма хеширования паролей
+	passwordHashers, err := newPasswordHashers(cfg, customLogger)
+	if err != nil {
+		log.Fatalf("failed to configure password hasher: %v", err)
+	}
+
+	// Настройка парольной политики
+	passwordPolicy, err := newPasswordPolicy(cfg, customLogger)
+	if err != nil {
+		log.Fatalf("failed to configure password policy: %v", err)
+	}
+
+	// Загрузка ключей подписи access-токенов
+	tokenIssuer, err := newTokenIssuer(cfg, customLogger)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+		log.Fatalf("failed to configure token issuer: %v", err)
 	}
 
-	// Создание экземпляра репозитория
-	repo := repository.NewPostgresRepository(db, customLogger)
+	// Настройка доставки уведомлений
+	notifier, closeNotifier, err := newNotifier(cfg, customLogger)
+	if err != nil {
+		log.Fatalf("failed to create notifier: %v", err)
+	}
+	defer closeNotifier()
+
+	// Загрузка ключа шифрования секретов 2FA
+	mfaCipher, err := newMFACipher(cfg, customLogger)
+	if err != nil {
+		log.Fatalf("failed to configure two-factor authentication: %v", err)
+	}
 
 	// Создание экземпляра сервиса пользователей
-	userService := service.NewUserService(repo, customLogger)
+	serviceOptions := []service.Option{
+		service.WithPasswordHashers(passwordHashers),
+		service.WithTokenIssuer(tokenIssuer),
+		service.WithPasswordPolicy(passwordPolicy),
+		service.WithNotifier(notifier),
+	}
+	if repo != nil {
+		serviceOptions = append(serviceOptions,
+			service.WithRefreshTokens(repo, cfg.RefreshTokenTTL),
+			service.WithRoles(repo),
+			service.WithLockout(repo, service.LockoutPolicy{
+				Threshold:    cfg.LockoutThreshold,
+				BaseDuration: cfg.LockoutBaseDuration,
+				MaxDuration:  cfg.LockoutMaxDuration,
+				ResetAfter:   cfg.LockoutResetAfter,
+			}),
+			service.WithEmailVerification(repo, cfg.EmailVerificationTTL, cfg.RequireVerifiedEmail),
+			service.WithPasswordReset(repo, cfg.PasswordResetTTL),
+			service.WithMFA(repo, mfaCipher, cfg.MFAIssuer),
+			service.WithSessions(repo, cfg.SessionTTL),
+			service.WithTrustedProxies(cfg.TrustedProxies),
+			service.WithAudit(repo),
+		)
+		// Окончательное удаление пользователей идет через кэш, чтобы он не отдавал удаленные записи
+		var purger repository.DeletedUserPurger = repo
+		if cache != nil {
+			purger = cache
+		}
+		backgroundJobs = append(backgroundJobs, databaseJobs(cfg, repo, purger)...)
+	}
+	userService := service.NewUserService(userRepo, customLogger, serviceOptions...)
 
-	// Создание нового gRPC сервера
-	grpcServer := grpc.NewServer()
+	// Запуск фоновых задач обслуживания
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.Run(ctx, customLogger, backgroundJobs...)
+
+	// Создание нового gRPC сервера; исполнитель и идентификатор запроса передаются в журнал изменений
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(service.AuditUnaryInterceptor()))
 
 	// Регистрация сервиса пользователей в gRPC сервере
 	user.RegisterUserServiceServer(grpcServer, userService)
@@ -59,3 +176,296 @@ func main() {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// connectToDatabase подключается к базе данных, выбранной DB_DRIVER
+func connectToDatabase(cfg *config.Config) (*gorm.DB, error) {
+	if cfg.DBDriver == config.DBDriverSQLite {
+		return utils.ConnectToSQLite(cfg)
+	}
+	return utils.ConnectToDatabase(cfg)
+}
+
+// newSQLRepository подключается к основной базе и репликам, приводит схему к версии этой сборки
+// и заполняет канонические формы у записей, созданных до их появления
+func newSQLRepository(cfg *config.Config, logger *slog.Logger, rules canonical.Rules) (*repository.SQLRepository, error) {
+	db, err := connectToDatabase(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Применение миграций или проверка, что схема базы совпадает с ожидаемой этой сборкой
+	migrator, err := migrate.New(db, logger, migrate.WithHook(canonicalIdentityMigration, backfillCanonical(logger, rules)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if cfg.MigrateOnStart {
+		if _, err := migrator.Up(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+		}
+	} else if err := migrator.Check(context.Background()); err != nil {
+		return nil, fmt.Errorf("database schema is not compatible: %w", err)
+	}
+
+	// Реплики бывают только у Postgres
+	var replicas []*gorm.DB
+	if cfg.DBDriver == config.DBDriverPostgres {
+		replicas, err = utils.ConnectToReplicas(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to read replicas: %w", err)
+		}
+	}
+
+	repo := repository.NewSQLRepository(db, logger,
+		repository.WithCanonicalRules(rules),
+		repository.WithReplicas(replicas, cfg.DBReadYourWritesWindow),
+	)
+
+	// Первая проверка реплик до приема запросов; до нее чтения идут в основную базу
+	if _, err := repo.CheckReplicas(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to check read replicas: %w", err)
+	}
+
+	// Записи, созданные прежней версией сервиса во время обновления, заполняются под блокировкой миграций,
+	// чтобы экземпляры, запускаемые одновременно, не заполняли одни и те же записи
+	err = migrator.Locked(context.Background(), func(conn *gorm.DB) error {
+		return conn.Transaction(func(tx *gorm.DB) error {
+			return backfillCanonical(logger, rules)(tx.Statement.Context, tx)
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to backfill canonical usernames and emails: %w", err)
+	}
+
+	return repo, nil
+}
+
+// canonicalIdentityMigration версия миграции, добавляющей канонические формы имени и почты
+const canonicalIdentityMigration = 4
+
+// backfillCanonical заполняет канонические формы в транзакции tx и сообщает о коллизиях
+func backfillCanonical(logger *slog.Logger, rules canonical.Rules) migrate.Hook {
+	return func(ctx context.Context, tx *gorm.DB) error {
+		repo := repository.NewSQLRepository(tx, logger, repository.WithCanonicalRules(rules))
+		collisions, err := repo.BackfillCanonical(repository.WithAuditInfo(ctx, repository.AuditInfo{Actor: systemAuditActor}))
+		if err != nil {
+			return err
+		}
+		for _, c := range collisions {
+			logger.Warn(fmt.Sprintf("canonical %s collision: user ID %d (%q) conflicts with user ID %d as %q, stored as %q; login is rejected until renamed",
+				c.Field, c.UserID, c.Value, c.ConflictingUserID, c.Canonical, c.Assigned))
+		}
+		return nil
+	}
+}
+
+// newCanonicalRules возвращает правила канонизации имен и адресов почты из конфигурации
+func newCanonicalRules(cfg *config.Config) canonical.Rules {
+	return canonical.Rules{
+		LowercaseLocalPart: cfg.EmailLowercaseLocalPart,
+		StripPlusTag:       cfg.EmailStripPlusTag,
+		IgnoreDotsDomains:  cfg.EmailIgnoreDotsDomains,
+	}
+}
+
+// systemAuditActor исполнитель в журнале изменений для фоновых задач и заполнения канонических форм
+const systemAuditActor = "system"
+
+// databaseJobs возвращает фоновые задачи обслуживания базы: проверку реплик и удаление устаревших записей.
+// Пользователи удаляются через purger, чтобы изменения прошли через кэш, если он включен.
+func databaseJobs(cfg *config.Config, repo *repository.SQLRepository, purger repository.DeletedUserPurger) []worker.Job {
+	return []worker.Job{
+		{
+			Name:     "check read replicas",
+			Interval: cfg.DBReplicaCheckInterval,
+			Run:      repo.CheckReplicas,
+		},
+		{
+			Name:     "purge expired refresh tokens",
+			Interval: cfg.TokenPurgeInterval,
+			Run: func(ctx context.Context) (int64, error) {
+				return repo.PurgeExpiredRefreshTokens(ctx, time.Now())
+			},
+		},
+		{
+			Name:     "purge expired verification tokens",
+			Interval: cfg.TokenPurgeInterval,
+			Run: func(ctx context.Context) (int64, error) {
+				return repo.PurgeExpiredVerificationTokens(ctx, time.Now())
+			},
+		},
+		{
+			Name:     "purge expired sessions",
+			Interval: cfg.TokenPurgeInterval,
+			Run: func(ctx context.Context) (int64, error) {
+				return repo.PurgeExpiredSessions(ctx, time.Now())
+			},
+		},
+		{
+			Name:     "purge deleted users",
+			Interval: cfg.DeletedUserPurgeInterval,
+			Run: func(ctx context.Context) (int64, error) {
+				ctx = repository.WithAuditInfo(ctx, repository.AuditInfo{Actor: systemAuditActor})
+				return purger.PurgeDeletedUsers(ctx, time.Now().Add(-cfg.DeletedUserRetention))
+			},
+		},
+	}
+}
+
+// runMigrate выполняет подкоманду migrate: up, down [n], status или to <версия>
+func runMigrate(cfg *config.Config, args []string) error {
+	const usage = "usage: migrate up | down [steps] | status | to <version>"
+
+	if cfg.DBDriver == config.DBDriverMemory {
+		return fmt.Errorf("migrations are not used with DB_DRIVER=%s", cfg.DBDriver)
+	}
+
+	db, err := connectToDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	stdoutLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	migrator, err := migrate.New(db, stdoutLogger,
+		migrate.WithHook(canonicalIdentityMigration, backfillCanonical(stdoutLogger, newCanonicalRules(cfg))))
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	var applied int
+	switch args[0] {
+	case "up":
+		applied, err = migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		applied, err = migrator.Down(ctx, steps)
+	case "to":
+		if len(args) < 2 {
+			return errors.New(usage)
+		}
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		applied, err = migrator.To(ctx, version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-32s  %s\n", s.Version, s.Name, appliedAt)
+		}
+		return err
+	default:
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d migrations executed, latest known version %d\n", applied, migrator.Latest())
+	return nil
+}
+
+// newTokenIssuer загружает ключи подписи из конфигурации.
+// Без настроенных ключей генерируется временный ключ, и токены перестают проверяться после перезапуска.
+func newTokenIssuer(cfg *config.Config, logger *slog.Logger) (*token.Issuer, error) {
+	var keys []*token.SigningKey
+	for _, keyCfg := range cfg.JWTSigningKeys {
+		key, err := token.LoadSigningKey(keyCfg.ID, keyCfg.Algorithm, keyCfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		key, err := token.GenerateEd25519SigningKey("ephemeral")
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("JWT_SIGNING_KEYS is not set, using an ephemeral signing key")
+		keys = append(keys, key)
+	}
+
+	return token.NewIssuer(token.Config{
+		Issuer:   cfg.JWTIssuer,
+		Audience: cfg.JWTAudience,
+		TTL:      cfg.JWTAccessTTL,
+	}, keys, cfg.JWTActiveKeyID)
+}
+
+// newPasswordPolicy собирает парольную политику и загружает список утекших паролей, если он задан
+func newPasswordPolicy(cfg *config.Config, logger *slog.Logger) (*policy.Policy, error) {
+	p := &policy.Policy{
+		MinLength:      cfg.PasswordMinLength,
+		MaxLength:      cfg.PasswordMaxLength,
+		RequireUpper:   cfg.PasswordRequireUpper,
+		RequireLower:   cfg.PasswordRequireLower,
+		RequireDigit:   cfg.PasswordRequireDigit,
+		RequireSymbol:  cfg.PasswordRequireSymbol,
+		ForbidIdentity: cfg.PasswordForbidIdentity,
+	}
+
+	if cfg.BreachedPasswordsFile != "" {
+		filter, err := policy.LoadBloomFilter(cfg.BreachedPasswordsFile, cfg.BreachedPasswordsFPR)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info(fmt.Sprintf("loaded %d breached passwords into a %d byte filter", filter.Len(), filter.SizeBytes()))
+		p.Breached = filter
+	}
+
+	return p, nil
+}
+
+// newNotifier создает публикацию уведомлений в Kafka или, если тема не задана, запись в лог
+func newNotifier(cfg *config.Config, logger *slog.Logger) (notify.Notifier, func(), error) {
+	if cfg.NotificationTopic == "" {
+		logger.Warn("NOTIFICATION_TOPIC is not set, notifications are written to the log")
+		return notify.NewLogNotifier(logger), func() {}, nil
+	}
+
+	notifier, err := notify.NewKafkaNotifier(cfg.KafkaBrokers, cfg.NotificationTopic)
+	if err != nil {
+		return nil, nil, err
+	}
+	return notifier, func() {
+		if err := notifier.Close(); err != nil {
+			logger.Error("failed to close notifier", slog.Any("error", err))
+		}
+	}, nil
+}
+
+// newPasswordHashers создает алгоритм хеширования новых паролей. bcrypt-sha256 с перцем регистрируется
+// всегда, чтобы его хеши оставались проверяемыми после смены PASSWORD_HASHER.
+func newPasswordHashers(cfg *config.Config, logger *slog.Logger) (*service.PasswordHashers, error) {
+	current, err := service.NewPasswordHasher(cfg.PasswordHasher, cfg.PasswordPepper)
+	if err != nil {
+		return nil, err
+	}
+	if current.Algorithm() == service.AlgorithmBcryptSHA256 && len(cfg.PasswordPepper) == 0 {
+		logger.Warn("PASSWORD_PEPPER is not set, bcrypt-sha256 hashes are created without a pepper")
+	}
+	return service.NewPasswordHashers(current, service.NewBcryptHasher(bcrypt.DefaultCost, cfg.PasswordPepper)), nil
+}
+
+// newMFACipher создает шифр для секретов TOTP; без ключа 2FA выключена
+func newMFACipher(cfg *config.Config, logger *slog.Logger) (*encryption.Cipher, error) {
+	if cfg.MFAEncryptionKey == "" {
+		logger.Warn("MFA_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+		return nil, nil
+	}
+	return encryption.NewCipherFromBase64(cfg.MFAEncryptionKey)
+}
@@ -0,0 +1,83 @@
+package canonical
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Rules правила приведения имен пользователей и адресов почты к каноническому виду.
+// Канонические значения используются для проверки уникальности и поиска,
+// поэтому после изменения правил существующие записи нужно пересчитать.
+type Rules struct {
+	LowercaseLocalPart bool     // Не различать регистр локальной части адреса
+	StripPlusTag       bool     // Отбрасывать метку после "+" в локальной части (bob+news@ -> bob@)
+	IgnoreDotsDomains  []string // Домены, в которых точки в локальной части не значимы (например, gmail.com)
+}
+
+// Default возвращает правила по умолчанию: регистр локальной части не различается, метки и точки сохраняются
+func Default() Rules {
+	return Rules{LowercaseLocalPart: true}
+}
+
+// Username приводит имя пользователя к каноническому виду: NFKC и свертка регистра
+func (r Rules) Username(username string) string {
+	return fold(strings.TrimSpace(username))
+}
+
+// UsernamePrefix приводит начало имени пользователя к каноническому виду для поиска по префиксу
+func (r Rules) UsernamePrefix(prefix string) string {
+	return fold(prefix)
+}
+
+// Email приводит адрес почты к каноническому виду: домен в нижнем регистре (IDN в punycode),
+// локальная часть по правилам r
+func (r Rules) Email(email string) string {
+	email = norm.NFKC.String(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return fold(email)
+	}
+
+	domain := canonicalDomain(email[at+1:])
+	local := email[:at]
+	if r.LowercaseLocalPart {
+		local = fold(local)
+	}
+	if r.StripPlusTag {
+		if plus := strings.Index(local, "+"); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if r.ignoresDots(domain) {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain
+}
+
+// ignoresDots сообщает, что точки в локальной части адресов домена не значимы
+func (r Rules) ignoresDots(domain string) bool {
+	for _, d := range r.IgnoreDotsDomains {
+		if canonicalDomain(d) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalDomain переводит домен в нижний регистр и ASCII-представление
+func canonicalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+	return domain
+}
+
+// fold выполняет NFKC, свертку регистра и повторную NFKC, так как свертка может нарушить нормализацию
+func fold(value string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(value)))
+}
@@ -1,27 +1,100 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 // Config содержит параметры конфигурации приложения
 type Config struct {
+	DBDriver      string   // Хранилище пользователей: postgres, sqlite или memory
 	DBHost        string   // Хост базы данных
 	DBPort        string   // Порт базы данных
 	DBUser        string   // Пользователь базы данных
 	DBPassword    string   // Пароль базы данных
 	DBName        string   // Имя базы данных
 	DBSSLMode     string   // Режим SSL для базы данных
+	DBSQLitePath  string   // Путь к файлу базы SQLite
 	KafkaBrokers  []string // Список брокеров Kafka
 	KafkaTopic    string   // Тема Kafka
 	GRPCPort      string   // Порт для gRPC сервиса
 	ServiceName   string   // Имя сервиса
 	LogBufferSize int      // Размер буфера для логов
+
+	MigrateOnStart bool // Применять миграции схемы при запуске (иначе только проверять версию схемы)
+
+	DBReplicaDSNs          []string      // DSN реплик только для чтения
+	DBReplicaCheckInterval time.Duration // Период проверки доступности реплик
+	DBReadYourWritesWindow time.Duration // Время после изменения пользователя, когда его чтения идут в основную базу
+
+	UserCacheSize          int           // Количество пользователей в кэше чтений (0 отключает кэш)
+	UserCacheTTL           time.Duration // Время жизни записи кэша
+	UserCacheStatsInterval time.Duration // Период записи статистики кэша в лог
+
+	PasswordHasher string // Алгоритм хеширования новых паролей (argon2id, bcrypt-sha256, scrypt)
+	PasswordPepper []byte // Секретный ключ HMAC для bcrypt-sha256 (пусто - хеши без перца)
+
+	JWTIssuer      string        // Издатель access-токенов
+	JWTAudience    []string      // Получатели access-токенов
+	JWTAccessTTL   time.Duration // Время жизни access-токена
+	JWTSigningKeys []SigningKey  // Ключи подписи access-токенов
+	JWTActiveKeyID string        // Идентификатор ключа, которым подписываются новые токены
+
+	RefreshTokenTTL    time.Duration  // Время жизни refresh-токена
+	TokenPurgeInterval time.Duration  // Период удаления истекших токенов
+	SessionTTL         time.Duration  // Время жизни сеанса без активности
+	TrustedProxies     []netip.Prefix // Шлюзы, от которых принимается адрес клиента из x-forwarded-for
+
+	DeletedUserRetention     time.Duration // Срок хранения удаленных пользователей до окончательного удаления
+	DeletedUserPurgeInterval time.Duration // Период окончательного удаления пользователей
+
+	LockoutThreshold    int           // Количество неудачных попыток входа до блокировки (0 отключает блокировку)
+	LockoutBaseDuration time.Duration // Длительность первой блокировки
+	LockoutMaxDuration  time.Duration // Максимальная длительность блокировки
+	LockoutResetAfter   time.Duration // Период без неудач, после которого счетчик сбрасывается
+
+	PasswordMinLength      int     // Минимальная длина пароля
+	PasswordMaxLength      int     // Максимальная длина пароля
+	PasswordRequireUpper   bool    // Требовать заглавную букву
+	PasswordRequireLower   bool    // Требовать строчную букву
+	PasswordRequireDigit   bool    // Требовать цифру
+	PasswordRequireSymbol  bool    // Требовать символ
+	PasswordForbidIdentity bool    // Запрещать пароли с именем пользователя или почтой
+	BreachedPasswordsFile  string  // Файл со списком утекших паролей, по одному на строку
+	BreachedPasswordsFPR   float64 // Допустимая доля ложных срабатываний фильтра утекших паролей
+
+	NotificationTopic    string        // Тема Kafka для уведомлений пользователям (пусто - писать в лог)
+	EmailVerificationTTL time.Duration // Время жизни токена подтверждения почты
+	RequireVerifiedEmail bool          // Запрещать вход с неподтвержденной почтой
+	PasswordResetTTL     time.Duration // Время жизни токена сброса пароля
+
+	MFAEncryptionKey string // Ключ шифрования секретов TOTP (32 байта в base64, пусто - 2FA выключена)
+	MFAIssuer        string // Название сервиса в приложении-аутентификаторе
+	// Правила канонизации адресов почты; после изменения канонические формы существующих записей нужно пересчитать
+	EmailLowercaseLocalPart bool     // Не различать регистр локальной части адреса
+	EmailStripPlusTag       bool     // Отбрасывать метку после "+" в локальной части
+	EmailIgnoreDotsDomains  []string // Домены, в которых точки в локальной части не значимы
+}
+
+// Поддерживаемые хранилища пользователей
+const (
+	DBDriverPostgres = "postgres"
+	DBDriverSQLite   = "sqlite" // Для локального запуска одним процессом и интеграционных тестов
+	DBDriverMemory   = "memory" // Только для тестов и локального запуска: данные теряются при остановке
+)
+
+// SigningKey описывает ключ подписи токенов
+type SigningKey struct {
+	ID        string // Идентификатор ключа (kid)
+	Algorithm string // Алгоритм подписи (EdDSA, RS256, HS256)
+	Path      string // Путь к файлу с ключом
 }
 
 // LoadConfig загружает конфигурацию из .env файла
@@ -32,11 +105,19 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to load .env file: %w", err)
 	}
 
-	// Проверяем обязательные переменные окружения
+	dbDriver := getEnv("DB_DRIVER", DBDriverPostgres)
+	switch dbDriver {
+	case DBDriverPostgres, DBDriverSQLite, DBDriverMemory:
+	default:
+		return nil, fmt.Errorf("invalid DB_DRIVER value: %q", dbDriver)
+	}
+
+	// Проверяем обязательные переменные окружения; параметры подключения к базе нужны только для Postgres
 	requiredEnvVars := []string{
-		"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD",
-		"DB_NAME", "DB_SSLMODE", "KAFKA_BROKERS", "KAFKA_TOPIC",
-		"GRPC_PORT", "SERVICE_NAME", "LOG_BUFFER_SIZE",
+		"KAFKA_BROKERS", "KAFKA_TOPIC", "GRPC_PORT", "SERVICE_NAME", "LOG_BUFFER_SIZE",
+	}
+	if dbDriver == DBDriverPostgres {
+		requiredEnvVars = append(requiredEnvVars, "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME", "DB_SSLMODE")
 	}
 
 	for _, envVar := range requiredEnvVars {
@@ -57,18 +138,284 @@ func LoadConfig() (*Config, error) {
 		logBufferSize = 100 // Значение по умолчанию
 	}
 
+	// Разбираем JWT_SIGNING_KEYS в формате kid:алгоритм:путь,kid:алгоритм:путь
+	signingKeys, err := parseSigningKeys(os.Getenv("JWT_SIGNING_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+
+	replicaCheckInterval, err := getEnvDuration("DB_REPLICA_CHECK_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	readYourWritesWindow, err := getEnvDuration("DB_READ_YOUR_WRITES_WINDOW", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	userCacheSize, err := getEnvInt("USER_CACHE_SIZE", 10000)
+	if err != nil {
+		return nil, err
+	}
+
+	userCacheTTL, err := getEnvDuration("USER_CACHE_TTL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	userCacheStatsInterval, err := getEnvDuration("USER_CACHE_STATS_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	jwtAccessTTL, err := getEnvDuration("JWT_ACCESS_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenTTL, err := getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenPurgeInterval, err := getEnvDuration("TOKEN_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionTTL, err := getEnvDuration("SESSION_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	// Разбираем TRUSTED_PROXIES в формате адрес или подсеть через запятую
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
+	deletedUserRetention, err := getEnvDuration("DELETED_USER_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	deletedUserPurgeInterval, err := getEnvDuration("DELETED_USER_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutThreshold, err := getEnvInt("LOCKOUT_THRESHOLD", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutBaseDuration, err := getEnvDuration("LOCKOUT_BASE_DURATION", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutMaxDuration, err := getEnvDuration("LOCKOUT_MAX_DURATION", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	lockoutResetAfter, err := getEnvDuration("LOCKOUT_RESET_AFTER", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordMinLength, err := getEnvInt("PASSWORD_MIN_LENGTH", 8)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordMaxLength, err := getEnvInt("PASSWORD_MAX_LENGTH", 128)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPepper, err := base64.StdEncoding.DecodeString(os.Getenv("PASSWORD_PEPPER"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PASSWORD_PEPPER value: %w", err)
+	}
+
+	breachedPasswordsFPR, err := strconv.ParseFloat(getEnv("BREACHED_PASSWORDS_FP_RATE", "0.001"), 64)
+	if err != nil || breachedPasswordsFPR <= 0 || breachedPasswordsFPR >= 1 {
+		return nil, fmt.Errorf("invalid BREACHED_PASSWORDS_FP_RATE value")
+	}
+
+	emailVerificationTTL, err := getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordResetTTL, err := getEnvDuration("PASSWORD_RESET_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
 	// Возвращаем конфигурацию
 	return &Config{
+		DBDriver:      dbDriver,
 		DBHost:        os.Getenv("DB_HOST"),
 		DBPort:        os.Getenv("DB_PORT"),
 		DBUser:        os.Getenv("DB_USER"),
 		DBPassword:    os.Getenv("DB_PASSWORD"),
 		DBName:        os.Getenv("DB_NAME"),
 		DBSSLMode:     os.Getenv("DB_SSLMODE"),
+		DBSQLitePath:  getEnv("DB_SQLITE_PATH", "user.db"),
 		KafkaBrokers:  kafkaBrokers,
 		KafkaTopic:    os.Getenv("KAFKA_TOPIC"),
 		GRPCPort:      os.Getenv("GRPC_PORT"),
 		ServiceName:   os.Getenv("SERVICE_NAME"),
 		LogBufferSize: logBufferSize,
+
+		MigrateOnStart: getEnvBool("MIGRATE_ON_START", true),
+
+		DBReplicaDSNs:          getEnvList("DB_REPLICA_DSNS"),
+		DBReplicaCheckInterval: replicaCheckInterval,
+		DBReadYourWritesWindow: readYourWritesWindow,
+
+		UserCacheSize:          userCacheSize,
+		UserCacheTTL:           userCacheTTL,
+		UserCacheStatsInterval: userCacheStatsInterval,
+
+		PasswordHasher: getEnv("PASSWORD_HASHER", "argon2id"),
+		PasswordPepper: passwordPepper,
+
+		JWTIssuer:      getEnv("JWT_ISSUER", "watchlist-user"),
+		JWTAudience:    getEnvList("JWT_AUDIENCE"),
+		JWTAccessTTL:   jwtAccessTTL,
+		JWTSigningKeys: signingKeys,
+		JWTActiveKeyID: os.Getenv("JWT_ACTIVE_KEY_ID"),
+
+		RefreshTokenTTL:    refreshTokenTTL,
+		TokenPurgeInterval: tokenPurgeInterval,
+		SessionTTL:         sessionTTL,
+		TrustedProxies:     trustedProxies,
+
+		DeletedUserRetention:     deletedUserRetention,
+		DeletedUserPurgeInterval: deletedUserPurgeInterval,
+
+		LockoutThreshold:    lockoutThreshold,
+		LockoutBaseDuration: lockoutBaseDuration,
+		LockoutMaxDuration:  lockoutMaxDuration,
+		LockoutResetAfter:   lockoutResetAfter,
+
+		PasswordMinLength:      passwordMinLength,
+		PasswordMaxLength:      passwordMaxLength,
+		PasswordRequireUpper:   getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireLower:   getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireDigit:   getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		PasswordRequireSymbol:  getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordForbidIdentity: getEnvBool("PASSWORD_FORBID_IDENTITY", true),
+		BreachedPasswordsFile:  os.Getenv("BREACHED_PASSWORDS_FILE"),
+		BreachedPasswordsFPR:   breachedPasswordsFPR,
+
+		NotificationTopic:    os.Getenv("NOTIFICATION_TOPIC"),
+		EmailVerificationTTL: emailVerificationTTL,
+		RequireVerifiedEmail: getEnvBool("REQUIRE_VERIFIED_EMAIL", false),
+		PasswordResetTTL:     passwordResetTTL,
+
+		MFAEncryptionKey: os.Getenv("MFA_ENCRYPTION_KEY"),
+		MFAIssuer:        getEnv("MFA_ISSUER", "Watchlist"),
+
+		EmailLowercaseLocalPart: getEnvBool("EMAIL_LOWERCASE_LOCAL_PART", true),
+		EmailStripPlusTag:       getEnvBool("EMAIL_STRIP_PLUS_TAG", false),
+		EmailIgnoreDotsDomains:  getEnvList("EMAIL_IGNORE_DOTS_DOMAINS"),
 	}, nil
 }
+
+// parseSigningKeys разбирает список ключей подписи
+func parseSigningKeys(value string) ([]SigningKey, error) {
+	var keys []SigningKey
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid JWT_SIGNING_KEYS entry %q: expected kid:algorithm:path", item)
+		}
+		keys = append(keys, SigningKey{ID: parts[0], Algorithm: parts[1], Path: parts[2]})
+	}
+	return keys, nil
+}
+
+// parseTrustedProxies разбирает список адресов и подсетей доверенных шлюзов; адрес без маски - подсеть из одного адреса
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// getEnv возвращает значение переменной окружения или значение по умолчанию, если она не задана
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration разбирает переменную окружения как time.Duration
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s value: %q", key, value)
+	}
+	return d, nil
+}
+
+// getEnvInt разбирает переменную окружения как неотрицательное целое число
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s value: %q", key, value)
+	}
+	return n, nil
+}
+
+// getEnvBool разбирает переменную окружения как логическое значение
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList разбирает переменную окружения как список через запятую
+func getEnvList(key string) []string {
+	return splitList(os.Getenv(key))
+}
+
+// splitList разбивает строку по запятым, отбрасывая пустые элементы
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,75 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// version префикс формата шифротекста, оставляющий место для смены ключа или алгоритма
+const version = "v1"
+
+// ErrMalformedCiphertext возвращается, если шифротекст не удается разобрать или расшифровать
+var ErrMalformedCiphertext = errors.New("malformed ciphertext")
+
+// Cipher шифрует небольшие секреты для хранения в базе данных алгоритмом AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher создает Cipher из 32-байтного ключа
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// NewCipherFromBase64 создает Cipher из ключа в base64
+func NewCipherFromBase64(key string) (*Cipher, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %w", err)
+	}
+	return NewCipher(raw)
+}
+
+// Encrypt шифрует plaintext; associatedData привязывает шифротекст к владельцу, например к ID пользователя
+func (c *Cipher) Encrypt(plaintext, associatedData []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, associatedData)
+	return version + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает результат Encrypt с теми же associatedData
+func (c *Cipher) Decrypt(ciphertext string, associatedData []byte) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(ciphertext, version+":")
+	if !ok {
+		return nil, ErrMalformedCiphertext
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, ErrMalformedCiphertext
+	}
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, associatedData)
+	if err != nil {
+		return nil, ErrMalformedCiphertext
+	}
+	return plaintext, nil
+}
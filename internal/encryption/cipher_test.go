@@ -0,0 +1,115 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	return c
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+
+	first, err := c.Encrypt(plaintext, []byte("user:42"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	second, err := c.Encrypt(plaintext, []byte("user:42"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	// Случайный nonce делает шифротексты одного секрета разными
+	if first == second {
+		t.Error("two encryptions of the same plaintext are equal")
+	}
+	if !strings.HasPrefix(first, version+":") {
+		t.Errorf("ciphertext %q has no version prefix", first)
+	}
+
+	for _, ciphertext := range []string{first, second} {
+		decrypted, err := c.Decrypt(ciphertext, []byte("user:42"))
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypt = %q, want %q", decrypted, plaintext)
+		}
+	}
+}
+
+func TestCipherRejectsTamperedCiphertext(t *testing.T) {
+	c := newTestCipher(t)
+	ciphertext, err := c.Encrypt([]byte("secret"), []byte("user:42"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(ciphertext, version+":"))
+	if err != nil {
+		t.Fatalf("failed to decode ciphertext: %v", err)
+	}
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+
+	other, err := NewCipher(bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		cipher     *Cipher
+		ciphertext string
+	}{
+		{name: "flipped bit", cipher: c, ciphertext: version + ":" + base64.RawStdEncoding.EncodeToString(flipped)},
+		{name: "truncated", cipher: c, ciphertext: version + ":" + base64.RawStdEncoding.EncodeToString(sealed[:len(sealed)-1])},
+		{name: "shorter than nonce", cipher: c, ciphertext: version + ":" + base64.RawStdEncoding.EncodeToString(sealed[:4])},
+		{name: "unknown version", cipher: c, ciphertext: "v2" + strings.TrimPrefix(ciphertext, version)},
+		{name: "not base64", cipher: c, ciphertext: version + ":!!!"},
+		{name: "other key", cipher: other, ciphertext: ciphertext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cipher.Decrypt(tt.ciphertext, []byte("user:42")); !errors.Is(err, ErrMalformedCiphertext) {
+				t.Errorf("Decrypt error = %v, want %v", err, ErrMalformedCiphertext)
+			}
+		})
+	}
+}
+
+func TestCipherRejectsWrongAssociatedData(t *testing.T) {
+	c := newTestCipher(t)
+	ciphertext, err := c.Encrypt([]byte("secret"), []byte("user:42"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// Секрет одного пользователя нельзя расшифровать как секрет другого
+	for _, associatedData := range [][]byte{[]byte("user:43"), nil} {
+		if _, err := c.Decrypt(ciphertext, associatedData); !errors.Is(err, ErrMalformedCiphertext) {
+			t.Errorf("Decrypt with associated data %q error = %v, want %v", associatedData, err, ErrMalformedCiphertext)
+		}
+	}
+}
+
+func TestNewCipherKeyLength(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 16)); err == nil {
+		t.Error("NewCipher with a 16-byte key succeeded, want an error")
+	}
+	if _, err := NewCipherFromBase64(base64.StdEncoding.EncodeToString(make([]byte, 32))); err != nil {
+		t.Errorf("NewCipherFromBase64: %v", err)
+	}
+	if _, err := NewCipherFromBase64("not base64"); err == nil {
+		t.Error("NewCipherFromBase64 with invalid base64 succeeded, want an error")
+	}
+}
@@ -0,0 +1,411 @@
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var (
+	// ErrUnknownSchemaVersion возвращается, если в базе применены миграции, неизвестные этой сборке
+	ErrUnknownSchemaVersion = errors.New("database schema is newer than this build")
+	// ErrPendingMigrations возвращается проверкой схемы, если в базе применены не все миграции
+	ErrPendingMigrations = errors.New("database schema has pending migrations")
+	// ErrUnknownVersion возвращается при переходе к версии, для которой нет миграции
+	ErrUnknownVersion = errors.New("unknown migration version")
+	// ErrUnsupportedDialect возвращается для баз данных, для которых нет миграций
+	ErrUnsupportedDialect = errors.New("unsupported database dialect")
+)
+
+// fileNamePattern имя файла миграции: <версия>_<название>.<up|down>.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// lockKey ключ advisory-блокировки миграций ("WATCHUSR" в ASCII)
+const lockKey int64 = 0x5741544348555352
+
+// Migration описывает одну версию схемы
+type Migration struct {
+	Version int64  // Номер версии
+	Name    string // Название миграции
+	Up      string // SQL применения
+	Down    string // SQL отката
+}
+
+// Status описывает состояние миграции в базе данных
+type Status struct {
+	Migration
+	AppliedAt *time.Time // Момент применения; nil, если миграция не применена
+}
+
+// dialect описывает особенности базы данных для миграций
+type dialect struct {
+	dir         string                  // Каталог с файлами миграций
+	createTable string                  // SQL создания таблицы schema_migrations
+	lock        func(db *gorm.DB) error // Захват блокировки миграций на соединении
+	unlock      func(db *gorm.DB) error // Освобождение блокировки миграций
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		dir: "postgres",
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    bigint PRIMARY KEY,
+			name       text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		lock: func(db *gorm.DB) error {
+			return db.Exec("SELECT pg_advisory_lock(?)", lockKey).Error
+		},
+		unlock: func(db *gorm.DB) error {
+			return db.Exec("SELECT pg_advisory_unlock(?)", lockKey).Error
+		},
+	},
+	// Версии миграций SQLite совпадают с Postgres. Блокировка не нужна: файлом базы пользуется один процесс,
+	// а каждая миграция выполняется в транзакции, которая сама блокирует запись.
+	"sqlite": {
+		dir: "sqlite",
+		createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    integer PRIMARY KEY,
+			name       text NOT NULL,
+			applied_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		lock:   func(*gorm.DB) error { return nil },
+		unlock: func(*gorm.DB) error { return nil },
+	},
+}
+
+// appliedMigration строка таблицы schema_migrations
+type appliedMigration struct {
+	Version   int64
+	AppliedAt time.Time
+}
+
+// Hook заполняет данные, которые нельзя вычислить в SQL, в транзакции миграции
+type Hook func(ctx context.Context, tx *gorm.DB) error
+
+// Migrator применяет и откатывает миграции схемы базы данных
+type Migrator struct {
+	db         *gorm.DB
+	logger     *slog.Logger
+	dialect    dialect
+	migrations []Migration    // Отсортированы по возрастанию версии
+	hooks      map[int64]Hook // Выполняются после применения миграции с этой версией
+}
+
+// Option настраивает Migrator
+type Option func(*Migrator)
+
+// WithHook выполняет hook после SQL применения миграции version в той же транзакции и под той же блокировкой
+func WithHook(version int64, hook Hook) Option {
+	return func(m *Migrator) {
+		m.hooks[version] = hook
+	}
+}
+
+// New создает Migrator с миграциями для диалекта переданного подключения
+func New(db *gorm.DB, logger *slog.Logger, opts ...Option) (*Migrator, error) {
+	name := db.Dialector.Name()
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, name)
+	}
+
+	migrations, err := load(files, d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{db: db, logger: logger, dialect: d, migrations: migrations, hooks: make(map[int64]Hook)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for version := range m.hooks {
+		if _, ok := m.find(version); !ok {
+			return nil, fmt.Errorf("%w: hook for %d", ErrUnknownVersion, version)
+		}
+	}
+	return m, nil
+}
+
+// load читает миграции из каталога и проверяет, что у каждой версии есть применение и откат
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %q", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d has different names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest возвращает версию последней известной миграции
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up применяет все непримененные миграции и возвращает их количество
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.migrate(ctx, func([]appliedMigration) (int64, error) {
+		return m.Latest(), nil
+	})
+}
+
+// Down откатывает последние steps примененных миграций и возвращает их количество
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, fmt.Errorf("invalid number of steps: %d", steps)
+	}
+	return m.migrate(ctx, func(applied []appliedMigration) (int64, error) {
+		if steps >= len(applied) {
+			return 0, nil
+		}
+		return applied[len(applied)-steps-1].Version, nil
+	})
+}
+
+// To применяет или откатывает миграции так, чтобы последней примененной стала указанная версия.
+// Версия 0 откатывает все миграции.
+func (m *Migrator) To(ctx context.Context, version int64) (int, error) {
+	if version != 0 {
+		if _, ok := m.find(version); !ok {
+			return 0, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+	}
+	return m.migrate(ctx, func([]appliedMigration) (int64, error) {
+		return version, nil
+	})
+}
+
+// Status возвращает состояние всех известных миграций.
+// Если в базе применены неизвестные миграции, возвращается ErrUnknownSchemaVersion.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.db.WithContext(ctx).Exec(m.dialect.createTable).Error; err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	applied, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int64]time.Time, len(applied))
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if at, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, m.checkKnown(applied)
+}
+
+// Check проверяет, что схема базы данных совпадает с ожидаемой этой сборкой:
+// возвращает ErrUnknownSchemaVersion для более новой схемы и ErrPendingMigrations для устаревшей
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []int64
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Version)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %v", ErrPendingMigrations, pending)
+	}
+	return nil
+}
+
+// Locked выполняет fn на соединении, удерживающем блокировку миграций.
+// Другие экземпляры сервиса в это время не применяют миграции и не выполняют свои Locked.
+func (m *Migrator) Locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := m.dialect.lock(conn); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer func() {
+			if err := m.dialect.unlock(conn); err != nil {
+				m.logger.Error("failed to release migration lock", slog.Any("error", err))
+			}
+		}()
+
+		// Новая сессия на соединении: запросы fn не изменяют ее состояние и не влияют друг на друга
+		return fn(conn.Session(&gorm.Session{NewDB: true}))
+	})
+}
+
+// migrate под блокировкой приводит схему к версии, которую вычисляет target по списку примененных миграций
+func (m *Migrator) migrate(ctx context.Context, target func(applied []appliedMigration) (int64, error)) (int, error) {
+	count := 0
+	err := m.Locked(ctx, func(conn *gorm.DB) error {
+		if err := conn.Exec(m.dialect.createTable).Error; err != nil {
+			return fmt.Errorf("failed to create schema_migrations table: %w", err)
+		}
+
+		// Список читается под блокировкой, чтобы не применить миграции, уже примененные другим экземпляром
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkKnown(applied); err != nil {
+			return err
+		}
+
+		version, err := target(applied)
+		if err != nil {
+			return err
+		}
+
+		isApplied := make(map[int64]bool, len(applied))
+		for _, a := range applied {
+			isApplied[a.Version] = true
+		}
+
+		// Сначала откатываются миграции новее целевой версии, от последней к первой
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= version || !isApplied[migration.Version] {
+				continue
+			}
+			if err := m.apply(conn, migration, false); err != nil {
+				return err
+			}
+			count++
+		}
+
+		// Затем применяются недостающие миграции до целевой версии включительно
+		for _, migration := range m.migrations {
+			if migration.Version > version || isApplied[migration.Version] {
+				continue
+			}
+			if err := m.apply(conn, migration, true); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// apply выполняет миграцию, ее hook и изменяет schema_migrations в одной транзакции
+func (m *Migrator) apply(conn *gorm.DB, migration Migration, up bool) error {
+	direction, script := "down", migration.Down
+	if up {
+		direction, script = "up", migration.Up
+	}
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(script).Error; err != nil {
+			return err
+		}
+		if up {
+			if hook, ok := m.hooks[migration.Version]; ok {
+				if err := hook(tx.Statement.Context, tx); err != nil {
+					return err
+				}
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error
+		}
+		return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s %d_%s: %w", direction, migration.Version, migration.Name, err)
+	}
+
+	m.logger.Info(fmt.Sprintf("migrated %s %d_%s", direction, migration.Version, migration.Name))
+	return nil
+}
+
+// applied возвращает примененные миграции по возрастанию версии
+func (m *Migrator) applied(db *gorm.DB) ([]appliedMigration, error) {
+	var applied []appliedMigration
+	err := db.Raw("SELECT version, applied_at FROM schema_migrations ORDER BY version").Scan(&applied).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// checkKnown проверяет, что все примененные миграции известны этой сборке
+func (m *Migrator) checkKnown(applied []appliedMigration) error {
+	var unknown []int64
+	for _, a := range applied {
+		if _, ok := m.find(a.Version); !ok {
+			unknown = append(unknown, a.Version)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: applied versions %v, latest known %d", ErrUnknownSchemaVersion, unknown, m.Latest())
+	}
+	return nil
+}
+
+// find ищет миграцию по версии
+func (m *Migrator) find(version int64) (Migration, bool) {
+	i := sort.Search(len(m.migrations), func(i int) bool { return m.migrations[i].Version >= version })
+	if i < len(m.migrations) && m.migrations[i].Version == version {
+		return m.migrations[i], true
+	}
+	return Migration{}, false
+}
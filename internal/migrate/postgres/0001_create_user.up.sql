@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "user" (
+    id         bigserial PRIMARY KEY,
+    username   text NOT NULL,
+    email      text NOT NULL,
+    pwdhash    text NOT NULL,
+    salt       text NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    CONSTRAINT uni_user_username UNIQUE (username),
+    CONSTRAINT uni_user_email UNIQUE (email)
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS session;
+DROP TABLE IF EXISTS recovery_code;
+DROP TABLE IF EXISTS user_mfa;
+DROP TABLE IF EXISTS verification_token;
+DROP TABLE IF EXISTS login_attempt;
+DROP TABLE IF EXISTS refresh_token;
+
+ALTER TABLE "user" DROP COLUMN IF EXISTS email_verified_at;
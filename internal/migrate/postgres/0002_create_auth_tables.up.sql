@@ -0,0 +1,72 @@
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS email_verified_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    family_id  text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    rotated_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user_id ON refresh_token (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_family_id ON refresh_token (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_token_token_hash ON refresh_token (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_expires_at ON refresh_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_attempt (
+    user_id        bigint PRIMARY KEY,
+    failed_count   bigint NOT NULL DEFAULT 0,
+    last_failed_at timestamptz,
+    locked_until   timestamptz,
+    updated_at     timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempt_locked_until ON login_attempt (locked_until);
+
+CREATE TABLE IF NOT EXISTS verification_token (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    purpose    varchar(32) NOT NULL,
+    token_hash text NOT NULL,
+    email      text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_verification_token_user_id ON verification_token (user_id);
+CREATE INDEX IF NOT EXISTS idx_verification_token_purpose ON verification_token (purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_verification_token_token_hash ON verification_token (token_hash);
+CREATE INDEX IF NOT EXISTS idx_verification_token_expires_at ON verification_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id          bigint PRIMARY KEY,
+    secret_encrypted text NOT NULL,
+    confirmed_at     timestamptz,
+    last_used_step   bigint NOT NULL DEFAULT 0,
+    created_at       timestamptz,
+    updated_at       timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    code_hash  text NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_code_user_id ON recovery_code (user_id);
+
+CREATE TABLE IF NOT EXISTS session (
+    id           text PRIMARY KEY,
+    user_id      bigint NOT NULL,
+    device_label varchar(255),
+    user_agent   varchar(512),
+    peer_address varchar(255),
+    created_at   timestamptz,
+    last_seen_at timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    revoked_at   timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_session_user_id ON session (user_id);
+CREATE INDEX IF NOT EXISTS idx_session_expires_at ON session (expires_at);
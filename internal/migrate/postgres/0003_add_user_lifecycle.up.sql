@@ -0,0 +1,5 @@
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_user_deleted_at ON "user" (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_created_at_id ON "user" (created_at, id);
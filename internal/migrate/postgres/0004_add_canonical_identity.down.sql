@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_user_email_id;
+DROP INDEX IF EXISTS idx_user_username_id;
+DROP INDEX IF EXISTS idx_user_email_unfilled;
+DROP INDEX IF EXISTS idx_user_username_unfilled;
+DROP INDEX IF EXISTS idx_user_email_lower_pattern;
+DROP INDEX IF EXISTS idx_user_username_canonical_pattern;
+
+ALTER TABLE "user" ADD CONSTRAINT uni_user_username UNIQUE (username);
+ALTER TABLE "user" ADD CONSTRAINT uni_user_email UNIQUE (email);
+
+DROP INDEX IF EXISTS idx_user_email_canonical;
+DROP INDEX IF EXISTS idx_user_username_canonical;
+
+ALTER TABLE "user" DROP COLUMN IF EXISTS email_canonical;
+ALTER TABLE "user" DROP COLUMN IF EXISTS username_canonical;
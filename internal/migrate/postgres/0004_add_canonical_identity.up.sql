@@ -0,0 +1,28 @@
+-- Канонические формы вычисляются в сервисе: их заполняет hook этой миграции в той же транзакции,
+-- а записи, созданные прежней версией сервиса во время обновления, - заполнение при запуске
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS username_canonical text;
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS email_canonical text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_username_canonical ON "user" (username_canonical);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_canonical ON "user" (email_canonical);
+
+-- Уникальность проверяется по каноническим формам; ограничения могли быть созданы под любым из этих имен
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS uni_user_username;
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS uni_user_email;
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS user_username_key;
+ALTER TABLE "user" DROP CONSTRAINT IF EXISTS user_email_key;
+
+-- Поиск по префиксу независимо от правил сортировки базы
+DROP INDEX IF EXISTS idx_user_username_pattern;
+DROP INDEX IF EXISTS idx_user_email_pattern;
+CREATE INDEX IF NOT EXISTS idx_user_username_canonical_pattern ON "user" (username_canonical text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_user_email_lower_pattern ON "user" (LOWER(email) text_pattern_ops);
+
+-- Поиск по имени и почте для записей, у которых канонические формы еще не заполнены
+CREATE INDEX IF NOT EXISTS idx_user_username_unfilled ON "user" (username) WHERE username_canonical IS NULL;
+CREATE INDEX IF NOT EXISTS idx_user_email_unfilled ON "user" (email) WHERE email_canonical IS NULL;
+
+-- Сортировка и keyset-пагинация списка по имени и почте вместо удаленных ограничений уникальности.
+-- Сравнение по байтам (COLLATE "C") не зависит от правил сортировки базы и совпадает с MemoryRepository.
+CREATE INDEX IF NOT EXISTS idx_user_username_id ON "user" (username COLLATE "C", id);
+CREATE INDEX IF NOT EXISTS idx_user_email_id ON "user" (email COLLATE "C", id);
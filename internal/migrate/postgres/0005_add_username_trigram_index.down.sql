@@ -0,0 +1,2 @@
+-- Расширение не удаляется: им могут пользоваться другие объекты базы
+DROP INDEX IF EXISTS idx_user_username_canonical_trgm;
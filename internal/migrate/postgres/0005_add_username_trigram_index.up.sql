@@ -0,0 +1,4 @@
+-- Нечеткий поиск пользователей; расширение создается один раз на базу и требует соответствующих прав
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_user_username_canonical_trgm ON "user" USING gin (username_canonical gin_trgm_ops);
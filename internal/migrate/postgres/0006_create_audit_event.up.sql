@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS audit_event (
+    id         bigserial PRIMARY KEY,
+    actor      text NOT NULL,
+    action     varchar(32) NOT NULL,
+    user_id    bigint NOT NULL,
+    changes    text NOT NULL,
+    request_id text NOT NULL,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_audit_event_user_id ON audit_event (user_id);
+
+-- Журнал только дополняется: изменение и удаление записей запрещены на уровне базы
+CREATE OR REPLACE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_event_append_only ON audit_event;
+CREATE TRIGGER audit_event_append_only
+    BEFORE UPDATE OR DELETE ON audit_event
+    FOR EACH ROW EXECUTE FUNCTION audit_event_append_only();
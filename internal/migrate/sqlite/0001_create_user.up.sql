@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "user" (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    username   text NOT NULL,
+    email      text NOT NULL,
+    pwdhash    text NOT NULL,
+    salt       text NOT NULL,
+    created_at datetime,
+    updated_at datetime
+);
+
+-- Ограничение таблицы в SQLite нельзя удалить без ее пересоздания, поэтому уникальность задана индексами
+CREATE UNIQUE INDEX IF NOT EXISTS uni_user_username ON "user" (username);
+CREATE UNIQUE INDEX IF NOT EXISTS uni_user_email ON "user" (email);
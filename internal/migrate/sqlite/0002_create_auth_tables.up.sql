@@ -0,0 +1,72 @@
+ALTER TABLE "user" ADD COLUMN email_verified_at datetime;
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    user_id    integer NOT NULL,
+    family_id  text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at datetime NOT NULL,
+    rotated_at datetime,
+    revoked_at datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user_id ON refresh_token (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_family_id ON refresh_token (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_token_token_hash ON refresh_token (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_expires_at ON refresh_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_attempt (
+    user_id        integer PRIMARY KEY,
+    failed_count   integer NOT NULL DEFAULT 0,
+    last_failed_at datetime,
+    locked_until   datetime,
+    updated_at     datetime
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempt_locked_until ON login_attempt (locked_until);
+
+CREATE TABLE IF NOT EXISTS verification_token (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    user_id    integer NOT NULL,
+    purpose    varchar(32) NOT NULL,
+    token_hash text NOT NULL,
+    email      text NOT NULL,
+    expires_at datetime NOT NULL,
+    used_at    datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_verification_token_user_id ON verification_token (user_id);
+CREATE INDEX IF NOT EXISTS idx_verification_token_purpose ON verification_token (purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_verification_token_token_hash ON verification_token (token_hash);
+CREATE INDEX IF NOT EXISTS idx_verification_token_expires_at ON verification_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id          integer PRIMARY KEY,
+    secret_encrypted text NOT NULL,
+    confirmed_at     datetime,
+    last_used_step   integer NOT NULL DEFAULT 0,
+    created_at       datetime,
+    updated_at       datetime
+);
+
+CREATE TABLE IF NOT EXISTS recovery_code (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    user_id    integer NOT NULL,
+    code_hash  text NOT NULL,
+    used_at    datetime,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_code_user_id ON recovery_code (user_id);
+
+CREATE TABLE IF NOT EXISTS session (
+    id           text PRIMARY KEY,
+    user_id      integer NOT NULL,
+    device_label varchar(255),
+    user_agent   varchar(512),
+    peer_address varchar(255),
+    created_at   datetime,
+    last_seen_at datetime NOT NULL,
+    expires_at   datetime NOT NULL,
+    revoked_at   datetime
+);
+CREATE INDEX IF NOT EXISTS idx_session_user_id ON session (user_id);
+CREATE INDEX IF NOT EXISTS idx_session_expires_at ON session (expires_at);
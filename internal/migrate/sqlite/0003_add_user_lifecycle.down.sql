@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_created_at_id;
+DROP INDEX IF EXISTS idx_user_deleted_at;
+
+ALTER TABLE "user" DROP COLUMN deleted_at;
+ALTER TABLE "user" DROP COLUMN version;
@@ -0,0 +1,5 @@
+ALTER TABLE "user" ADD COLUMN version integer NOT NULL DEFAULT 1;
+ALTER TABLE "user" ADD COLUMN deleted_at datetime;
+
+CREATE INDEX IF NOT EXISTS idx_user_deleted_at ON "user" (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_created_at_id ON "user" (created_at, id);
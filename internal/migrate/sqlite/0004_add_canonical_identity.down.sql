@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS idx_user_email_id;
+DROP INDEX IF EXISTS idx_user_username_id;
+DROP INDEX IF EXISTS idx_user_email_unfilled;
+DROP INDEX IF EXISTS idx_user_username_unfilled;
+DROP INDEX IF EXISTS idx_user_email_lower_pattern;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uni_user_username ON "user" (username);
+CREATE UNIQUE INDEX IF NOT EXISTS uni_user_email ON "user" (email);
+
+DROP INDEX IF EXISTS idx_user_email_canonical;
+DROP INDEX IF EXISTS idx_user_username_canonical;
+
+ALTER TABLE "user" DROP COLUMN email_canonical;
+ALTER TABLE "user" DROP COLUMN username_canonical;
@@ -0,0 +1,22 @@
+-- Канонические формы вычисляются в сервисе: их заполняет hook этой миграции в той же транзакции,
+-- а записи, созданные прежней версией сервиса во время обновления, - заполнение при запуске
+ALTER TABLE "user" ADD COLUMN username_canonical text;
+ALTER TABLE "user" ADD COLUMN email_canonical text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_username_canonical ON "user" (username_canonical);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email_canonical ON "user" (email_canonical);
+
+-- Уникальность проверяется по каноническим формам
+DROP INDEX IF EXISTS uni_user_username;
+DROP INDEX IF EXISTS uni_user_email;
+
+-- Поиск по префиксу почты; для имени достаточно уникального индекса
+CREATE INDEX IF NOT EXISTS idx_user_email_lower_pattern ON "user" (LOWER(email));
+
+-- Поиск по имени и почте для записей, у которых канонические формы еще не заполнены
+CREATE INDEX IF NOT EXISTS idx_user_username_unfilled ON "user" (username) WHERE username_canonical IS NULL;
+CREATE INDEX IF NOT EXISTS idx_user_email_unfilled ON "user" (email) WHERE email_canonical IS NULL;
+
+-- Сортировка и keyset-пагинация списка по имени и почте вместо удаленных уникальных индексов
+CREATE INDEX IF NOT EXISTS idx_user_username_id ON "user" (username, id);
+CREATE INDEX IF NOT EXISTS idx_user_email_id ON "user" (email, id);
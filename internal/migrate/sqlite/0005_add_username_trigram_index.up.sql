@@ -0,0 +1,3 @@
+-- В SQLite нет pg_trgm: поиск пользователей идет только по подстроке, индекс ему не помогает.
+-- Миграция сохраняет совпадение версий схемы с Postgres.
+SELECT 1;
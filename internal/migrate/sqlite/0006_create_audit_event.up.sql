@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS audit_event (
+    id         integer PRIMARY KEY AUTOINCREMENT,
+    actor      text NOT NULL,
+    action     varchar(32) NOT NULL,
+    user_id    integer NOT NULL,
+    changes    text NOT NULL,
+    request_id text NOT NULL,
+    created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_audit_event_user_id ON audit_event (user_id);
+
+-- Журнал только дополняется: изменение и удаление записей запрещены на уровне базы
+CREATE TRIGGER IF NOT EXISTS audit_event_no_update
+    BEFORE UPDATE ON audit_event
+BEGIN
+    SELECT RAISE(ABORT, 'audit_event is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_event_no_delete
+    BEFORE DELETE ON audit_event
+BEGIN
+    SELECT RAISE(ABORT, 'audit_event is append-only');
+END;
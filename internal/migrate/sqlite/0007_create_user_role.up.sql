@@ -0,0 +1,7 @@
+-- Роли пользователей для access-токенов; назначаются администраторами напрямую в базе
+CREATE TABLE IF NOT EXISTS user_role (
+    user_id    integer NOT NULL,
+    role       varchar(64) NOT NULL,
+    created_at datetime,
+    PRIMARY KEY (user_id, role)
+);
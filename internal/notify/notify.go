@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Типы уведомлений
+const (
+	TypeEmailVerification = "email_verification"
+	TypePasswordReset     = "password_reset"
+)
+
+// Message уведомление с одноразовым токеном для пользователя
+type Message struct {
+	Type      string    `json:"type"`       // Тип уведомления
+	UserID    int64     `json:"user_id"`    // ID пользователя
+	Email     string    `json:"email"`      // Адрес получателя
+	Token     string    `json:"token"`      // Одноразовый токен
+	ExpiresAt time.Time `json:"expires_at"` // Момент истечения токена
+}
+
+// Notifier доставляет уведомления пользователям
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// KafkaNotifier публикует уведомления в топик Kafka, откуда их забирает сервис рассылки
+type KafkaNotifier struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaNotifier создает KafkaNotifier
+func NewKafkaNotifier(brokers []string, topic string) (*KafkaNotifier, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync producer: %w", err)
+	}
+
+	return &KafkaNotifier{producer: producer, topic: topic}, nil
+}
+
+// Send публикует уведомление; ключом сообщения служит адрес, чтобы уведомления одному получателю шли по порядку
+func (k *KafkaNotifier) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	_, _, err = k.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.topic,
+		Key:   sarama.StringEncoder(msg.Email),
+		Value: sarama.ByteEncoder(payload),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish notification: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает продюсер
+func (k *KafkaNotifier) Close() error {
+	return k.producer.Close()
+}
+
+// LogNotifier пишет уведомления в лог вместо доставки; предназначен для локальной разработки
+type LogNotifier struct {
+	logger *slog.Logger
+}
+
+// NewLogNotifier создает LogNotifier
+func NewLogNotifier(logger *slog.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+// Send записывает уведомление в лог; токен пишется только на уровне Debug
+func (l *LogNotifier) Send(ctx context.Context, msg Message) error {
+	l.logger.InfoContext(ctx, fmt.Sprintf("%s notification for user ID: %d", msg.Type, msg.UserID))
+	l.logger.DebugContext(ctx, fmt.Sprintf("%s token for user ID %d: %s", msg.Type, msg.UserID, msg.Token))
+	return nil
+}
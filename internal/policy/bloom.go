@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// BloomFilter компактное вероятностное множество: Test может ошибочно вернуть true,
+// но никогда не возвращает false для добавленного элемента
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64 // Количество бит
+	k      uint64 // Количество хеш-функций
+	length int    // Количество добавленных элементов
+}
+
+// NewBloomFilter создает фильтр для expectedItems элементов с заданной вероятностью ложного срабатывания
+func NewBloomFilter(expectedItems int, falsePositiveRate float64) *BloomFilter {
+	if expectedItems < 1 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.001
+	}
+
+	n := float64(expectedItems)
+	m := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/n*math.Ln2)))
+
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// Add добавляет элемент в фильтр
+func (f *BloomFilter) Add(item string) {
+	h1, h2 := bloomHashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.length++
+}
+
+// Test сообщает, что элемент, возможно, был добавлен в фильтр
+func (f *BloomFilter) Test(item string) bool {
+	h1, h2 := bloomHashes(item)
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len возвращает количество добавленных элементов
+func (f *BloomFilter) Len() int {
+	return f.length
+}
+
+// SizeBytes возвращает объем памяти, занимаемый битовым массивом
+func (f *BloomFilter) SizeBytes() int {
+	return len(f.bits) * 8
+}
+
+// bloomHashes возвращает две независимые хеш-функции для двойного хеширования
+func bloomHashes(item string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(item))
+	h1 := binary.LittleEndian.Uint64(sum[0:8])
+	h2 := binary.LittleEndian.Uint64(sum[8:16]) | 1
+	return h1, h2
+}
+
+// LoadBloomFilter компилирует в фильтр список паролей из файла, по одному на строку.
+// Файл читается дважды: сначала для подсчета строк, затем для заполнения фильтра нужного размера.
+func LoadBloomFilter(path string, falsePositiveRate float64) (*BloomFilter, error) {
+	count := 0
+	if err := scanLines(path, func(string) { count++ }); err != nil {
+		return nil, err
+	}
+
+	filter := NewBloomFilter(count, falsePositiveRate)
+	if err := scanLines(path, filter.Add); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// scanLines вызывает fn для каждой непустой строки файла
+func scanLines(path string, fn func(string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			fn(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilterNoFalseNegatives(t *testing.T) {
+	tests := []struct {
+		items int
+		rate  float64
+	}{
+		{items: 1, rate: 0.001},
+		{items: 1000, rate: 0.01},
+		{items: 10000, rate: 0.001},
+		// Переполненный фильтр ошибается чаще, но добавленные элементы по-прежнему находит
+		{items: 5000, rate: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d items at %g", tt.items, tt.rate), func(t *testing.T) {
+			f := NewBloomFilter(tt.items/2, tt.rate)
+			for i := 0; i < tt.items; i++ {
+				f.Add(fmt.Sprintf("password-%d", i))
+			}
+			for i := 0; i < tt.items; i++ {
+				if item := fmt.Sprintf("password-%d", i); !f.Test(item) {
+					t.Fatalf("Test(%q) = false for an added item", item)
+				}
+			}
+			if f.Len() != tt.items {
+				t.Errorf("Len = %d, want %d", f.Len(), tt.items)
+			}
+		})
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	const items, rate, probes = 10000, 0.01, 100000
+	f := NewBloomFilter(items, rate)
+	for i := 0; i < items; i++ {
+		f.Add(fmt.Sprintf("breached-%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < probes; i++ {
+		if f.Test(fmt.Sprintf("unique-%d", i)) {
+			falsePositives++
+		}
+	}
+	// Запас вдвое от заданной вероятности исключает случайные падения
+	if got := float64(falsePositives) / probes; got > 2*rate {
+		t.Errorf("false positive rate = %g, want at most %g", got, 2*rate)
+	}
+}
+
+func TestNewBloomFilterInvalidParameters(t *testing.T) {
+	for _, rate := range []float64{0, -1, 1, 2} {
+		f := NewBloomFilter(0, rate)
+		f.Add("password")
+		if !f.Test("password") || f.SizeBytes() == 0 {
+			t.Errorf("NewBloomFilter(0, %g) must fall back to usable defaults", rate)
+		}
+	}
+}
+
+func TestLoadBloomFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("123456\r\npassword\n\nqwerty\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	f, err := LoadBloomFilter(path, 0.001)
+	if err != nil {
+		t.Fatalf("LoadBloomFilter: %v", err)
+	}
+	if f.Len() != 3 {
+		t.Errorf("Len = %d, want 3 non-empty lines", f.Len())
+	}
+	for _, item := range []string{"123456", "password", "qwerty"} {
+		if !f.Test(item) {
+			t.Errorf("Test(%q) = false for a listed password", item)
+		}
+	}
+
+	if _, err := LoadBloomFilter(filepath.Join(t.TempDir(), "missing.txt"), 0.001); err == nil {
+		t.Error("LoadBloomFilter of a missing file succeeded")
+	}
+}
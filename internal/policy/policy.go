@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Коды нарушений парольной политики
+const (
+	ViolationTooShort         = "TOO_SHORT"
+	ViolationTooLong          = "TOO_LONG"
+	ViolationMissingUpper     = "MISSING_UPPERCASE"
+	ViolationMissingLower     = "MISSING_LOWERCASE"
+	ViolationMissingDigit     = "MISSING_DIGIT"
+	ViolationMissingSymbol    = "MISSING_SYMBOL"
+	ViolationContainsIdentity = "CONTAINS_IDENTITY"
+	ViolationBreached         = "BREACHED"
+)
+
+// Violation нарушение парольной политики
+type Violation struct {
+	Field       string // Поле запроса
+	Code        string // Код нарушения
+	Description string // Описание для пользователя
+}
+
+// Policy правила, которым должен соответствовать пароль
+type Policy struct {
+	MinLength      int          // Минимальная длина в символах
+	MaxLength      int          // Максимальная длина в символах (0 без ограничения)
+	RequireUpper   bool         // Требовать заглавную букву
+	RequireLower   bool         // Требовать строчную букву
+	RequireDigit   bool         // Требовать цифру
+	RequireSymbol  bool         // Требовать символ, не являющийся буквой или цифрой
+	ForbidIdentity bool         // Запрещать пароли, содержащие имя пользователя или электронную почту
+	Breached       *BloomFilter // Список утекших паролей (nil отключает проверку)
+}
+
+// Default возвращает политику по умолчанию: длина 8-128 символов и запрет имени пользователя в пароле
+func Default() *Policy {
+	return &Policy{
+		MinLength:      8,
+		MaxLength:      128,
+		ForbidIdentity: true,
+	}
+}
+
+// Validate проверяет пароль и возвращает все найденные нарушения
+func (p *Policy) Validate(password, username, email string) []Violation {
+	var violations []Violation
+	add := func(code, description string) {
+		violations = append(violations, Violation{Field: "password", Code: code, Description: description})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(ViolationTooShort, fmt.Sprintf("password must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(ViolationTooLong, fmt.Sprintf("password must be at most %d characters", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		add(ViolationMissingUpper, "password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		add(ViolationMissingLower, "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(ViolationMissingDigit, "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(ViolationMissingSymbol, "password must contain a symbol")
+	}
+
+	if p.ForbidIdentity && containsIdentity(password, username, email) {
+		add(ViolationContainsIdentity, "password must not contain the username or email")
+	}
+
+	if p.Breached != nil && password != "" && p.Breached.Test(password) {
+		add(ViolationBreached, "password appears in a list of breached passwords")
+	}
+
+	return violations
+}
+
+// minIdentityLength минимальная длина имени или локальной части почты, которую имеет смысл искать в пароле
+const minIdentityLength = 3
+
+// containsIdentity сообщает, что пароль содержит имя пользователя, адрес почты или его локальную часть
+func containsIdentity(password, username, email string) bool {
+	lowered := strings.ToLower(password)
+	candidates := []string{username, email}
+	if local, _, ok := strings.Cut(email, "@"); ok {
+		candidates = append(candidates, local)
+	}
+
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(candidate)
+		if utf8.RuneCountInString(candidate) >= minIdentityLength && strings.Contains(lowered, candidate) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	strict := &Policy{
+		MinLength:      8,
+		MaxLength:      16,
+		RequireUpper:   true,
+		RequireLower:   true,
+		RequireDigit:   true,
+		RequireSymbol:  true,
+		ForbidIdentity: true,
+	}
+	breached := NewBloomFilter(1, 0.001)
+	breached.Add("Password1!")
+
+	tests := []struct {
+		name     string
+		policy   *Policy
+		password string
+		username string
+		email    string
+		want     []string
+	}{
+		{name: "satisfies all rules", policy: strict, password: "Str0ng!Pass", username: "alice", email: "alice@example.com"},
+		{name: "too short", policy: strict, password: "Ab1!", want: []string{ViolationTooShort}},
+		{name: "too long", policy: strict, password: "Abcdefgh1!abcdefgh", want: []string{ViolationTooLong}},
+		{name: "length counts characters, not bytes", policy: &Policy{MinLength: 8, MaxLength: 8}, password: "пароль12"},
+		{name: "no max length", policy: &Policy{MinLength: 1}, password: string(make([]byte, 1000))},
+		{name: "missing uppercase", policy: strict, password: "str0ng!pass", want: []string{ViolationMissingUpper}},
+		{name: "missing lowercase", policy: strict, password: "STR0NG!PASS", want: []string{ViolationMissingLower}},
+		{name: "missing digit", policy: strict, password: "Strong!Pass", want: []string{ViolationMissingDigit}},
+		{name: "missing symbol", policy: strict, password: "Str0ngPass", want: []string{ViolationMissingSymbol}},
+		{name: "space is not a symbol", policy: strict, password: "Str0ng Pass", want: []string{ViolationMissingSymbol}},
+		{name: "non-ASCII letters count", policy: strict, password: "Пар0ль!пар", username: "alice"},
+		{name: "contains username", policy: strict, password: "Str0ng!Alice", username: "alice", want: []string{ViolationContainsIdentity}},
+		{name: "contains email", policy: strict, password: "1!a@b.cOm", email: "a@b.com", want: []string{ViolationContainsIdentity}},
+		{name: "contains email local part", policy: strict, password: "Str0ng!Bob12", email: "bob12@example.com", want: []string{ViolationContainsIdentity}},
+		{name: "short username is ignored", policy: strict, password: "Str0ng!Pass", username: "as"},
+		{name: "identity allowed", policy: &Policy{MinLength: 1}, password: "alice123", username: "alice"},
+		{name: "breached", policy: &Policy{MinLength: 1, Breached: breached}, password: "Password1!", want: []string{ViolationBreached}},
+		{name: "not breached", policy: &Policy{MinLength: 1, Breached: breached}, password: "Password2!"},
+		{
+			name:     "reports every violation",
+			policy:   strict,
+			password: "alice",
+			username: "alice",
+			want: []string{ViolationTooShort, ViolationMissingUpper, ViolationMissingDigit,
+				ViolationMissingSymbol, ViolationContainsIdentity},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range tt.policy.Validate(tt.password, tt.username, tt.email) {
+				if v.Field != "password" || v.Description == "" {
+					t.Errorf("violation %+v must have field and description", v)
+				}
+				got = append(got, v.Code)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	p := Default()
+	if v := p.Validate("correct horse", "alice", "alice@example.com"); len(v) != 0 {
+		t.Errorf("Default().Validate = %v, want no violations", v)
+	}
+	if v := p.Validate("short", "", ""); len(v) != 1 || v[0].Code != ViolationTooShort {
+		t.Errorf("Default().Validate(short) = %v, want %s", v, ViolationTooShort)
+	}
+}
@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Действия с пользователем, которые записываются в журнал изменений
+const (
+	AuditActionCreate            = "create"
+	AuditActionUpdate            = "update"
+	AuditActionDelete            = "delete"
+	AuditActionRestore           = "restore"
+	AuditActionPurge             = "purge"
+	AuditActionVerifyEmail       = "verify_email"
+	AuditActionRehashPassword    = "rehash_password"
+	AuditActionBackfillCanonical = "backfill_canonical"
+	AuditActionEnableMFA         = "enable_mfa"
+	AuditActionDisableMFA        = "disable_mfa"
+)
+
+// UnknownAuditActor записывается в журнал, если в контексте не указано, кто выполняет изменение
+const UnknownAuditActor = "unknown"
+
+// Размеры страницы журнала изменений
+const (
+	DefaultAuditEventsPageSize = 50
+	MaxAuditEventsPageSize     = 200
+)
+
+// auditPasswordField поле журнала, обозначающее смену пароля; хеш и соль в журнал не попадают
+const auditPasswordField = "password"
+
+// AuditInfo сведения о вызове, которые записываются вместе с изменением пользователя
+type AuditInfo struct {
+	Actor     string // Кто выполняет изменение
+	RequestID string // Идентификатор запроса
+}
+
+// auditContextKey ключ контекста со сведениями о вызове для журнала изменений
+type auditContextKey struct{}
+
+// WithAuditInfo возвращает контекст, изменения пользователей в котором записываются в журнал от имени info.Actor
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, info)
+}
+
+// auditInfoFrom возвращает сведения о вызове из контекста; неизвестный исполнитель заменяется UnknownAuditActor
+func auditInfoFrom(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditContextKey{}).(AuditInfo)
+	if info.Actor == "" {
+		info.Actor = UnknownAuditActor
+	}
+	return info
+}
+
+// AuditChange значения поля до и после изменения. Для секретов сохраняется только факт изменения.
+type AuditChange struct {
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// AuditEvent запись журнала изменений пользователя
+type AuditEvent struct {
+	ID        uint
+	Actor     string
+	Action    string
+	UserID    uint
+	Changes   map[string]AuditChange // Измененные поля по имени
+	RequestID string
+	CreatedAt time.Time
+}
+
+// ListAuditEventsOptions параметры постраничного списка событий журнала
+type ListAuditEventsOptions struct {
+	UserID   uint // Только события этого пользователя; 0 - события всех пользователей
+	PageSize int
+	Cursor   string // Курсор из предыдущей страницы, пусто для первой
+}
+
+// AuditEventPage страница журнала изменений, от новых событий к старым
+type AuditEventPage struct {
+	Events     []*AuditEvent
+	NextCursor string // Пусто на последней странице
+}
+
+// AuditRepository интерфейс чтения журнала изменений пользователей
+type AuditRepository interface {
+	ListAuditEvents(ctx context.Context, opts ListAuditEventsOptions) (*AuditEventPage, error)
+}
+
+// auditCursor содержимое курсора журнала: ID последнего события страницы и пользователь, для которого он выдан
+type auditCursor struct {
+	UserID uint `json:"u,omitempty"`
+	ID     uint `json:"id"`
+}
+
+// lockForUpdate блокирует прочитанные строки до конца транзакции, чтобы прежние значения в журнале
+// совпадали с перезаписанными. SQLite блокирует всю базу и не поддерживает это предложение, GORM его опускает.
+var lockForUpdate = clause.Locking{Strength: "UPDATE"}
+
+// ListAuditEvents возвращает страницу журнала изменений от новых событий к старым
+func (r *SQLRepository) ListAuditEvents(ctx context.Context, opts ListAuditEventsOptions) (*AuditEventPage, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error("ListAuditEvents operation canceled", slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	if opts.PageSize < 0 {
+		return nil, fmt.Errorf("%w: negative page size", ErrInvalidListOptions)
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = DefaultAuditEventsPageSize
+	}
+	if opts.PageSize > MaxAuditEventsPageSize {
+		opts.PageSize = MaxAuditEventsPageSize
+	}
+
+	query := r.db.WithContext(ctx).Model(&GormAuditEvent{})
+	if opts.UserID != 0 {
+		query = query.Where("user_id = ?", opts.UserID)
+	}
+	if opts.Cursor != "" {
+		cursor, err := decodeAuditCursor(opts)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id < ?", cursor.ID)
+	}
+
+	// Запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+	var gormEvents []GormAuditEvent
+	if err := query.Order("id DESC").Limit(opts.PageSize + 1).Find(&gormEvents).Error; err != nil {
+		r.logger.Error("failed to list audit events", slog.Any("error", err))
+		return nil, err
+	}
+
+	page := &AuditEventPage{Events: make([]*AuditEvent, 0, len(gormEvents))}
+	if len(gormEvents) > opts.PageSize {
+		gormEvents = gormEvents[:opts.PageSize]
+		page.NextCursor = encodeAuditCursor(opts.UserID, gormEvents[len(gormEvents)-1].ID)
+	}
+	for i := range gormEvents {
+		event, err := convertToAuditEvent(&gormEvents[i])
+		if err != nil {
+			r.logger.Error(fmt.Sprintf("failed to decode audit event with ID: %d", gormEvents[i].ID), slog.Any("error", err))
+			return nil, err
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	r.logger.Debug(fmt.Sprintf("listed %d audit events", len(page.Events)))
+	return page, nil
+}
+
+// appendAudit записывает событие журнала в транзакции tx, в которой выполнено изменение пользователя.
+// before и after - запись пользователя до и после изменения; nil, если записи нет.
+func appendAudit(ctx context.Context, tx *gorm.DB, action string, userID uint, before, after *GormUser) error {
+	return appendAuditChanges(ctx, tx, action, userID, auditDiff(before, after))
+}
+
+// appendAuditChanges записывает в транзакции tx событие журнала с изменениями, которые не следуют
+// из записи пользователя: каноническими формами и настройками 2FA
+func appendAuditChanges(ctx context.Context, tx *gorm.DB, action string, userID uint, changes map[string]AuditChange) error {
+	event, err := newGormAuditEvent(ctx, action, userID, changes)
+	if err != nil {
+		return err
+	}
+	return tx.Create(event).Error
+}
+
+// newGormAuditEvent формирует запись журнала со сведениями о вызове из контекста
+func newGormAuditEvent(ctx context.Context, action string, userID uint, changes map[string]AuditChange) (*GormAuditEvent, error) {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	info := auditInfoFrom(ctx)
+	return &GormAuditEvent{
+		Actor:     info.Actor,
+		Action:    action,
+		UserID:    userID,
+		Changes:   string(encoded),
+		RequestID: info.RequestID,
+	}, nil
+}
+
+// auditDiff возвращает поля, различающиеся в before и after. Хеш пароля и соль не сохраняются:
+// их изменение отмечается полем "password" без значений. Канонические формы и версия следуют
+// из остальных полей и не записываются.
+func auditDiff(before, after *GormUser) map[string]AuditChange {
+	var empty GormUser
+	if before == nil {
+		before = &empty
+	}
+	if after == nil {
+		after = &empty
+	}
+
+	changes := make(map[string]AuditChange)
+	addChange := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes[field] = AuditChange{Before: oldValue, After: newValue}
+		}
+	}
+	addChange("username", before.Username, after.Username)
+	addChange("email", before.Email, after.Email)
+	if before.Pwdhash != after.Pwdhash || before.Salt != after.Salt {
+		changes[auditPasswordField] = AuditChange{Redacted: true}
+	}
+	addChange("email_verified_at", formatAuditTime(before.EmailVerifiedAt), formatAuditTime(after.EmailVerifiedAt))
+
+	var deletedBefore, deletedAfter *time.Time
+	if before.DeletedAt.Valid {
+		deletedBefore = &before.DeletedAt.Time
+	}
+	if after.DeletedAt.Valid {
+		deletedAfter = &after.DeletedAt.Time
+	}
+	addChange("deleted_at", formatAuditTime(deletedBefore), formatAuditTime(deletedAfter))
+
+	return changes
+}
+
+// formatAuditTime форматирует момент времени для журнала; nil записывается пустой строкой
+func formatAuditTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// convertToAuditEvent преобразует GormAuditEvent в AuditEvent
+func convertToAuditEvent(gormEvent *GormAuditEvent) (*AuditEvent, error) {
+	var changes map[string]AuditChange
+	if err := json.Unmarshal([]byte(gormEvent.Changes), &changes); err != nil {
+		return nil, err
+	}
+	return &AuditEvent{
+		ID:        gormEvent.ID,
+		Actor:     gormEvent.Actor,
+		Action:    gormEvent.Action,
+		UserID:    gormEvent.UserID,
+		Changes:   changes,
+		RequestID: gormEvent.RequestID,
+		CreatedAt: gormEvent.CreatedAt,
+	}, nil
+}
+
+// encodeAuditCursor формирует непрозрачный курсор, указывающий на события старше события lastID
+func encodeAuditCursor(userID, lastID uint) string {
+	data, _ := json.Marshal(auditCursor{UserID: userID, ID: lastID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeAuditCursor разбирает курсор и проверяет, что он выдан для того же пользователя
+func decodeAuditCursor(opts ListAuditEventsOptions) (*auditCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor auditCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.UserID != opts.UserID {
+		return nil, fmt.Errorf("%w: cursor was issued for a different user", ErrInvalidCursor)
+	}
+	return &cursor, nil
+}
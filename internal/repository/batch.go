@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/watchlist-kata/user/internal/canonical"
+	"gorm.io/gorm"
+)
+
+// MaxUserBatchSize максимальное количество разных пользователей в одном пакетном запросе
+const MaxUserBatchSize = 100
+
+var ErrBatchTooLarge = fmt.Errorf("batch must contain at most %d users", MaxUserBatchSize)
+
+// GetUsersByIDs получает пользователей одним запросом. Найденные возвращаются в порядке запроса
+// без повторов, ID отсутствующих и удаленных пользователей - отдельно.
+// Если разных ID больше MaxUserBatchSize, возвращает ErrBatchTooLarge.
+func (r *SQLRepository) GetUsersByIDs(ctx context.Context, ids []uint) ([]*User, []uint, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetUsersByIDs operation canceled for %d IDs", len(ids)), slog.Any("error", ctx.Err()))
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	unique, err := uniqueIDs(ids)
+	if err != nil || len(unique) == 0 {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(unique))
+	for _, id := range unique {
+		keys = append(keys, userIDKey(id))
+	}
+
+	var gormUsers []GormUser
+	err = r.read(ctx, keys, func(db *gorm.DB) error {
+		gormUsers = nil
+		return db.Where("id IN ?", unique).Find(&gormUsers).Error
+	})
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to get %d users by ID", len(unique)), slog.Any("error", err))
+		return nil, nil, err
+	}
+
+	users, missing := orderUsersByID(unique, gormUsers)
+	r.logger.Info(fmt.Sprintf("users fetched successfully by ID: %d found, %d missing", len(users), len(missing)))
+	return users, missing, nil
+}
+
+// GetUsersByUsernames получает пользователей по именам одним запросом с учетом правил канонизации.
+// Найденные возвращаются в порядке запроса без повторов, имена без пользователей - отдельно в том виде,
+// как они переданы. Если разных имен больше MaxUserBatchSize, возвращает ErrBatchTooLarge.
+func (r *SQLRepository) GetUsersByUsernames(ctx context.Context, usernames []string) ([]*User, []string, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetUsersByUsernames operation canceled for %d usernames", len(usernames)), slog.Any("error", ctx.Err()))
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	unique, err := uniqueUsernames(r.canon, usernames)
+	if err != nil || len(unique) == 0 {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(unique))
+	canonicals := make([]string, 0, len(unique))
+	for _, u := range unique {
+		keys = append(keys, usernameKey(u.canonical))
+		canonicals = append(canonicals, u.canonical)
+	}
+
+	var gormUsers []GormUser
+	err = r.read(ctx, keys, func(db *gorm.DB) error {
+		gormUsers = nil
+		return db.Where("username_canonical IN ?", canonicals).Find(&gormUsers).Error
+	})
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to get %d users by username", len(unique)), slog.Any("error", err))
+		return nil, nil, err
+	}
+
+	users, missing := orderUsersByUsername(unique, gormUsers)
+	r.logger.Info(fmt.Sprintf("users fetched successfully by username: %d found, %d missing", len(users), len(missing)))
+	return users, missing, nil
+}
+
+// uniqueIDs убирает повторы ID с сохранением порядка и проверяет размер пакета
+func uniqueIDs(ids []uint) ([]uint, error) {
+	unique := make([]uint, 0, len(ids))
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) > MaxUserBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	return unique, nil
+}
+
+// requestedUsername имя из пакетного запроса вместе с канонической формой
+type requestedUsername struct {
+	username  string
+	canonical string
+}
+
+// uniqueUsernames убирает повторы имен с сохранением порядка и проверяет размер пакета.
+// Имена, различающиеся только регистром или формой символов, обозначают одного пользователя.
+func uniqueUsernames(canon canonical.Rules, usernames []string) ([]requestedUsername, error) {
+	unique := make([]requestedUsername, 0, len(usernames))
+	seen := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		c := canon.Username(username)
+		if !seen[c] {
+			seen[c] = true
+			unique = append(unique, requestedUsername{username: username, canonical: c})
+		}
+	}
+	if len(unique) > MaxUserBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	return unique, nil
+}
+
+// orderUsersByID раскладывает найденных пользователей в порядке запроса и собирает ненайденные ID
+func orderUsersByID(ids []uint, gormUsers []GormUser) ([]*User, []uint) {
+	byID := make(map[uint]*GormUser, len(gormUsers))
+	for i := range gormUsers {
+		byID[gormUsers[i].ID] = &gormUsers[i]
+	}
+
+	users := make([]*User, 0, len(gormUsers))
+	var missing []uint
+	for _, id := range ids {
+		if gormUser, ok := byID[id]; ok {
+			users = append(users, convertToUser(gormUser))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return users, missing
+}
+
+// orderUsersByUsername раскладывает найденных пользователей в порядке запроса и собирает ненайденные имена
+func orderUsersByUsername(usernames []requestedUsername, gormUsers []GormUser) ([]*User, []string) {
+	byCanonical := make(map[string]*GormUser, len(gormUsers))
+	for i := range gormUsers {
+		if gormUsers[i].UsernameCanonical != nil {
+			byCanonical[*gormUsers[i].UsernameCanonical] = &gormUsers[i]
+		}
+	}
+
+	users := make([]*User, 0, len(gormUsers))
+	var missing []string
+	for _, u := range usernames {
+		if gormUser, ok := byCanonical[u.canonical]; ok {
+			users = append(users, convertToUser(gormUser))
+		} else {
+			missing = append(missing, u.username)
+		}
+	}
+	return users, missing
+}
@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/canonical"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DefaultUserCacheSize = 10000            // Размер кэша пользователей по умолчанию
+	DefaultUserCacheTTL  = 30 * time.Second // Время жизни записи кэша по умолчанию
+)
+
+// CacheStats статистика кэша пользователей
+type CacheStats struct {
+	Hits      uint64 // Чтения, обслуженные из кэша
+	Misses    uint64 // Чтения, переданные в нижележащий репозиторий
+	Evictions uint64 // Записи, вытесненные из-за размера или истекшие
+	Size      int    // Текущее количество записей
+}
+
+// cacheEntry запись кэша; пользователь хранится вместе с каноническими формами, по которым его находят
+type cacheEntry struct {
+	user              *User
+	usernameCanonical string
+	emailCanonical    string
+	expiresAt         time.Time
+	element           *list.Element
+}
+
+// CachingRepository декоратор Repository, кэширующий чтения пользователей по ID, имени и почте.
+// Записи вытесняются по LRU и истекают по TTL; изменения через декоратор сбрасывают запись сразу.
+// Изменения, сделанные другими экземплярами сервиса, становятся видны не позже чем через TTL.
+// Чтения с контекстом WithPrimary идут мимо кэша и обновляют его.
+type CachingRepository struct {
+	Repository
+
+	logger *slog.Logger
+	canon  canonical.Rules
+	size   int
+	ttl    time.Duration
+
+	mu         sync.Mutex
+	lru        *list.List // ID пользователей, в начале - недавно использованные
+	byID       map[uint]*cacheEntry
+	byUsername map[string]uint
+	byEmail    map[string]uint
+	generation uint64 // Увеличивается при каждом сбросе, чтобы не сохранять результаты чтений, начатых до него
+
+	loads singleflight.Group
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// CacheOption задает необязательные параметры CachingRepository
+type CacheOption func(*CachingRepository)
+
+// WithCacheSize задает максимальное количество пользователей в кэше
+func WithCacheSize(size int) CacheOption {
+	return func(c *CachingRepository) {
+		c.size = size
+	}
+}
+
+// WithCacheTTL задает время жизни записи кэша
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *CachingRepository) {
+		c.ttl = ttl
+	}
+}
+
+// WithCacheCanonicalRules задает правила канонизации; они должны совпадать с правилами репозитория
+func WithCacheCanonicalRules(rules canonical.Rules) CacheOption {
+	return func(c *CachingRepository) {
+		c.canon = rules
+	}
+}
+
+// NewCachingRepository создает кэширующий декоратор над repo
+func NewCachingRepository(repo Repository, logger *slog.Logger, opts ...CacheOption) *CachingRepository {
+	c := &CachingRepository{
+		Repository: repo,
+		logger:     logger,
+		canon:      canonical.Default(),
+		size:       DefaultUserCacheSize,
+		ttl:        DefaultUserCacheTTL,
+		lru:        list.New(),
+		byID:       make(map[uint]*cacheEntry),
+		byUsername: make(map[string]uint),
+		byEmail:    make(map[string]uint),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Stats возвращает статистику кэша
+func (c *CachingRepository) Stats() CacheStats {
+	c.mu.Lock()
+	size := len(c.byID)
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// LogStats пишет статистику кэша в лог; подходит как фоновая задача
+func (c *CachingRepository) LogStats(ctx context.Context) (int64, error) {
+	stats := c.Stats()
+	ratio := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		ratio = float64(stats.Hits) / float64(total)
+	}
+	c.logger.Info(fmt.Sprintf("user cache: %d hits, %d misses (%.1f%% hit ratio), %d evictions, %d entries",
+		stats.Hits, stats.Misses, ratio*100, stats.Evictions, stats.Size))
+	return 0, nil
+}
+
+// GetUserByID получает пользователя по ID из кэша или из нижележащего репозитория
+func (c *CachingRepository) GetUserByID(ctx context.Context, id uint) (*User, error) {
+	return c.get(ctx, userIDKey(id), func() (uint, bool) { return id, true }, func(ctx context.Context) (*User, error) {
+		return c.Repository.GetUserByID(ctx, id)
+	})
+}
+
+// GetUserByUsername получает пользователя по имени из кэша или из нижележащего репозитория
+func (c *CachingRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	key := c.canon.Username(username)
+	return c.get(ctx, usernameKey(key), func() (uint, bool) {
+		id, ok := c.byUsername[key]
+		return id, ok
+	}, func(ctx context.Context) (*User, error) {
+		return c.Repository.GetUserByUsername(ctx, username)
+	})
+}
+
+// GetUserByEmail получает пользователя по почте из кэша или из нижележащего репозитория
+func (c *CachingRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	key := c.canon.Email(email)
+	return c.get(ctx, emailKey(key), func() (uint, bool) {
+		id, ok := c.byEmail[key]
+		return id, ok
+	}, func(ctx context.Context) (*User, error) {
+		return c.Repository.GetUserByEmail(ctx, email)
+	})
+}
+
+// UpdateUser обновляет пользователя и сбрасывает его запись в кэше
+func (c *CachingRepository) UpdateUser(ctx context.Context, user *user.User, expectedVersion int64) (*User, error) {
+	defer c.invalidate(uint(user.Id))
+	return c.Repository.UpdateUser(ctx, user, expectedVersion)
+}
+
+// RehashPassword заменяет хеш пароля и сбрасывает запись пользователя в кэше
+func (c *CachingRepository) RehashPassword(ctx context.Context, id uint, previousPwdhash, pwdhash string) error {
+	defer c.invalidate(id)
+	return c.Repository.RehashPassword(ctx, id, previousPwdhash, pwdhash)
+}
+
+// DeleteUser удаляет пользователя и сбрасывает его запись в кэше
+func (c *CachingRepository) DeleteUser(ctx context.Context, id uint) error {
+	defer c.invalidate(id)
+	return c.Repository.DeleteUser(ctx, id)
+}
+
+// RestoreUser восстанавливает пользователя и сбрасывает его запись в кэше
+func (c *CachingRepository) RestoreUser(ctx context.Context, id uint) (*User, error) {
+	defer c.invalidate(id)
+	return c.Repository.RestoreUser(ctx, id)
+}
+
+// PurgeDeletedUsers окончательно удаляет пользователей через нижележащий репозиторий и сбрасывает кэш.
+// ID удаленных пользователей неизвестны, поэтому после удаления хотя бы одного кэш сбрасывается целиком.
+func (c *CachingRepository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	purger, ok := c.Repository.(DeletedUserPurger)
+	if !ok {
+		return 0, errors.New("repository does not purge deleted users")
+	}
+
+	purged, err := purger.PurgeDeletedUsers(ctx, before)
+	if purged > 0 {
+		c.invalidateAll()
+	}
+	return purged, err
+}
+
+// WithinTx выполняет fn в транзакции нижележащего репозитория. Чтения внутри fn идут мимо кэша,
+// чтобы видеть незафиксированные изменения; записи измененных пользователей сбрасываются после завершения.
+func (c *CachingRepository) WithinTx(ctx context.Context, fn func(repo Repository) error, opts ...TxOption) error {
+	var changed []uint
+	defer func() {
+		for _, id := range changed {
+			c.invalidate(id)
+		}
+	}()
+
+	return c.Repository.WithinTx(ctx, func(repo Repository) error {
+		return fn(&cacheTxRepository{Repository: repo, changed: &changed})
+	}, opts...)
+}
+
+// cacheTxRepository репозиторий единицы работы под кэшем: запоминает ID измененных пользователей
+type cacheTxRepository struct {
+	Repository
+	changed *[]uint
+}
+
+// Unwrap возвращает нижележащий репозиторий транзакции, чтобы TxVerifications нашел в нем хранилище токенов
+func (t *cacheTxRepository) Unwrap() Repository {
+	return t.Repository
+}
+
+func (t *cacheTxRepository) UpdateUser(ctx context.Context, user *user.User, expectedVersion int64) (*User, error) {
+	*t.changed = append(*t.changed, uint(user.Id))
+	return t.Repository.UpdateUser(ctx, user, expectedVersion)
+}
+
+func (t *cacheTxRepository) RehashPassword(ctx context.Context, id uint, previousPwdhash, pwdhash string) error {
+	*t.changed = append(*t.changed, id)
+	return t.Repository.RehashPassword(ctx, id, previousPwdhash, pwdhash)
+}
+
+func (t *cacheTxRepository) DeleteUser(ctx context.Context, id uint) error {
+	*t.changed = append(*t.changed, id)
+	return t.Repository.DeleteUser(ctx, id)
+}
+
+func (t *cacheTxRepository) RestoreUser(ctx context.Context, id uint) (*User, error) {
+	*t.changed = append(*t.changed, id)
+	return t.Repository.RestoreUser(ctx, id)
+}
+
+func (t *cacheTxRepository) WithinTx(ctx context.Context, fn func(repo Repository) error, opts ...TxOption) error {
+	return t.Repository.WithinTx(ctx, func(repo Repository) error {
+		return fn(&cacheTxRepository{Repository: repo, changed: t.changed})
+	}, opts...)
+}
+
+// get ищет пользователя в кэше через resolve, а при промахе загружает его через load.
+// Одновременные промахи по одному ключу объединяются в один запрос.
+func (c *CachingRepository) get(ctx context.Context, key string, resolve func() (uint, bool), load func(ctx context.Context) (*User, error)) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if usePrimary(ctx) {
+		c.misses.Add(1)
+		return c.store(ctx, c.currentGeneration(), load)
+	}
+
+	if cached := c.lookup(key, resolve); cached != nil {
+		c.hits.Add(1)
+		return cached, nil
+	}
+	c.misses.Add(1)
+
+	// Загрузка не отменяется вместе с контекстом первого вызова, чтобы не сорвать ее остальным ожидающим
+	generation := c.currentGeneration()
+	result := c.loads.DoChan(key, func() (interface{}, error) {
+		return c.store(context.WithoutCancel(ctx), generation, load)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return cloneUser(res.Val.(*User)), nil
+	}
+}
+
+// store загружает пользователя и кладет его в кэш, если с начала чтения кэш не сбрасывался
+func (c *CachingRepository) store(ctx context.Context, generation uint64, load func(ctx context.Context) (*User, error)) (*User, error) {
+	loaded, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.put(loaded)
+	}
+	return cloneUser(loaded), nil
+}
+
+// lookup возвращает копию пользователя из кэша или nil при промахе
+func (c *CachingRepository) lookup(key string, resolve func() (uint, bool)) *User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := resolve()
+	if !ok {
+		return nil
+	}
+	entry, ok := c.byID[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expiresAt) {
+		c.remove(entry)
+		c.evictions.Add(1)
+		return nil
+	}
+	// Индекс по имени или почте мог устареть после переименования
+	if key != userIDKey(id) && key != usernameKey(entry.usernameCanonical) && key != emailKey(entry.emailCanonical) {
+		return nil
+	}
+
+	c.lru.MoveToFront(entry.element)
+	return cloneUser(entry.user)
+}
+
+// put кладет пользователя в кэш и вытесняет давно неиспользованные записи сверх размера; вызывается под c.mu
+func (c *CachingRepository) put(u *User) {
+	if c.size <= 0 {
+		return
+	}
+
+	id := uint(u.Id)
+	if entry, ok := c.byID[id]; ok {
+		c.remove(entry)
+	}
+
+	// Ключи берутся из сохраненных канонических форм, а не вычисляются заново по имени и почте:
+	// у пользователя, переименованного при заполнении форм, они не совпадают.
+	// Запись без заполненной формы доступна из кэша только по ID.
+	entry := &cacheEntry{
+		user:              cloneUser(u),
+		usernameCanonical: u.UsernameCanonical,
+		emailCanonical:    u.EmailCanonical,
+		expiresAt:         time.Now().Add(c.ttl),
+	}
+	entry.element = c.lru.PushFront(id)
+	c.byID[id] = entry
+	if entry.usernameCanonical != "" {
+		c.byUsername[entry.usernameCanonical] = id
+	}
+	if entry.emailCanonical != "" {
+		c.byEmail[entry.emailCanonical] = id
+	}
+
+	for c.lru.Len() > c.size {
+		oldest := c.byID[c.lru.Back().Value.(uint)]
+		c.remove(oldest)
+		c.evictions.Add(1)
+	}
+}
+
+// remove удаляет запись вместе с ее индексами; вызывается под c.mu
+func (c *CachingRepository) remove(entry *cacheEntry) {
+	id := uint(entry.user.Id)
+	c.lru.Remove(entry.element)
+	delete(c.byID, id)
+	if c.byUsername[entry.usernameCanonical] == id {
+		delete(c.byUsername, entry.usernameCanonical)
+	}
+	if c.byEmail[entry.emailCanonical] == id {
+		delete(c.byEmail, entry.emailCanonical)
+	}
+}
+
+// invalidate сбрасывает запись пользователя и отменяет сохранение незавершенных чтений
+func (c *CachingRepository) invalidate(id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.loads.Forget(userIDKey(id))
+	if entry, ok := c.byID[id]; ok {
+		c.loads.Forget(usernameKey(entry.usernameCanonical))
+		c.loads.Forget(emailKey(entry.emailCanonical))
+		c.remove(entry)
+	}
+}
+
+// invalidateAll сбрасывает все записи и отменяет сохранение незавершенных чтений
+func (c *CachingRepository) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for id, entry := range c.byID {
+		c.loads.Forget(userIDKey(id))
+		c.loads.Forget(usernameKey(entry.usernameCanonical))
+		c.loads.Forget(emailKey(entry.emailCanonical))
+		c.remove(entry)
+	}
+}
+
+// currentGeneration возвращает текущее поколение кэша
+func (c *CachingRepository) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// cloneUser возвращает копию пользователя, чтобы вызывающий код не мог изменить запись кэша
+func cloneUser(u *User) *User {
+	return &User{
+		User:              proto.Clone(u.User).(*user.User),
+		Version:           u.Version,
+		UsernameCanonical: u.UsernameCanonical,
+		EmailCanonical:    u.EmailCanonical,
+	}
+}
@@ -0,0 +1,178 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+)
+
+// gatedRepository считает чтения по ID и задерживает их ответ до закрытия release.
+// Запись читается до ожидания, поэтому задержанный ответ может оказаться устаревшим.
+type gatedRepository struct {
+	repository.Repository
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (r *gatedRepository) GetUserByID(ctx context.Context, id uint) (*repository.User, error) {
+	u, err := r.Repository.GetUserByID(ctx, id)
+	r.calls.Add(1)
+	<-r.release
+	return u, err
+}
+
+func newGatedCache(t *testing.T) (*repository.CachingRepository, *gatedRepository, *repository.User) {
+	t.Helper()
+	inner := repository.NewMemoryRepository(repositorytest.DiscardLogger())
+	created, err := inner.CreateUser(context.Background(), &user.User{Username: "alice", Email: "alice@example.com", Pwdhash: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	gated := &gatedRepository{Repository: inner, release: make(chan struct{})}
+	return repository.NewCachingRepository(gated, repositorytest.DiscardLogger()), gated, created
+}
+
+// waitFor ждет выполнения условия, которое наступает в другой горутине
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCachingRepositoryCoalescesMisses(t *testing.T) {
+	ctx := context.Background()
+	cache, gated, created := newGatedCache(t)
+	const readers = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if u, err := cache.GetUserByID(ctx, uint(created.Id)); err != nil || u.Username != "alice" {
+				t.Errorf("GetUserByID = %v, %v; want alice", u, err)
+			}
+		}()
+	}
+
+	// Все читатели промахнулись и ждут одной загрузки
+	waitFor(t, "all readers to miss", func() bool { return cache.Stats().Misses == readers })
+	close(gated.release)
+	wg.Wait()
+
+	if calls := gated.calls.Load(); calls != 1 {
+		t.Errorf("underlying reads = %d, want concurrent misses coalesced into 1", calls)
+	}
+	if _, err := cache.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || gated.calls.Load() != 1 {
+		t.Errorf("after the load: %d hits, %d underlying reads; want the user served from the cache", stats.Hits, gated.calls.Load())
+	}
+}
+
+func TestCachingRepositoryDropsLoadsStartedBeforeInvalidation(t *testing.T) {
+	ctx := context.Background()
+	cache, gated, created := newGatedCache(t)
+
+	stale := make(chan *repository.User, 1)
+	go func() {
+		u, err := cache.GetUserByID(ctx, uint(created.Id))
+		if err != nil {
+			t.Errorf("GetUserByID: %v", err)
+		}
+		stale <- u
+	}()
+	waitFor(t, "the load to read the user", func() bool { return gated.calls.Load() == 1 })
+
+	// Изменение во время загрузки сбрасывает кэш, и прочитанная до него запись не сохраняется
+	renamed := &user.User{Id: created.Id, Username: "alice2", Email: created.Email, Pwdhash: created.Pwdhash}
+	if _, err := cache.UpdateUser(ctx, renamed, repository.AnyVersion); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	close(gated.release)
+	if u := <-stale; u == nil || u.Username != "alice" {
+		t.Fatalf("concurrent GetUserByID = %v, want the record read before the update", u)
+	}
+
+	u, err := cache.GetUserByID(ctx, uint(created.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID after the update: %v", err)
+	}
+	if u.Username != "alice2" || gated.calls.Load() != 2 {
+		t.Errorf("GetUserByID after the update = %q with %d underlying reads, want alice2 loaded again", u.Username, gated.calls.Load())
+	}
+}
+
+func TestCachingRepositoryPurgeDeletedUsers(t *testing.T) {
+	ctx := context.Background()
+	inner := repositorytest.NewSQLiteRepository(t)
+	cache := repository.NewCachingRepository(inner, repositorytest.DiscardLogger())
+
+	created := createUser(t, inner, "bob")
+	if _, err := cache.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	// Удаление другим экземпляром сервиса не сбрасывает кэш, а окончательное удаление через кэш - сбрасывает
+	if err := inner.DeleteUser(ctx, uint(created.Id)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := cache.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Fatalf("GetUserByID of the cached user: %v", err)
+	}
+	purged, err := cache.PurgeDeletedUsers(ctx, time.Now().Add(time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedUsers = %d, %v; want 1", purged, err)
+	}
+	if _, err := cache.GetUserByID(ctx, uint(created.Id)); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetUserByID after purge error = %v, want %v", err, repository.ErrUserNotFound)
+	}
+
+	// Без поддержки в нижележащем репозитории удаление не выполняется
+	memoryCache := repository.NewCachingRepository(repository.NewMemoryRepository(repositorytest.DiscardLogger()), repositorytest.DiscardLogger())
+	if _, err := memoryCache.PurgeDeletedUsers(ctx, time.Now()); err == nil {
+		t.Error("PurgeDeletedUsers over a repository without purge succeeded, want an error")
+	}
+}
+
+func TestCachingRepositoryCollidedCanonicalForms(t *testing.T) {
+	ctx := context.Background()
+	db := repositorytest.OpenMigratedSQLite(t)
+	inner := repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+
+	// Второй пользователь при заполнении форм получает формы с суффиксом ID
+	insertLegacyUser(t, db, 1, "Alice", "alice@example.com")
+	insertLegacyUser(t, db, 2, "ALICE", "Alice@Example.com")
+	if _, err := inner.BackfillCanonical(ctx); err != nil {
+		t.Fatalf("BackfillCanonical: %v", err)
+	}
+
+	cache := repository.NewCachingRepository(inner, repositorytest.DiscardLogger())
+	if _, err := cache.GetUserByID(ctx, 2); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	// Закэшированный по ID пользователь с суффиксом не занимает ключи первого пользователя
+	if u, err := cache.GetUserByUsername(ctx, "alice"); err != nil || u.Id != 1 {
+		t.Errorf("GetUserByUsername = %v, %v; want user 1", u, err)
+	}
+	if u, err := cache.GetUserByEmail(ctx, "alice@example.com"); err != nil || u.Id != 1 {
+		t.Errorf("GetUserByEmail = %v, %v; want user 1", u, err)
+	}
+	if u, err := cache.GetUserByUsername(ctx, "alice#2"); err != nil || u.Id != 2 {
+		t.Errorf("GetUserByUsername of the suffixed form = %v, %v; want user 2", u, err)
+	}
+}
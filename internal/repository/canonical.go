@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// backfillBatchSize количество пользователей, обрабатываемых за один запрос при заполнении канонических форм
+const backfillBatchSize = 500
+
+// CanonicalCollision запись, каноническая форма которой уже занята другим пользователем и сохранена с суффиксом ID
+type CanonicalCollision struct {
+	UserID            uint   // Пользователь, которому сохранена форма с суффиксом
+	Field             string // Поле: username или email
+	Value             string // Значение в том виде, как его ввели
+	Canonical         string // Каноническая форма
+	Assigned          string // Сохраненная форма: каноническая с суффиксом "#<ID>"
+	ConflictingUserID uint   // Пользователь, которому форма уже принадлежит
+}
+
+// BackfillCanonical заполняет канонические формы имени и почты у записей, где их нет.
+// Записи обрабатываются по возрастанию ID, поэтому при коллизии форму сохраняет пользователь
+// с меньшим ID, а остальным сохраняется форма с суффиксом "#<ID>", которая попадает в список коллизий.
+// С такой формой запись остается уникальной, но вход по прежнему имени или почте находит владельца формы,
+// и неудачные попытки засчитываются ему. Сам пользователь с суффиксом не может войти (см. User.PendingRename),
+// пока ему не сменят имя или почту.
+// Проверка и запись не атомарны: вызывающий выполняет заполнение в транзакции миграции
+// или под блокировкой миграций. Заполненные формы записываются в журнал изменений, если он уже создан:
+// при заполнении в миграции канонических форм журнала еще нет.
+func (r *SQLRepository) BackfillCanonical(ctx context.Context) ([]CanonicalCollision, error) {
+	var collisions []CanonicalCollision
+	var lastID uint
+	audited := r.db.WithContext(ctx).Migrator().HasTable(&GormAuditEvent{})
+	for {
+		// Проверка отмены контекста
+		select {
+		case <-ctx.Done():
+			r.logger.Error("BackfillCanonical operation canceled", slog.Any("error", ctx.Err()))
+			return collisions, ctx.Err()
+		default:
+		}
+
+		var batch []GormUser
+		err := r.db.WithContext(ctx).Unscoped().
+			Where("id > ? AND (username_canonical IS NULL OR email_canonical IS NULL)", lastID).
+			Order("id").
+			Limit(backfillBatchSize).
+			Find(&batch).Error
+		if err != nil {
+			r.logger.Error("failed to load users for canonical backfill", slog.Any("error", err))
+			return collisions, err
+		}
+		if len(batch) == 0 {
+			return collisions, nil
+		}
+
+		for i := range batch {
+			gormUser := &batch[i]
+			lastID = gormUser.ID
+
+			updates := map[string]interface{}{}
+			if gormUser.UsernameCanonical == nil {
+				collision, err := r.claimCanonical(ctx, gormUser, "username", gormUser.Username, r.canon.Username(gormUser.Username), updates)
+				if err != nil {
+					return collisions, err
+				}
+				if collision != nil {
+					collisions = append(collisions, *collision)
+				}
+			}
+			if gormUser.EmailCanonical == nil {
+				collision, err := r.claimCanonical(ctx, gormUser, "email", gormUser.Email, r.canon.Email(gormUser.Email), updates)
+				if err != nil {
+					return collisions, err
+				}
+				if collision != nil {
+					collisions = append(collisions, *collision)
+				}
+			}
+
+			// UpdateColumns не меняет updated_at и версию: содержимое записи для клиентов не изменилось
+			err := r.db.WithContext(ctx).Unscoped().Model(&GormUser{}).Where("id = ?", gormUser.ID).UpdateColumns(updates).Error
+			if err != nil {
+				r.logger.Error(fmt.Sprintf("failed to store canonical forms for user ID: %d", gormUser.ID), slog.Any("error", err))
+				return collisions, err
+			}
+			if audited {
+				if err := appendAuditChanges(ctx, r.db.WithContext(ctx), AuditActionBackfillCanonical, gormUser.ID, canonicalAuditChanges(updates)); err != nil {
+					r.logger.Error(fmt.Sprintf("failed to record canonical forms for user ID: %d", gormUser.ID), slog.Any("error", err))
+					return collisions, err
+				}
+			}
+		}
+	}
+}
+
+// canonicalAuditChanges возвращает заполненные канонические формы как изменения для журнала
+func canonicalAuditChanges(updates map[string]interface{}) map[string]AuditChange {
+	changes := make(map[string]AuditChange, len(updates))
+	for column, value := range updates {
+		changes[column] = AuditChange{After: value.(string)}
+	}
+	return changes
+}
+
+// PendingRename сообщает, что имя или почта пользователя сохранены с суффиксом "#<ID>" после коллизии
+// канонических форм. Суффикс снимается при смене имени или почты в UpdateUser.
+func (u *User) PendingRename() bool {
+	suffix := fmt.Sprintf("#%d", u.Id)
+	return assignedSuffix(u.Username, u.UsernameCanonical, suffix) || assignedSuffix(u.Email, u.EmailCanonical, suffix)
+}
+
+// assignedSuffix сообщает, что суффикс есть в сохраненной форме, но не во введенном значении
+func assignedSuffix(value, canonical, suffix string) bool {
+	return strings.HasSuffix(canonical, suffix) && !strings.HasSuffix(value, suffix)
+}
+
+// claimCanonical добавляет в updates каноническую форму, а если она занята - форму с суффиксом ID и возвращает коллизию
+func (r *SQLRepository) claimCanonical(ctx context.Context, gormUser *GormUser, field, value, canonical string, updates map[string]interface{}) (*CanonicalCollision, error) {
+	column := field + "_canonical"
+
+	ownerID, err := r.canonicalOwner(ctx, column, canonical, gormUser.ID)
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to check canonical %s for user ID: %d", field, gormUser.ID), slog.Any("error", err))
+		return nil, err
+	}
+	if ownerID == 0 {
+		updates[column] = canonical
+		return nil, nil
+	}
+
+	// Суффикс содержит ID, поэтому занят только значением, специально введенным другим пользователем
+	assigned := fmt.Sprintf("%s#%d", canonical, gormUser.ID)
+	suffixOwnerID, err := r.canonicalOwner(ctx, column, assigned, gormUser.ID)
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to check canonical %s for user ID: %d", field, gormUser.ID), slog.Any("error", err))
+		return nil, err
+	}
+	if suffixOwnerID != 0 {
+		return nil, fmt.Errorf("canonical %s %q of user ID %d is taken by user ID %d and %q by user ID %d",
+			field, canonical, gormUser.ID, ownerID, assigned, suffixOwnerID)
+	}
+
+	updates[column] = assigned
+	return &CanonicalCollision{
+		UserID:            gormUser.ID,
+		Field:             field,
+		Value:             value,
+		Canonical:         canonical,
+		Assigned:          assigned,
+		ConflictingUserID: ownerID,
+	}, nil
+}
+
+// canonicalOwner возвращает ID другого пользователя с формой canonical в column или 0, если форма свободна
+func (r *SQLRepository) canonicalOwner(ctx context.Context, column, canonical string, userID uint) (uint, error) {
+	var ownerIDs []uint
+	err := r.db.WithContext(ctx).Unscoped().Model(&GormUser{}).
+		Where(column+" = ? AND id <> ?", canonical, userID).
+		Limit(1).
+		Pluck("id", &ownerIDs).Error
+	if err != nil || len(ownerIDs) == 0 {
+		return 0, err
+	}
+	return ownerIDs[0], nil
+}
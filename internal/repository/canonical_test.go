@@ -0,0 +1,131 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/watchlist-kata/user/internal/migrate"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"gorm.io/gorm"
+)
+
+// insertLegacyUser добавляет запись так, как ее создавала версия сервиса без канонических форм
+func insertLegacyUser(t *testing.T, db *gorm.DB, id uint, username, email string) {
+	t.Helper()
+	err := db.Exec(`INSERT INTO "user" (id, username, email, pwdhash, salt) VALUES (?, ?, ?, 'hash', '')`, id, username, email).Error
+	if err != nil {
+		t.Fatalf("failed to insert legacy user %q: %v", username, err)
+	}
+}
+
+func TestCanonicalIdentityMigration(t *testing.T) {
+	ctx := context.Background()
+	db := repositorytest.OpenSQLite(t)
+
+	var collisions []repository.CanonicalCollision
+	backfill := func(ctx context.Context, tx *gorm.DB) error {
+		var err error
+		collisions, err = repository.NewSQLRepository(tx, repositorytest.DiscardLogger()).BackfillCanonical(ctx)
+		return err
+	}
+	migrator, err := migrate.New(db, repositorytest.DiscardLogger(), migrate.WithHook(4, backfill))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if _, err := migrator.To(ctx, 3); err != nil {
+		t.Fatalf("failed to migrate to version 3: %v", err)
+	}
+
+	// До канонических форм имена и адреса, различающиеся регистром, считались разными
+	insertLegacyUser(t, db, 1, "Alice", "alice@example.com")
+	insertLegacyUser(t, db, 2, "ALICE", "Alice@Example.com")
+	insertLegacyUser(t, db, 3, "bob", "bob@example.com")
+
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	// Форму сохраняет пользователь с меньшим ID, остальным она сохраняется с суффиксом ID
+	want := map[string]string{"username": "alice#2", "email": "alice@example.com#2"}
+	if len(collisions) != len(want) {
+		t.Fatalf("collisions = %+v, want %d", collisions, len(want))
+	}
+	for _, c := range collisions {
+		if c.UserID != 2 || c.ConflictingUserID != 1 || c.Assigned != want[c.Field] {
+			t.Errorf("collision = %+v, want user 2 conflicting with user 1 stored as %q", c, want[c.Field])
+		}
+	}
+
+	repo := repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+	lookups := []struct {
+		name   string
+		lookup func() (*repository.User, error)
+		wantID int64
+	}{
+		{name: "username of the earlier user", lookup: func() (*repository.User, error) { return repo.GetUserByUsername(ctx, "alice") }, wantID: 1},
+		{name: "suffixed username of the later user", lookup: func() (*repository.User, error) { return repo.GetUserByUsername(ctx, "Alice#2") }, wantID: 2},
+		{name: "email of the earlier user", lookup: func() (*repository.User, error) { return repo.GetUserByEmail(ctx, "ALICE@example.com") }, wantID: 1},
+		{name: "username without collision", lookup: func() (*repository.User, error) { return repo.GetUserByUsername(ctx, "Bob") }, wantID: 3},
+	}
+	for _, tt := range lookups {
+		u, err := tt.lookup()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if u.Id != tt.wantID {
+			t.Errorf("%s: found user %d, want %d", tt.name, u.Id, tt.wantID)
+		}
+	}
+
+	// Обновление без смены имени и почты сохраняет формы с суффиксом
+	later, err := repo.GetUserByID(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	later.Pwdhash = "new-hash"
+	if _, err := repo.UpdateUser(ctx, later.User, later.Version); err != nil {
+		t.Fatalf("UpdateUser of the later user: %v", err)
+	}
+}
+
+func TestBackfillCanonicalUnfilledRows(t *testing.T) {
+	ctx := context.Background()
+	db := repositorytest.OpenMigratedSQLite(t)
+	repo := repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+
+	// Запись, созданная прежней версией сервиса во время обновления
+	insertLegacyUser(t, db, 10, "Carol", "Carol@example.com")
+
+	// До заполнения запись находится по точному имени и адресу
+	if u, err := repo.GetUserByUsername(ctx, "Carol"); err != nil || u.Id != 10 {
+		t.Fatalf("GetUserByUsername before backfill = %v, %v; want user 10", u, err)
+	}
+	if u, err := repo.GetUserByEmail(ctx, "Carol@example.com"); err != nil || u.Id != 10 {
+		t.Fatalf("GetUserByEmail before backfill = %v, %v; want user 10", u, err)
+	}
+
+	collisions, err := repo.BackfillCanonical(repository.WithAuditInfo(ctx, repository.AuditInfo{Actor: "system"}))
+	if err != nil || len(collisions) != 0 {
+		t.Fatalf("BackfillCanonical = %+v, %v; want no collisions", collisions, err)
+	}
+	if u, err := repo.GetUserByUsername(ctx, "CAROL"); err != nil || u.Id != 10 {
+		t.Errorf("GetUserByUsername after backfill = %v, %v; want user 10", u, err)
+	}
+
+	// Заполненные формы записываются в журнал изменений
+	event := lastAuditEvent(t, repo, 10)
+	want := map[string]repository.AuditChange{
+		"username_canonical": {After: "carol"},
+		"email_canonical":    {After: "carol@example.com"},
+	}
+	if event.Action != repository.AuditActionBackfillCanonical || event.Actor != "system" || len(event.Changes) != len(want) {
+		t.Fatalf("event after backfill = %+v, want %q by system with %v", event, repository.AuditActionBackfillCanonical, want)
+	}
+	for field, change := range want {
+		if event.Changes[field] != change {
+			t.Errorf("backfill change of %s = %+v, want %+v", field, event.Changes[field], change)
+		}
+	}
+}
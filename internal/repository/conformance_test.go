@@ -0,0 +1,69 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/watchlist-kata/user/internal/migrate"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// testPostgresDSNEnv переменная окружения с DSN пустой базы для проверки PostgresRepository
+const testPostgresDSNEnv = "TEST_POSTGRES_DSN"
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return repository.NewMemoryRepository(repositorytest.DiscardLogger())
+	})
+}
+
+func TestCachingRepositoryConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return repository.NewCachingRepository(repository.NewMemoryRepository(repositorytest.DiscardLogger()), repositorytest.DiscardLogger())
+	})
+}
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return repositorytest.NewSQLiteRepository(t)
+	})
+}
+
+// TestPostgresRepositoryConformance запускается только при заданном TEST_POSTGRES_DSN.
+// Все данные пользователей в этой базе удаляются перед каждой проверкой.
+func TestPostgresRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSNEnv)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: gormLogger.Discard})
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := migrate.New(db, repositorytest.DiscardLogger())
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		if err := db.Exec(`TRUNCATE TABLE "user", audit_event RESTART IDENTITY CASCADE`).Error; err != nil {
+			t.Fatalf("failed to clean database: %v", err)
+		}
+		return repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+	})
+}
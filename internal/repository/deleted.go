@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// purgeBatchSize количество пользователей, удаляемых окончательно в одной транзакции
+const purgeBatchSize = 500
+
+// userOwnedModels модели с данными пользователя, которые удаляются вместе с ним
+var userOwnedModels = []interface{}{
+	&GormRefreshToken{},
+	&GormLoginAttempt{},
+	&GormVerificationToken{},
+	&GormUserMFA{},
+	&GormRecoveryCode{},
+	&GormSession{},
+	&GormUserRole{},
+}
+
+// DeletedUserPurger окончательно удаляет пользователей, удаленных до указанного момента
+type DeletedUserPurger interface {
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RestoreUser снимает отметку об удалении; если удаленного пользователя с таким ID нет, возвращает ErrUserNotFound
+func (r *SQLRepository) RestoreUser(ctx context.Context, id uint) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RestoreUser operation canceled for user ID: %d", id), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormUser GormUser
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var deletedUser GormUser
+		err := tx.Unscoped().Clauses(lockForUpdate).
+			Where("deleted_at IS NOT NULL").
+			First(&deletedUser, id).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		err = tx.Unscoped().Model(&GormUser{}).
+			Where("id = ?", id).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&gormUser, id).Error; err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx, AuditActionRestore, id, &deletedUser, &gormUser)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			r.logger.Warn(fmt.Sprintf("deleted user not found with ID: %d", id))
+			return nil, ErrUserNotFound
+		}
+		r.logger.Error(fmt.Sprintf("failed to restore user with ID: %d", id), slog.Any("error", err))
+		return nil, err
+	}
+
+	r.markWritten(&gormUser)
+	r.logger.Info(fmt.Sprintf("user restored successfully with ID: %d", id))
+	return convertToUser(&gormUser), nil
+}
+
+// PurgeDeletedUsers окончательно удаляет пользователей, удаленных до указанного момента, вместе с их данными
+func (r *SQLRepository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	for {
+		// Проверка отмены контекста
+		select {
+		case <-ctx.Done():
+			return purged, ctx.Err()
+		default:
+		}
+
+		var batch int64
+		err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var ids []uint
+			err := tx.Unscoped().Model(&GormUser{}).
+				Where("deleted_at < ?", before).
+				Order("id").
+				Limit(purgeBatchSize).
+				Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+
+			for _, model := range userOwnedModels {
+				if err := tx.Where("user_id IN ?", ids).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+
+			result := tx.Unscoped().Where("id IN ?", ids).Delete(&GormUser{})
+			if result.Error != nil {
+				return result.Error
+			}
+			batch = result.RowsAffected
+
+			// Окончательное удаление записывается без значений полей: запись пользователя уже не читалась
+			events := make([]*GormAuditEvent, 0, len(ids))
+			for _, id := range ids {
+				event, err := newGormAuditEvent(ctx, AuditActionPurge, id, map[string]AuditChange{})
+				if err != nil {
+					return err
+				}
+				events = append(events, event)
+			}
+			return tx.Create(&events).Error
+		})
+		if err != nil {
+			r.logger.Error("failed to purge deleted users", slog.Any("error", err))
+			return purged, err
+		}
+
+		purged += batch
+		if batch < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dialect особенности базы данных, на которые опирается SQLRepository
+type dialect struct {
+	// translateError переводит ошибки драйвера в ошибки репозитория
+	translateError func(err error) error
+	// isSerializationFailure сообщает, что транзакцию можно повторить: она не сериализовалась с параллельными
+	isSerializationFailure func(err error) bool
+	// searchUsers добавляет к запросу условие и порядок поиска пользователей по каноническому запросу
+	searchUsers func(db *gorm.DB, query string) *gorm.DB
+	// sortColumn возвращает выражение текстовой колонки для сортировки списка по байтам, как в MemoryRepository
+	sortColumn func(column string) string
+}
+
+// dialects особенности поддерживаемых баз данных по имени диалекта GORM
+var dialects = map[string]dialect{
+	"postgres": {
+		translateError:         translatePostgresError,
+		isSerializationFailure: isPostgresSerializationFailure,
+		searchUsers:            trigramSearch,
+		sortColumn:             collateC,
+	},
+	"sqlite": {
+		translateError:         translateSQLiteError,
+		isSerializationFailure: isSQLiteBusy,
+		searchUsers:            substringSearch,
+		sortColumn:             bytewiseColumn,
+	},
+}
+
+// dialectOf возвращает особенности базы данных подключения.
+// Для неизвестных диалектов ошибки не переводятся, транзакции не повторяются, а поиск идет только по подстроке.
+func dialectOf(db *gorm.DB) dialect {
+	if d, ok := dialects[db.Dialector.Name()]; ok {
+		return d
+	}
+	return dialect{
+		translateError:         func(err error) error { return err },
+		isSerializationFailure: func(error) bool { return false },
+		searchUsers:            substringSearch,
+		sortColumn:             bytewiseColumn,
+	}
+}
+
+// collateC сортирует по байтам независимо от правил сортировки базы; индексы списка созданы с тем же COLLATE
+func collateC(column string) string {
+	return column + ` COLLATE "C"`
+}
+
+// bytewiseColumn оставляет колонку как есть: SQLite по умолчанию сравнивает строки по байтам
+func bytewiseColumn(column string) string {
+	return column
+}
+
+// trigramSearch ищет по подстроке и сходству слов (pg_trgm); оба условия используют один GIN-индекс
+// по триграммам канонического имени. Результаты упорядочены по убыванию сходства, затем по ID.
+func trigramSearch(db *gorm.DB, query string) *gorm.DB {
+	return db.
+		Where(`username_canonical LIKE ? ESCAPE '\' OR ? <% username_canonical`, "%"+escapeLike(query)+"%", query).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "word_similarity(?, username_canonical) DESC, similarity(?, username_canonical) DESC, id",
+			Vars:               []interface{}{query, query},
+			WithoutParentheses: true,
+		}})
+}
+
+// substringSearch ищет только по подстроке, без допуска опечаток. Раньше идут имена, где подстрока
+// встречается ближе к началу, затем более короткие, затем по ID.
+func substringSearch(db *gorm.DB, query string) *gorm.DB {
+	return db.
+		Where(`username_canonical LIKE ? ESCAPE '\'`, "%"+escapeLike(query)+"%").
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "instr(username_canonical, ?), length(username_canonical), id",
+			Vars:               []interface{}{query},
+			WithoutParentheses: true,
+		}})
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username already exists")
+	ErrEmailTaken    = errors.New("email already exists")
+)
+
+// Коды ошибок Postgres
+const (
+	pgUniqueViolation      = "23505" // Нарушение ограничения уникальности
+	pgSerializationFailure = "40001" // Транзакция не сериализуется с параллельными
+	pgDeadlockDetected     = "40P01" // Взаимоблокировка, транзакция выбрана жертвой
+)
+
+// uniqueConstraintErrors сопоставляет ограничения уникальности таблицы пользователей с ошибками репозитория
+var uniqueConstraintErrors = map[string]error{
+	"idx_user_username_canonical": ErrUsernameTaken,
+	"idx_user_email_canonical":    ErrEmailTaken,
+}
+
+// translatePostgresError переводит нарушение известного ограничения уникальности в ошибку репозитория.
+// Остальные ошибки возвращаются без изменений.
+func translatePostgresError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+		return err
+	}
+	if translated, ok := uniqueConstraintErrors[pgErr.ConstraintName]; ok {
+		return translated
+	}
+	return err
+}
+
+// isPostgresSerializationFailure сообщает, что транзакцию Postgres откатили из-за параллельных транзакций
+func isPostgresSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected)
+}
+
+// Коды ошибок SQLite
+const (
+	sqliteBusy             = 5    // База заблокирована другой транзакцией дольше времени ожидания
+	sqliteConstraintUnique = 2067 // Расширенный код нарушения уникального индекса
+)
+
+// sqliteUniqueColumnErrors сопоставляет столбцы уникальных индексов с ошибками репозитория:
+// SQLite сообщает не имя индекса, а столбцы в виде "таблица.столбец"
+var sqliteUniqueColumnErrors = map[string]error{
+	"user.username_canonical": ErrUsernameTaken,
+	"user.email_canonical":    ErrEmailTaken,
+}
+
+// translateSQLiteError переводит нарушение известного уникального индекса в ошибку репозитория.
+// Остальные ошибки возвращаются без изменений.
+func translateSQLiteError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqliteConstraintUnique {
+		return err
+	}
+
+	// Сообщение имеет вид "... UNIQUE constraint failed: user.username_canonical (2067)"
+	_, columns, ok := strings.Cut(sqliteErr.Error(), "UNIQUE constraint failed: ")
+	if !ok {
+		return err
+	}
+	columns, _, _ = strings.Cut(columns, " (")
+	if translated, ok := sqliteUniqueColumnErrors[columns]; ok {
+		return translated
+	}
+	return err
+}
+
+// isSQLiteBusy сообщает, что транзакция SQLite не дождалась блокировки базы (включая расширенные коды SQLITE_BUSY_*)
+func isSQLiteBusy(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqliteBusy
+}
+
+// isUniqueViolation сообщает, что ошибка - нарушение уникальности имени или почты пользователя
+func isUniqueViolation(err error) bool {
+	return errors.Is(err, ErrUsernameTaken) || errors.Is(err, ErrEmailTaken)
+}
@@ -2,20 +2,154 @@ package repository
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // GormUser представляет модель пользователя в базе данных
 type GormUser struct {
-	ID        uint      `gorm:"primaryKey"`      // Уникальный идентификатор пользователя
-	Username  string    `gorm:"unique;not null"` // Имя пользователя (уникальное)
-	Email     string    `gorm:"unique;not null"` // Электронная почта (уникальная)
-	Pwdhash   string    `gorm:"not null"`        // Хеш пароля
-	Salt      string    `gorm:"not null"`        // Соль для хеширования пароля
-	CreatedAt time.Time `gorm:"autoCreateTime"`  // Дата создания
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`  // Дата обновления
+	ID        uint      `gorm:"primaryKey"`     // Уникальный идентификатор пользователя
+	Username  string    `gorm:"not null"`       // Имя пользователя в том виде, как его ввели
+	Email     string    `gorm:"not null"`       // Электронная почта в том виде, как ее ввели
+	Pwdhash   string    `gorm:"not null"`       // Хеш пароля
+	Salt      string    `gorm:"not null"`       // Соль для хеширования пароля
+	CreatedAt time.Time `gorm:"autoCreateTime"` // Дата создания
+	UpdatedAt time.Time `gorm:"autoUpdateTime"` // Дата обновления
+
+	// Канонические формы, по которым проверяется уникальность и выполняется поиск.
+	// NULL только у записей, созданных до их появления и не заполненных из-за коллизии.
+	UsernameCanonical *string `gorm:"uniqueIndex:idx_user_username_canonical"` // Имя пользователя в каноническом виде (уникальное)
+	EmailCanonical    *string `gorm:"uniqueIndex:idx_user_email_canonical"`    // Электронная почта в каноническом виде (уникальная)
+
+	Version         int64          `gorm:"not null;default:1"` // Версия записи для оптимистичной блокировки
+	EmailVerifiedAt *time.Time     // Дата подтверждения электронной почты
+	DeletedAt       gorm.DeletedAt `gorm:"index"` // Дата мягкого удаления; удаленные пользователи исключаются из запросов
 }
 
 // TableName указывает GORM использовать имя таблицы "users"
 func (GormUser) TableName() string {
 	return "user"
 }
+
+// GormRefreshToken представляет refresh-токен в базе данных
+type GormRefreshToken struct {
+	ID        uint       `gorm:"primaryKey"`           // Уникальный идентификатор записи
+	UserID    uint       `gorm:"not null;index"`       // ID владельца токена
+	FamilyID  string     `gorm:"not null;index"`       // Идентификатор цепочки ротаций
+	TokenHash string     `gorm:"uniqueIndex;not null"` // SHA-256 от токена
+	ExpiresAt time.Time  `gorm:"not null;index"`       // Момент истечения
+	RotatedAt *time.Time // Момент замены токена следующим в цепочке
+	RevokedAt *time.Time // Момент отзыва токена
+	CreatedAt time.Time  `gorm:"autoCreateTime"` // Дата создания
+}
+
+// TableName указывает GORM использовать имя таблицы "refresh_token"
+func (GormRefreshToken) TableName() string {
+	return "refresh_token"
+}
+
+// GormLoginAttempt представляет счетчик неудачных попыток входа пользователя
+type GormLoginAttempt struct {
+	UserID       uint       `gorm:"primaryKey;autoIncrement:false"` // ID пользователя
+	FailedCount  int        `gorm:"not null;default:0"`             // Количество неудачных попыток подряд
+	LastFailedAt *time.Time // Момент последней неудачной попытки
+	LockedUntil  *time.Time `gorm:"index"`          // Момент окончания блокировки
+	UpdatedAt    time.Time  `gorm:"autoUpdateTime"` // Дата обновления
+}
+
+// TableName указывает GORM использовать имя таблицы "login_attempt"
+func (GormLoginAttempt) TableName() string {
+	return "login_attempt"
+}
+
+// GormVerificationToken представляет одноразовый токен подтверждения действия пользователя
+type GormVerificationToken struct {
+	ID        uint       `gorm:"primaryKey"`             // Уникальный идентификатор записи
+	UserID    uint       `gorm:"not null;index"`         // ID пользователя
+	Purpose   string     `gorm:"not null;size:32;index"` // Назначение токена
+	TokenHash string     `gorm:"uniqueIndex;not null"`   // SHA-256 от токена
+	Email     string     `gorm:"not null"`               // Адрес почты, на который отправлен токен
+	ExpiresAt time.Time  `gorm:"not null;index"`         // Момент истечения
+	UsedAt    *time.Time // Момент использования или аннулирования
+	CreatedAt time.Time  `gorm:"autoCreateTime"` // Дата создания
+}
+
+// TableName указывает GORM использовать имя таблицы "verification_token"
+func (GormVerificationToken) TableName() string {
+	return "verification_token"
+}
+
+// GormUserMFA представляет настройки двухфакторной аутентификации пользователя
+type GormUserMFA struct {
+	UserID          uint       `gorm:"primaryKey;autoIncrement:false"` // ID пользователя
+	SecretEncrypted string     `gorm:"not null"`                       // Зашифрованный секрет TOTP
+	ConfirmedAt     *time.Time // Дата подтверждения первым кодом; до нее 2FA не действует
+	LastUsedStep    int64      `gorm:"not null;default:0"` // Последний использованный шаг TOTP
+	CreatedAt       time.Time  `gorm:"autoCreateTime"`     // Дата создания
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime"`     // Дата обновления
+}
+
+// TableName указывает GORM использовать имя таблицы "user_mfa"
+func (GormUserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// GormRecoveryCode представляет одноразовый код восстановления доступа
+type GormRecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`     // Уникальный идентификатор записи
+	UserID    uint       `gorm:"not null;index"` // ID пользователя
+	CodeHash  string     `gorm:"not null"`       // SHA-256 от кода
+	UsedAt    *time.Time // Момент использования
+	CreatedAt time.Time  `gorm:"autoCreateTime"` // Дата создания
+}
+
+// TableName указывает GORM использовать имя таблицы "recovery_code"
+func (GormRecoveryCode) TableName() string {
+	return "recovery_code"
+}
+
+// GormSession представляет сеанс входа пользователя; ID совпадает с цепочкой refresh-токенов
+type GormSession struct {
+	ID          string     `gorm:"primaryKey"`     // Идентификатор сеанса
+	UserID      uint       `gorm:"not null;index"` // ID пользователя
+	DeviceLabel string     `gorm:"size:255"`       // Название устройства, переданное клиентом
+	UserAgent   string     `gorm:"size:512"`       // User-Agent клиента
+	PeerAddress string     `gorm:"size:255"`       // Адрес клиента
+	CreatedAt   time.Time  `gorm:"autoCreateTime"` // Дата создания
+	LastSeenAt  time.Time  `gorm:"not null"`       // Момент последней активности
+	ExpiresAt   time.Time  `gorm:"not null;index"` // Момент истечения при отсутствии активности
+	RevokedAt   *time.Time // Момент отзыва сеанса
+}
+
+// TableName указывает GORM использовать имя таблицы "session"
+func (GormSession) TableName() string {
+	return "session"
+}
+
+// GormAuditEvent представляет запись журнала изменений пользователей; записи только добавляются
+type GormAuditEvent struct {
+	ID        uint      `gorm:"primaryKey"`       // Уникальный идентификатор записи
+	Actor     string    `gorm:"not null"`         // Кто выполнил изменение
+	Action    string    `gorm:"not null;size:32"` // Действие
+	UserID    uint      `gorm:"not null;index"`   // ID измененного пользователя
+	Changes   string    `gorm:"not null"`         // Измененные поля в JSON, без значений секретов
+	RequestID string    `gorm:"not null"`         // Идентификатор запроса, в котором выполнено изменение
+	CreatedAt time.Time `gorm:"autoCreateTime"`   // Момент изменения
+}
+
+// TableName указывает GORM использовать имя таблицы "audit_event"
+func (GormAuditEvent) TableName() string {
+	return "audit_event"
+}
+
+// GormUserRole представляет роль пользователя, включаемую в access-токен
+type GormUserRole struct {
+	UserID    uint      `gorm:"primaryKey"`         // ID пользователя
+	Role      string    `gorm:"primaryKey;size:64"` // Название роли
+	CreatedAt time.Time `gorm:"autoCreateTime"`     // Дата назначения
+}
+
+// TableName указывает GORM использовать имя таблицы "user_role"
+func (GormUserRole) TableName() string {
+	return "user_role"
+}
@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Размеры страницы списка пользователей
+const (
+	DefaultListUsersPageSize = 20
+	MaxListUsersPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrInvalidListOptions = errors.New("invalid list options")
+)
+
+// UserStatus состояние учетной записи для фильтрации списка
+type UserStatus string
+
+const (
+	UserStatusAny        UserStatus = ""
+	UserStatusVerified   UserStatus = "verified"   // Почта подтверждена
+	UserStatusUnverified UserStatus = "unverified" // Почта не подтверждена
+	UserStatusLocked     UserStatus = "locked"     // Вход временно заблокирован после неудачных попыток
+	UserStatusDeleted    UserStatus = "deleted"    // Удален и ожидает окончательного удаления
+)
+
+// UserSortField поле сортировки списка пользователей
+type UserSortField string
+
+const (
+	SortByID        UserSortField = "id"
+	SortByCreatedAt UserSortField = "created_at"
+	SortByUsername  UserSortField = "username"
+	SortByEmail     UserSortField = "email"
+)
+
+// ListUsersFilter условия отбора пользователей; пустые поля не ограничивают выборку
+type ListUsersFilter struct {
+	UsernamePrefix string
+	EmailPrefix    string
+	CreatedFrom    *time.Time // Включительно
+	CreatedTo      *time.Time // Не включительно
+	Status         UserStatus
+}
+
+// ListUsersOptions параметры постраничного списка пользователей
+type ListUsersOptions struct {
+	Filter     ListUsersFilter
+	SortBy     UserSortField
+	Descending bool
+	PageSize   int
+	Cursor     string // Курсор из предыдущей страницы, пусто для первой
+}
+
+// UserPage страница списка пользователей
+type UserPage struct {
+	Users      []*User
+	NextCursor string // Пусто на последней странице
+}
+
+// listCursor содержимое курсора: ключ последней записи страницы и параметры, с которыми он выдан
+type listCursor struct {
+	SortBy     UserSortField `json:"s"`
+	Descending bool          `json:"d,omitempty"`
+	Filter     string        `json:"f"`
+	Value      string        `json:"v,omitempty"`
+	ID         uint          `json:"id"`
+}
+
+// ListUsers возвращает страницу пользователей с keyset-пагинацией.
+// Сортировка всегда дополняется ID, поэтому порядок стабилен и при совпадающих значениях.
+func (r *SQLRepository) ListUsers(ctx context.Context, opts ListUsersOptions) (*UserPage, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error("ListUsers operation canceled", slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	opts, err := normalizeListUsersOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	column := string(opts.SortBy)
+	if opts.SortBy == SortByUsername || opts.SortBy == SortByEmail {
+		column = r.dialect.sortColumn(column)
+	}
+	direction, comparison := "ASC", ">"
+	if opts.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	var cursor *listCursor
+	var cursorSortValue interface{}
+	if opts.Cursor != "" {
+		if cursor, err = decodeListCursor(opts); err != nil {
+			return nil, err
+		}
+		if opts.SortBy != SortByID {
+			if cursorSortValue, err = cursorValue(opts.SortBy, cursor.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Список, как и поиск, читается с реплики без окна чтения своих записей: у него нет ключа пользователя,
+	// а отставание реплики допустимо, так как между страницами данные и так меняются.
+	// Чтение из основной базы задается WithPrimary.
+	now := time.Now()
+	var gormUsers []GormUser
+	err = r.read(ctx, nil, func(db *gorm.DB) error {
+		gormUsers = nil
+		query := r.applyListUsersFilter(db.Model(&GormUser{}), opts.Filter, now)
+		if cursor != nil {
+			if opts.SortBy == SortByID {
+				query = query.Where(fmt.Sprintf("id %s ?", comparison), cursor.ID)
+			} else {
+				query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, comparison), cursorSortValue, cursor.ID)
+			}
+		}
+
+		if opts.SortBy != SortByID {
+			query = query.Order(fmt.Sprintf("%s %s", column, direction))
+		}
+		query = query.Order(fmt.Sprintf("id %s", direction))
+
+		// Запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+		return query.Limit(opts.PageSize + 1).Find(&gormUsers).Error
+	})
+	if err != nil {
+		r.logger.Error("failed to list users", slog.Any("error", err))
+		return nil, err
+	}
+
+	page := newListPage(opts, gormUsers)
+	r.logger.Debug(fmt.Sprintf("listed %d users", len(page.Users)))
+	return page, nil
+}
+
+// newListPage формирует страницу из записей, запрошенных с одной лишней для определения следующей страницы
+func newListPage(opts ListUsersOptions, gormUsers []GormUser) *UserPage {
+	page := &UserPage{Users: make([]*User, 0, len(gormUsers))}
+	if len(gormUsers) > opts.PageSize {
+		gormUsers = gormUsers[:opts.PageSize]
+		page.NextCursor = encodeListCursor(opts, &gormUsers[len(gormUsers)-1])
+	}
+	for i := range gormUsers {
+		page.Users = append(page.Users, convertToUser(&gormUsers[i]))
+	}
+	return page
+}
+
+// normalizeListUsersOptions проверяет параметры списка и подставляет значения по умолчанию
+func normalizeListUsersOptions(opts ListUsersOptions) (ListUsersOptions, error) {
+	switch opts.SortBy {
+	case "":
+		opts.SortBy = SortByID
+	case SortByID, SortByCreatedAt, SortByUsername, SortByEmail:
+	default:
+		return opts, fmt.Errorf("%w: unknown sort field %q", ErrInvalidListOptions, opts.SortBy)
+	}
+
+	switch opts.Filter.Status {
+	case UserStatusAny, UserStatusVerified, UserStatusUnverified, UserStatusLocked, UserStatusDeleted:
+	default:
+		return opts, fmt.Errorf("%w: unknown status %q", ErrInvalidListOptions, opts.Filter.Status)
+	}
+
+	if opts.PageSize < 0 {
+		return opts, fmt.Errorf("%w: negative page size", ErrInvalidListOptions)
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = DefaultListUsersPageSize
+	}
+	if opts.PageSize > MaxListUsersPageSize {
+		opts.PageSize = MaxListUsersPageSize
+	}
+
+	return opts, nil
+}
+
+// applyListUsersFilter добавляет к запросу условия фильтра.
+// Префиксы сравниваются без учета регистра: имя по канонической форме, почта по адресу в нижнем регистре.
+func (r *SQLRepository) applyListUsersFilter(query *gorm.DB, filter ListUsersFilter, now time.Time) *gorm.DB {
+	if filter.UsernamePrefix != "" {
+		query = query.Where(`username_canonical LIKE ? ESCAPE '\'`, escapeLike(r.canon.UsernamePrefix(filter.UsernamePrefix))+"%")
+	}
+	if filter.EmailPrefix != "" {
+		query = query.Where(`LOWER(email) LIKE ? ESCAPE '\'`, escapeLike(strings.ToLower(filter.EmailPrefix))+"%")
+	}
+	if filter.CreatedFrom != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		query = query.Where("created_at < ?", *filter.CreatedTo)
+	}
+
+	switch filter.Status {
+	case UserStatusVerified:
+		query = query.Where("email_verified_at IS NOT NULL")
+	case UserStatusUnverified:
+		query = query.Where("email_verified_at IS NULL")
+	case UserStatusLocked:
+		query = query.Where("id IN (?)",
+			query.Session(&gorm.Session{NewDB: true}).Model(&GormLoginAttempt{}).Select("user_id").Where("locked_until > ?", now))
+	case UserStatusDeleted:
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	return query
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// encodeListCursor формирует непрозрачный курсор, указывающий на запись после last
+func encodeListCursor(opts ListUsersOptions, last *GormUser) string {
+	cursor := listCursor{
+		SortBy:     opts.SortBy,
+		Descending: opts.Descending,
+		Filter:     filterFingerprint(opts.Filter),
+		ID:         last.ID,
+	}
+	switch opts.SortBy {
+	case SortByCreatedAt:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case SortByUsername:
+		cursor.Value = last.Username
+	case SortByEmail:
+		cursor.Value = last.Email
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor разбирает курсор и проверяет, что он выдан для тех же сортировки и фильтра
+func decodeListCursor(opts ListUsersOptions) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.SortBy != opts.SortBy || cursor.Descending != opts.Descending || cursor.Filter != filterFingerprint(opts.Filter) {
+		return nil, fmt.Errorf("%w: cursor was issued for different list options", ErrInvalidCursor)
+	}
+	return &cursor, nil
+}
+
+// cursorValue приводит значение сортировки из курсора к типу колонки
+func cursorValue(sortBy UserSortField, value string) (interface{}, error) {
+	if sortBy != SortByCreatedAt {
+		return value, nil
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return createdAt, nil
+}
+
+// filterFingerprint возвращает короткий отпечаток фильтра для привязки к нему курсора
+func filterFingerprint(filter ListUsersFilter) string {
+	var b strings.Builder
+	b.WriteString(filter.UsernamePrefix)
+	b.WriteByte(0)
+	b.WriteString(filter.EmailPrefix)
+	b.WriteByte(0)
+	for _, t := range []*time.Time{filter.CreatedFrom, filter.CreatedTo} {
+		if t != nil {
+			b.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+		}
+		b.WriteByte(0)
+	}
+	b.WriteString(string(filter.Status))
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttempts состояние неудачных попыток входа пользователя
+type LoginAttempts struct {
+	UserID       uint
+	FailedCount  int
+	LastFailedAt *time.Time
+	LockedUntil  *time.Time
+}
+
+// Locked сообщает, что учетная запись заблокирована в момент now
+func (a *LoginAttempts) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// ErrAccountLocked возвращается RecordFailedLogin, если учетная запись уже заблокирована
+var ErrAccountLocked = errors.New("account is locked")
+
+// LockDurationFunc возвращает длительность блокировки после failedCount неудачных попыток подряд; 0 - без блокировки
+type LockDurationFunc func(failedCount int) time.Duration
+
+// LockoutRepository хранилище счетчиков неудачных попыток входа
+type LockoutRepository interface {
+	GetLoginAttempts(ctx context.Context, userID uint) (*LoginAttempts, error)
+	RecordFailedLogin(ctx context.Context, userID uint, at time.Time, resetAfter time.Duration, lockFor LockDurationFunc) (*LoginAttempts, error)
+	LockAccount(ctx context.Context, userID uint, until time.Time) error
+	ClearLoginAttempts(ctx context.Context, userID uint) error
+}
+
+// GetLoginAttempts возвращает состояние попыток входа; для пользователя без неудачных попыток возвращает пустое состояние
+func (r *SQLRepository) GetLoginAttempts(ctx context.Context, userID uint) (*LoginAttempts, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetLoginAttempts operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var attempt GormLoginAttempt
+	if err := r.db.WithContext(ctx).First(&attempt, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &LoginAttempts{UserID: userID}, nil
+		}
+		r.logger.Error(fmt.Sprintf("failed to get login attempts for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+
+	return convertToLoginAttempts(&attempt), nil
+}
+
+// RecordFailedLogin атомарно засчитывает попытку входа как неудачную. Попытка резервируется до проверки
+// пароля и снимается ClearLoginAttempts после успешного входа, поэтому параллельные попытки не обходят порог.
+// Если последняя неудача была раньше, чем resetAfter назад, счет начинается заново. Если lockFor для нового
+// счетчика возвращает ненулевую длительность, блокировка устанавливается в той же транзакции.
+// Пока учетная запись заблокирована, счетчик не растет, а вместе с текущим состоянием возвращается ErrAccountLocked.
+func (r *SQLRepository) RecordFailedLogin(ctx context.Context, userID uint, at time.Time, resetAfter time.Duration, lockFor LockDurationFunc) (*LoginAttempts, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RecordFailedLogin operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var attempt GormLoginAttempt
+	var locked bool
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Вставка или обновление блокирует строку до конца транзакции, параллельные попытки ждут ее завершения
+		record := GormLoginAttempt{UserID: userID, FailedCount: 1, LastFailedAt: &at}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failed_count": gorm.Expr(
+					"CASE WHEN login_attempt.locked_until > ? THEN login_attempt.failed_count "+
+						"WHEN login_attempt.last_failed_at IS NULL OR login_attempt.last_failed_at < ? THEN 1 "+
+						"ELSE login_attempt.failed_count + 1 END",
+					at, at.Add(-resetAfter)),
+				"last_failed_at": gorm.Expr(
+					"CASE WHEN login_attempt.locked_until > ? THEN login_attempt.last_failed_at ELSE ? END", at, at),
+				"updated_at": at,
+			}),
+		}).Create(&record).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&attempt, userID).Error; err != nil {
+			return err
+		}
+
+		state := convertToLoginAttempts(&attempt)
+		if state.Locked(at) {
+			locked = true
+			return nil
+		}
+		if d := lockFor(attempt.FailedCount); d > 0 {
+			until := at.Add(d)
+			if err := tx.Model(&GormLoginAttempt{}).Where("user_id = ?", userID).Update("locked_until", until).Error; err != nil {
+				return err
+			}
+			attempt.LockedUntil = &until
+			r.logger.Warn(fmt.Sprintf("account locked until %s for user ID: %d", until.Format(time.RFC3339), userID))
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to record failed login for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+
+	if locked {
+		return convertToLoginAttempts(&attempt), ErrAccountLocked
+	}
+	return convertToLoginAttempts(&attempt), nil
+}
+
+// LockAccount блокирует вход пользователя до указанного момента
+func (r *SQLRepository) LockAccount(ctx context.Context, userID uint, until time.Time) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("LockAccount operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormLoginAttempt{}).Where("user_id = ?", userID).Update("locked_until", until)
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to lock account for user ID: %d", userID), slog.Any("error", result.Error))
+		return result.Error
+	}
+
+	r.logger.Warn(fmt.Sprintf("account locked until %s for user ID: %d", until.Format(time.RFC3339), userID))
+	return nil
+}
+
+// ClearLoginAttempts сбрасывает счетчик неудачных попыток и снимает блокировку
+func (r *SQLRepository) ClearLoginAttempts(ctx context.Context, userID uint) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("ClearLoginAttempts operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	if err := r.db.WithContext(ctx).Delete(&GormLoginAttempt{}, userID).Error; err != nil {
+		r.logger.Error(fmt.Sprintf("failed to clear login attempts for user ID: %d", userID), slog.Any("error", err))
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("login attempts cleared for user ID: %d", userID))
+	return nil
+}
+
+// convertToLoginAttempts преобразует GormLoginAttempt в LoginAttempts
+func convertToLoginAttempts(attempt *GormLoginAttempt) *LoginAttempts {
+	return &LoginAttempts{
+		UserID:       attempt.UserID,
+		FailedCount:  attempt.FailedCount,
+		LastFailedAt: attempt.LastFailedAt,
+		LockedUntil:  attempt.LockedUntil,
+	}
+}
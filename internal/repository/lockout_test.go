@@ -0,0 +1,113 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+)
+
+// lockAfter блокирует учетную запись на минуту, начиная с threshold неудачных попыток
+func lockAfter(threshold int) repository.LockDurationFunc {
+	return func(failedCount int) time.Duration {
+		if failedCount < threshold {
+			return 0
+		}
+		return time.Minute
+	}
+}
+
+func TestRecordFailedLogin(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	now := time.Now()
+
+	for i := 1; i <= 3; i++ {
+		attempts, err := repo.RecordFailedLogin(ctx, 1, now, time.Hour, lockAfter(3))
+		if err != nil {
+			t.Fatalf("attempt %d: RecordFailedLogin: %v", i, err)
+		}
+		if attempts.FailedCount != i {
+			t.Errorf("attempt %d: FailedCount = %d", i, attempts.FailedCount)
+		}
+		if locked := attempts.Locked(now); locked != (i == 3) {
+			t.Errorf("attempt %d: Locked = %v", i, locked)
+		}
+	}
+
+	// Пока учетная запись заблокирована, попытки не засчитываются
+	attempts, err := repo.RecordFailedLogin(ctx, 1, now.Add(time.Second), time.Hour, lockAfter(3))
+	if !errors.Is(err, repository.ErrAccountLocked) {
+		t.Fatalf("RecordFailedLogin while locked error = %v, want %v", err, repository.ErrAccountLocked)
+	}
+	if attempts == nil || attempts.FailedCount != 3 || attempts.LockedUntil == nil {
+		t.Fatalf("RecordFailedLogin while locked = %+v, want the locked state with 3 failures", attempts)
+	}
+
+	// После окончания блокировки счет продолжается
+	attempts, err = repo.RecordFailedLogin(ctx, 1, now.Add(2*time.Minute), time.Hour, lockAfter(3))
+	if err != nil {
+		t.Fatalf("RecordFailedLogin after lock: %v", err)
+	}
+	if attempts.FailedCount != 4 {
+		t.Errorf("FailedCount after lock = %d, want 4", attempts.FailedCount)
+	}
+
+	// После периода без неудач счет начинается заново
+	attempts, err = repo.RecordFailedLogin(ctx, 1, now.Add(3*time.Hour), time.Hour, lockAfter(3))
+	if err != nil {
+		t.Fatalf("RecordFailedLogin after reset period: %v", err)
+	}
+	if attempts.FailedCount != 1 {
+		t.Errorf("FailedCount after reset period = %d, want 1", attempts.FailedCount)
+	}
+
+	if err := repo.ClearLoginAttempts(ctx, 1); err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+	attempts, err = repo.GetLoginAttempts(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetLoginAttempts: %v", err)
+	}
+	if attempts.FailedCount != 0 || attempts.LockedUntil != nil {
+		t.Errorf("GetLoginAttempts after clear = %+v", attempts)
+	}
+}
+
+func TestRecordFailedLoginConcurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	const threshold, workers = 3, 20
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		accepted int
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.RecordFailedLogin(ctx, 1, time.Now(), time.Hour, lockAfter(threshold))
+			if err != nil && !errors.Is(err, repository.ErrAccountLocked) {
+				t.Errorf("RecordFailedLogin: %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Параллельные попытки резервируются по одной, и после порога остальные отклоняются
+	if accepted != threshold {
+		t.Errorf("accepted %d concurrent attempts, want %d", accepted, threshold)
+	}
+}
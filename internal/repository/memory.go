@@ -0,0 +1,594 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/canonical"
+	"gorm.io/gorm"
+)
+
+// MemoryRepository потокобезопасная реализация Repository в памяти для тестов и локального запуска.
+// Повторяет поведение SQLRepository: уникальность канонических имени и почты (включая удаленных
+// пользователей), мягкое удаление, версии записей, автоматические ID и даты. Данные теряются при остановке.
+// Блокировок входа в памяти нет, поэтому фильтр по состоянию locked всегда возвращает пустой список.
+type MemoryRepository struct {
+	logger *slog.Logger
+	canon  canonical.Rules
+
+	mu     sync.RWMutex
+	users  map[uint]*GormUser
+	nextID uint
+}
+
+// MemoryOption задает необязательные параметры MemoryRepository
+type MemoryOption func(*MemoryRepository)
+
+// WithMemoryCanonicalRules задает правила приведения имен и адресов почты к каноническому виду
+func WithMemoryCanonicalRules(rules canonical.Rules) MemoryOption {
+	return func(r *MemoryRepository) {
+		r.canon = rules
+	}
+}
+
+// NewMemoryRepository создает пустой репозиторий в памяти
+func NewMemoryRepository(logger *slog.Logger, opts ...MemoryOption) *MemoryRepository {
+	r := &MemoryRepository{
+		logger: logger,
+		canon:  canonical.Default(),
+		users:  make(map[uint]*GormUser),
+		nextID: 1,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// now возвращает текущее время с точностью Postgres, чтобы курсоры вели себя одинаково
+func (r *MemoryRepository) now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// CreateUser создает нового пользователя.
+// Если имя или почта заняты, возвращает ErrUsernameTaken или ErrEmailTaken.
+func (r *MemoryRepository) CreateUser(ctx context.Context, user *user.User) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if err := validateUser(user); err != nil {
+		return nil, err
+	}
+
+	usernameCanonical := r.canon.Username(user.Username)
+	emailCanonical := r.canon.Email(user.Email)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUnique(0, usernameCanonical, emailCanonical); err != nil {
+		return nil, err
+	}
+
+	now := r.now()
+	gormUser := &GormUser{
+		ID:                r.nextID,
+		Username:          user.Username,
+		Email:             user.Email,
+		Pwdhash:           user.Pwdhash,
+		Salt:              user.Salt,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		UsernameCanonical: &usernameCanonical,
+		EmailCanonical:    &emailCanonical,
+		Version:           1,
+	}
+	r.users[gormUser.ID] = gormUser
+	r.nextID++
+
+	r.logger.Debug(fmt.Sprintf("user created in memory with ID: %d", gormUser.ID))
+	return convertToUser(gormUser), nil
+}
+
+// GetUserByID получает пользователя по ID
+func (r *MemoryRepository) GetUserByID(ctx context.Context, id uint) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt.Valid {
+		return nil, ErrUserNotFound
+	}
+	return convertToUser(u), nil
+}
+
+// GetUserByUsername получает пользователя по имени без учета регистра и формы записи символов
+func (r *MemoryRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	usernameCanonical := r.canon.Username(username)
+	return r.find(ctx, func(u *GormUser) bool { return equalCanonical(u.UsernameCanonical, usernameCanonical) })
+}
+
+// GetUserByEmail получает пользователя по электронной почте с учетом правил канонизации адресов
+func (r *MemoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	emailCanonical := r.canon.Email(email)
+	return r.find(ctx, func(u *GormUser) bool { return equalCanonical(u.EmailCanonical, emailCanonical) })
+}
+
+// GetUsersByIDs получает пользователей в порядке запроса; ID отсутствующих и удаленных возвращаются отдельно
+func (r *MemoryRepository) GetUsersByIDs(ctx context.Context, ids []uint) ([]*User, []uint, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	unique, err := uniqueIDs(ids)
+	if err != nil || len(unique) == 0 {
+		return nil, nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var gormUsers []GormUser
+	for _, id := range unique {
+		if u, ok := r.users[id]; ok && !u.DeletedAt.Valid {
+			gormUsers = append(gormUsers, *u)
+		}
+	}
+	users, missing := orderUsersByID(unique, gormUsers)
+	return users, missing, nil
+}
+
+// GetUsersByUsernames получает пользователей по именам в порядке запроса; ненайденные имена возвращаются отдельно
+func (r *MemoryRepository) GetUsersByUsernames(ctx context.Context, usernames []string) ([]*User, []string, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	unique, err := uniqueUsernames(r.canon, usernames)
+	if err != nil || len(unique) == 0 {
+		return nil, nil, err
+	}
+
+	requested := make(map[string]bool, len(unique))
+	for _, u := range unique {
+		requested[u.canonical] = true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var gormUsers []GormUser
+	for _, u := range r.users {
+		if !u.DeletedAt.Valid && u.UsernameCanonical != nil && requested[*u.UsernameCanonical] {
+			gormUsers = append(gormUsers, *u)
+		}
+	}
+	users, missing := orderUsersByUsername(unique, gormUsers)
+	return users, missing, nil
+}
+
+// UpdateUser обновляет пользователя, если версия записи равна expectedVersion (AnyVersion отключает проверку).
+// Каждое обновление увеличивает версию; смена почты сбрасывает ее подтверждение.
+func (r *MemoryRepository) UpdateUser(ctx context.Context, user *user.User, expectedVersion int64) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	usernameCanonical := r.canon.Username(user.Username)
+	emailCanonical := r.canon.Email(user.Email)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[uint(user.Id)]
+	if !ok || existing.DeletedAt.Valid {
+		return nil, ErrUserNotFound
+	}
+	if expectedVersion != AnyVersion && existing.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	if err := r.checkUnique(existing.ID, usernameCanonical, emailCanonical); err != nil {
+		return nil, err
+	}
+
+	updated := *existing
+	if updated.Email != user.Email {
+		updated.EmailVerifiedAt = nil
+	}
+	updated.Username = user.Username
+	updated.Email = user.Email
+	updated.UsernameCanonical = &usernameCanonical
+	updated.EmailCanonical = &emailCanonical
+	updated.Pwdhash = user.Pwdhash
+	updated.Salt = user.Salt
+	updated.Version++
+	updated.UpdatedAt = r.now()
+	r.users[updated.ID] = &updated
+
+	r.logger.Debug(fmt.Sprintf("user updated in memory with ID: %d", updated.ID))
+	return convertToUser(&updated), nil
+}
+
+// RehashPassword заменяет хеш пароля, если он все еще равен previousPwdhash; версия не меняется
+func (r *MemoryRepository) RehashPassword(ctx context.Context, id uint, previousPwdhash, pwdhash string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[id]
+	if !ok || existing.DeletedAt.Valid || existing.Pwdhash != previousPwdhash {
+		return ErrUserNotFound
+	}
+
+	rehashed := *existing
+	rehashed.Pwdhash = pwdhash
+	rehashed.Salt = ""
+	r.users[id] = &rehashed
+
+	r.logger.Debug(fmt.Sprintf("password rehashed in memory for user ID: %d", id))
+	return nil
+}
+
+// DeleteUser помечает пользователя удаленным
+func (r *MemoryRepository) DeleteUser(ctx context.Context, id uint) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[id]
+	if !ok || existing.DeletedAt.Valid {
+		return ErrUserNotFound
+	}
+
+	deleted := *existing
+	deleted.DeletedAt = gorm.DeletedAt{Time: r.now(), Valid: true}
+	r.users[id] = &deleted
+
+	r.logger.Debug(fmt.Sprintf("user deleted in memory with ID: %d", id))
+	return nil
+}
+
+// RestoreUser снимает отметку об удалении; если удаленного пользователя с таким ID нет, возвращает ErrUserNotFound
+func (r *MemoryRepository) RestoreUser(ctx context.Context, id uint) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.users[id]
+	if !ok || !existing.DeletedAt.Valid {
+		return nil, ErrUserNotFound
+	}
+
+	restored := *existing
+	restored.DeletedAt = gorm.DeletedAt{}
+	r.users[id] = &restored
+
+	r.logger.Debug(fmt.Sprintf("user restored in memory with ID: %d", id))
+	return convertToUser(&restored), nil
+}
+
+// ListUsers возвращает страницу пользователей с тем же порядком и курсорами, что и SQLRepository
+func (r *MemoryRepository) ListUsers(ctx context.Context, opts ListUsersOptions) (*UserPage, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	opts, err := normalizeListUsersOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var after *GormUser
+	if opts.Cursor != "" {
+		cursor, err := decodeListCursor(opts)
+		if err != nil {
+			return nil, err
+		}
+		after, err = cursorUser(opts.SortBy, cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.RLock()
+	var gormUsers []GormUser
+	for _, u := range r.users {
+		if r.matchesFilter(u, opts.Filter) {
+			gormUsers = append(gormUsers, *u)
+		}
+	}
+	r.mu.RUnlock()
+
+	less := func(a, b *GormUser) bool {
+		c := compareSortField(opts.SortBy, a, b)
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if opts.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(gormUsers, func(i, j int) bool { return less(&gormUsers[i], &gormUsers[j]) })
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(gormUsers), func(i int) bool { return less(after, &gormUsers[i]) })
+	}
+	end := min(start+opts.PageSize+1, len(gormUsers))
+
+	return newListPage(opts, gormUsers[start:end]), nil
+}
+
+// SearchUsers ищет пользователей по подстроке имени с допуском опечаток. Сходство считается по триграммам,
+// как в pg_trgm, но упрощенно, поэтому порядок результатов может отличаться от SQLRepository.
+func (r *MemoryRepository) SearchUsers(ctx context.Context, opts SearchUsersOptions) (*UserPage, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	query, offset, pageSize, err := searchWindow(r.canon, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize == 0 {
+		return &UserPage{Users: []*User{}}, nil
+	}
+
+	type match struct {
+		user  GormUser
+		score float64
+	}
+	var matches []match
+	queryTrigrams := trigrams(query)
+
+	r.mu.RLock()
+	for _, u := range r.users {
+		if u.DeletedAt.Valid || u.UsernameCanonical == nil {
+			continue
+		}
+		score := trigramSimilarity(queryTrigrams, trigrams(*u.UsernameCanonical))
+		if strings.Contains(*u.UsernameCanonical, query) {
+			score += 1
+		} else if score < trigramSimilarityThreshold {
+			continue
+		}
+		matches = append(matches, match{user: *u, score: score})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].user.ID < matches[j].user.ID
+	})
+
+	var gormUsers []GormUser
+	for i := offset; i < len(matches) && i <= offset+pageSize; i++ {
+		gormUsers = append(gormUsers, matches[i].user)
+	}
+	return newSearchPage(query, offset, pageSize, gormUsers), nil
+}
+
+// WithinTx выполняет fn над копией данных и сохраняет ее, только если fn завершилась без ошибки.
+// Транзакции выполняются по очереди и на время выполнения блокируют остальные обращения к репозиторию,
+// поэтому внутри fn можно использовать только переданный repo. Уровень изоляции всегда сериализуемый,
+// повторы не нужны.
+func (r *MemoryRepository) WithinTx(ctx context.Context, fn func(repo Repository) error, opts ...TxOption) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Записи пользователей заменяются при изменении, а не меняются на месте, поэтому копии карты достаточно
+	tx := &MemoryRepository{
+		logger: r.logger,
+		canon:  r.canon,
+		users:  maps.Clone(r.users),
+		nextID: r.nextID,
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	r.users = tx.users
+	r.nextID = tx.nextID
+	return nil
+}
+
+// find возвращает неудаленного пользователя, удовлетворяющего условию, или ErrUserNotFound
+func (r *MemoryRepository) find(ctx context.Context, match func(u *GormUser) bool) (*User, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if !u.DeletedAt.Valid && match(u) {
+			return convertToUser(u), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// checkUnique проверяет, что каноническое имя и почта не заняты другими пользователями, включая удаленных;
+// вызывается под r.mu
+func (r *MemoryRepository) checkUnique(id uint, usernameCanonical, emailCanonical string) error {
+	for _, u := range r.users {
+		if u.ID == id {
+			continue
+		}
+		if equalCanonical(u.UsernameCanonical, usernameCanonical) {
+			return ErrUsernameTaken
+		}
+		if equalCanonical(u.EmailCanonical, emailCanonical) {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// matchesFilter проверяет пользователя на соответствие фильтру списка
+func (r *MemoryRepository) matchesFilter(u *GormUser, filter ListUsersFilter) bool {
+	if u.DeletedAt.Valid != (filter.Status == UserStatusDeleted) {
+		return false
+	}
+	if filter.UsernamePrefix != "" &&
+		(u.UsernameCanonical == nil || !strings.HasPrefix(*u.UsernameCanonical, r.canon.UsernamePrefix(filter.UsernamePrefix))) {
+		return false
+	}
+	if filter.EmailPrefix != "" && !strings.HasPrefix(strings.ToLower(u.Email), strings.ToLower(filter.EmailPrefix)) {
+		return false
+	}
+	if filter.CreatedFrom != nil && u.CreatedAt.Before(*filter.CreatedFrom) {
+		return false
+	}
+	if filter.CreatedTo != nil && !u.CreatedAt.Before(*filter.CreatedTo) {
+		return false
+	}
+
+	switch filter.Status {
+	case UserStatusVerified:
+		return u.EmailVerifiedAt != nil
+	case UserStatusUnverified:
+		return u.EmailVerifiedAt == nil
+	case UserStatusLocked:
+		return false
+	}
+	return true
+}
+
+// cursorUser восстанавливает из курсора ключ последней записи страницы
+func cursorUser(sortBy UserSortField, cursor *listCursor) (*GormUser, error) {
+	u := &GormUser{ID: cursor.ID}
+	switch sortBy {
+	case SortByCreatedAt:
+		value, err := cursorValue(sortBy, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		u.CreatedAt = value.(time.Time)
+	case SortByUsername:
+		u.Username = cursor.Value
+	case SortByEmail:
+		u.Email = cursor.Value
+	}
+	return u, nil
+}
+
+// compareSortField сравнивает пользователей по полю сортировки без учета ID
+func compareSortField(sortBy UserSortField, a, b *GormUser) int {
+	switch sortBy {
+	case SortByCreatedAt:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case SortByUsername:
+		return strings.Compare(a.Username, b.Username)
+	case SortByEmail:
+		return strings.Compare(a.Email, b.Email)
+	}
+	return 0
+}
+
+// equalCanonical сравнивает каноническую колонку с значением; NULL не равен ничему
+func equalCanonical(column *string, value string) bool {
+	return column != nil && *column == value
+}
+
+// trigramSimilarityThreshold порог сходства по умолчанию в pg_trgm
+const trigramSimilarityThreshold = 0.3
+
+// trigrams возвращает множество триграмм строки так же, как pg_trgm: каждое слово дополняется
+// двумя пробелами в начале и одним в конце
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return !isWordRune(r) }) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// isWordRune сообщает, входит ли символ в слово для разбиения на триграммы; как и в pg_trgm, это буквы и цифры
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// trigramSimilarity возвращает отношение общих триграмм ко всем триграммам двух строк
+func trigramSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range a {
+		if b[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
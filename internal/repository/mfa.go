@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrMFANotFound = errors.New("two-factor authentication not enrolled")
+
+// MFA настройки двухфакторной аутентификации пользователя
+type MFA struct {
+	UserID          uint
+	SecretEncrypted string
+	ConfirmedAt     *time.Time
+	LastUsedStep    int64
+}
+
+// Enabled сообщает, что 2FA подтверждена и действует при входе
+func (m *MFA) Enabled() bool {
+	return m.ConfirmedAt != nil
+}
+
+// MFARepository хранилище секретов TOTP и кодов восстановления
+type MFARepository interface {
+	GetMFA(ctx context.Context, userID uint) (*MFA, error)
+	SaveMFAEnrollment(ctx context.Context, userID uint, secretEncrypted string) error
+	ConfirmMFA(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (bool, error)
+	DeleteMFA(ctx context.Context, userID uint) error
+}
+
+// GetMFA возвращает настройки 2FA пользователя или ErrMFANotFound
+func (r *SQLRepository) GetMFA(ctx context.Context, userID uint) (*MFA, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetMFA operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormMFA GormUserMFA
+	if err := r.db.WithContext(ctx).First(&gormMFA, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFANotFound
+		}
+		r.logger.Error(fmt.Sprintf("failed to get MFA settings for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+
+	return &MFA{
+		UserID:          gormMFA.UserID,
+		SecretEncrypted: gormMFA.SecretEncrypted,
+		ConfirmedAt:     gormMFA.ConfirmedAt,
+		LastUsedStep:    gormMFA.LastUsedStep,
+	}, nil
+}
+
+// SaveMFAEnrollment сохраняет неподтвержденный секрет, заменяя предыдущую незавершенную попытку.
+// Подтвержденная 2FA не перезаписывается.
+func (r *SQLRepository) SaveMFAEnrollment(ctx context.Context, userID uint, secretEncrypted string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("SaveMFAEnrollment operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	record := GormUserMFA{UserID: userID, SecretEncrypted: secretEncrypted}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "user_mfa.confirmed_at IS NULL"},
+		}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret_encrypted", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to save MFA enrollment for user ID: %d", userID), slog.Any("error", err))
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("MFA enrollment started for user ID: %d", userID))
+	return nil
+}
+
+// ConfirmMFA включает 2FA, заменяет коды восстановления и записывает событие журнала в одной транзакции
+func (r *SQLRepository) ConfirmMFA(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("ConfirmMFA operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		confirmedAt := time.Now()
+		result := tx.Model(&GormUserMFA{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": confirmedAt, "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFANotFound
+		}
+		if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+			return err
+		}
+		// Коды восстановления секретны, поэтому в журнале отмечается только их выдача
+		return appendAuditChanges(ctx, tx, AuditActionEnableMFA, userID, map[string]AuditChange{
+			"mfa_confirmed_at": {After: formatAuditTime(&confirmedAt)},
+			"recovery_codes":   {Redacted: true},
+		})
+	})
+	if err != nil {
+		if !errors.Is(err, ErrMFANotFound) {
+			r.logger.Error(fmt.Sprintf("failed to confirm MFA for user ID: %d", userID), slog.Any("error", err))
+		}
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("MFA enabled for user ID: %d", userID))
+	return nil
+}
+
+// UseTOTPStep запоминает использованный шаг TOTP; возвращает false, если этот или более поздний шаг уже использован
+func (r *SQLRepository) UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("UseTOTPStep operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return false, ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormUserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to store TOTP step for user ID: %d", userID), slog.Any("error", result.Error))
+		return false, result.Error
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// UseRecoveryCode помечает код восстановления использованным; возвращает false, если код не найден или уже использован
+func (r *SQLRepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (bool, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("UseRecoveryCode operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return false, ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to use recovery code for user ID: %d", userID), slog.Any("error", result.Error))
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		r.logger.Warn(fmt.Sprintf("recovery code used for user ID: %d", userID))
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteMFA отключает 2FA, удаляет коды восстановления и записывает событие журнала, если 2FA была подключена
+func (r *SQLRepository) DeleteMFA(ctx context.Context, userID uint) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("DeleteMFA operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var mfa []GormUserMFA
+		if err := tx.Clauses(lockForUpdate).Where("user_id = ?", userID).Find(&mfa).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&GormRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&GormUserMFA{}, userID).Error; err != nil {
+			return err
+		}
+		if len(mfa) == 0 {
+			return nil
+		}
+		// Секрет и коды восстановления в журнал не попадают
+		return appendAuditChanges(ctx, tx, AuditActionDisableMFA, userID, map[string]AuditChange{
+			"mfa_confirmed_at": {Before: formatAuditTime(mfa[0].ConfirmedAt)},
+			"totp_secret":      {Redacted: true},
+			"recovery_codes":   {Redacted: true},
+		})
+	})
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to delete MFA settings for user ID: %d", userID), slog.Any("error", err))
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("MFA disabled for user ID: %d", userID))
+	return nil
+}
+
+// replaceRecoveryCodes удаляет прежние коды восстановления и сохраняет новые
+func replaceRecoveryCodes(tx *gorm.DB, userID uint, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&GormRecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+
+	codes := make([]GormRecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, GormRecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return tx.Create(&codes).Error
+}
@@ -0,0 +1,63 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+)
+
+// lastAuditEvent возвращает последнее событие журнала пользователя
+func lastAuditEvent(t *testing.T, repo *repository.SQLRepository, userID uint) *repository.AuditEvent {
+	t.Helper()
+	page, err := repo.ListAuditEvents(context.Background(), repository.ListAuditEventsOptions{UserID: userID})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(page.Events) == 0 {
+		t.Fatalf("no audit events for user %d", userID)
+	}
+	return page.Events[0]
+}
+
+func TestMFAAuditEvents(t *testing.T) {
+	ctx := repository.WithAuditInfo(context.Background(), repository.AuditInfo{Actor: "user:1", RequestID: "req-1"})
+	repo := repositorytest.NewSQLiteRepository(t)
+	id := uint(createUser(t, repo, "alice").Id)
+
+	if err := repo.SaveMFAEnrollment(ctx, id, "v1:encrypted-secret"); err != nil {
+		t.Fatalf("SaveMFAEnrollment: %v", err)
+	}
+	if err := repo.ConfirmMFA(ctx, id, 1, []string{"code-hash"}); err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+	enabled := lastAuditEvent(t, repo, id)
+	if enabled.Action != repository.AuditActionEnableMFA || enabled.Actor != "user:1" || enabled.RequestID != "req-1" {
+		t.Fatalf("event after ConfirmMFA = %+v, want %q by user:1 in req-1", enabled, repository.AuditActionEnableMFA)
+	}
+	if enabled.Changes["mfa_confirmed_at"].After == "" || enabled.Changes["recovery_codes"] != (repository.AuditChange{Redacted: true}) {
+		t.Errorf("ConfirmMFA changes = %+v, want the confirmation time and redacted recovery codes", enabled.Changes)
+	}
+
+	if err := repo.DeleteMFA(ctx, id); err != nil {
+		t.Fatalf("DeleteMFA: %v", err)
+	}
+	disabled := lastAuditEvent(t, repo, id)
+	if disabled.Action != repository.AuditActionDisableMFA {
+		t.Fatalf("event after DeleteMFA = %+v, want %q", disabled, repository.AuditActionDisableMFA)
+	}
+	if disabled.Changes["mfa_confirmed_at"].Before != enabled.Changes["mfa_confirmed_at"].After ||
+		disabled.Changes["totp_secret"] != (repository.AuditChange{Redacted: true}) ||
+		disabled.Changes["recovery_codes"] != (repository.AuditChange{Redacted: true}) {
+		t.Errorf("DeleteMFA changes = %+v, want the confirmation time and a redacted secret and recovery codes", disabled.Changes)
+	}
+
+	// Отключение без настроенной 2FA ничего не меняет и не записывается
+	if err := repo.DeleteMFA(ctx, id); err != nil {
+		t.Fatalf("DeleteMFA without MFA: %v", err)
+	}
+	if event := lastAuditEvent(t, repo, id); event.ID != disabled.ID {
+		t.Errorf("DeleteMFA without MFA wrote event %+v", event)
+	}
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenReused   = errors.New("refresh token already rotated or revoked")
+)
+
+// RefreshToken представляет сохраненный refresh-токен; сам токен хранится только в виде хеша
+type RefreshToken struct {
+	ID        uint
+	UserID    uint
+	FamilyID  string
+	TokenHash string
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// Active сообщает, что токен не заменен и не отозван
+func (t *RefreshToken) Active() bool {
+	return t.RotatedAt == nil && t.RevokedAt == nil
+}
+
+// RefreshTokenRepository хранилище refresh-токенов
+type RefreshTokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id uint, next *RefreshToken) (*RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID uint) error
+	PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// CreateRefreshToken сохраняет новый refresh-токен
+func (r *SQLRepository) CreateRefreshToken(ctx context.Context, token *RefreshToken) (*RefreshToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("CreateRefreshToken operation canceled for user ID: %d", token.UserID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	gormToken := convertToGormRefreshToken(token)
+	if err := r.db.WithContext(ctx).Create(gormToken).Error; err != nil {
+		r.logger.Error(fmt.Sprintf("failed to create refresh token for user ID: %d", token.UserID), slog.Any("error", err))
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("refresh token created for user ID: %d", token.UserID))
+	return convertToRefreshToken(gormToken), nil
+}
+
+// GetRefreshToken получает refresh-токен по хешу
+func (r *SQLRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error("GetRefreshToken operation canceled", slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormToken GormRefreshToken
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&gormToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		r.logger.Error("failed to get refresh token", slog.Any("error", err))
+		return nil, err
+	}
+
+	return convertToRefreshToken(&gormToken), nil
+}
+
+// RotateRefreshToken помечает токен замененным и сохраняет следующий токен цепочки в одной транзакции.
+// Если токен уже заменен или отозван, возвращает ErrRefreshTokenReused.
+func (r *SQLRepository) RotateRefreshToken(ctx context.Context, id uint, next *RefreshToken) (*RefreshToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RotateRefreshToken operation canceled for token ID: %d", id), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	gormToken := convertToGormRefreshToken(next)
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Условное обновление защищает от одновременной ротации одного и того же токена
+		result := tx.Model(&GormRefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", id).
+			Update("rotated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+		return tx.Create(gormToken).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrRefreshTokenReused) {
+			r.logger.Error(fmt.Sprintf("failed to rotate refresh token ID: %d", id), slog.Any("error", err))
+		}
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("refresh token rotated for user ID: %d", next.UserID))
+	return convertToRefreshToken(gormToken), nil
+}
+
+// RevokeRefreshTokenFamily отзывает все активные токены цепочки
+func (r *SQLRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RevokeRefreshTokenFamily operation canceled for family: %s", familyID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormRefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to revoke refresh token family: %s", familyID), slog.Any("error", result.Error))
+		return result.Error
+	}
+
+	r.logger.Info(fmt.Sprintf("revoked %d refresh tokens in family: %s", result.RowsAffected, familyID))
+	return nil
+}
+
+// RevokeUserRefreshTokens отзывает все активные токены пользователя
+func (r *SQLRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RevokeUserRefreshTokens operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormRefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to revoke refresh tokens for user ID: %d", userID), slog.Any("error", result.Error))
+		return result.Error
+	}
+
+	r.logger.Info(fmt.Sprintf("revoked %d refresh tokens for user ID: %d", result.RowsAffected, userID))
+	return nil
+}
+
+// PurgeExpiredRefreshTokens удаляет токены, истекшие до указанного момента
+func (r *SQLRepository) PurgeExpiredRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&GormRefreshToken{})
+	if result.Error != nil {
+		r.logger.Error("failed to purge expired refresh tokens", slog.Any("error", result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// convertToGormRefreshToken преобразует RefreshToken в GormRefreshToken
+func convertToGormRefreshToken(token *RefreshToken) *GormRefreshToken {
+	return &GormRefreshToken{
+		ID:        token.ID,
+		UserID:    token.UserID,
+		FamilyID:  token.FamilyID,
+		TokenHash: token.TokenHash,
+		ExpiresAt: token.ExpiresAt,
+		RotatedAt: token.RotatedAt,
+		RevokedAt: token.RevokedAt,
+	}
+}
+
+// convertToRefreshToken преобразует GormRefreshToken в RefreshToken
+func convertToRefreshToken(gormToken *GormRefreshToken) *RefreshToken {
+	return &RefreshToken{
+		ID:        gormToken.ID,
+		UserID:    gormToken.UserID,
+		FamilyID:  gormToken.FamilyID,
+		TokenHash: gormToken.TokenHash,
+		ExpiresAt: gormToken.ExpiresAt,
+		RotatedAt: gormToken.RotatedAt,
+		RevokedAt: gormToken.RevokedAt,
+		CreatedAt: gormToken.CreatedAt,
+	}
+}
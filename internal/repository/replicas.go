@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultReadYourWritesWindow время после изменения пользователя, в течение которого его чтения идут в основную базу
+const DefaultReadYourWritesWindow = 5 * time.Second
+
+// primaryContextKey ключ контекста, требующего чтения из основной базы
+type primaryContextKey struct{}
+
+// WithPrimary возвращает контекст, в котором чтения репозитория выполняются на основной базе, а не на репликах.
+// Нужен для чтений перед изменением, где устаревшая копия приведет к конфликту версий.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey{}, true)
+}
+
+// usePrimary сообщает, требует ли контекст чтения из основной базы
+func usePrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryContextKey{}).(bool)
+	return primary
+}
+
+// replica реплика базы данных только для чтения
+type replica struct {
+	index   int
+	db      *gorm.DB
+	healthy atomic.Bool
+}
+
+// replicaSet распределяет чтения между исправными репликами и помнит недавно измененных пользователей.
+// Окно чтения своих записей действует в пределах одного процесса.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+	window   time.Duration
+
+	mu        sync.Mutex
+	written   map[string]time.Time // Ключ пользователя -> момент окончания окна чтения из основной базы
+	lastSweep time.Time
+}
+
+// WithReplicas направляет чтения пользователей на реплики. Реплики считаются неисправными до первой
+// проверки CheckReplicas. Чтения пользователя, измененного не раньше чем window назад, идут в основную базу.
+func WithReplicas(replicas []*gorm.DB, window time.Duration) SQLOption {
+	return func(r *SQLRepository) {
+		if len(replicas) == 0 {
+			return
+		}
+		set := &replicaSet{window: window, written: make(map[string]time.Time)}
+		for i, db := range replicas {
+			set.replicas = append(set.replicas, &replica{index: i + 1, db: db})
+		}
+		r.replicas = set
+	}
+}
+
+// CheckReplicas проверяет доступность реплик и возвращает количество реплик, чье состояние изменилось
+func (r *SQLRepository) CheckReplicas(ctx context.Context) (int64, error) {
+	if r.replicas == nil {
+		return 0, nil
+	}
+
+	var changed int64
+	for _, rep := range r.replicas.replicas {
+		// Проверка отмены контекста
+		select {
+		case <-ctx.Done():
+			return changed, ctx.Err()
+		default:
+		}
+
+		err := pingReplica(ctx, rep.db)
+		healthy := err == nil
+		if rep.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		changed++
+		if healthy {
+			r.logger.Info(fmt.Sprintf("read replica %d is healthy", rep.index))
+		} else {
+			r.logger.Warn(fmt.Sprintf("read replica %d is unhealthy, reads fall back to the primary", rep.index), slog.Any("error", err))
+		}
+	}
+	return changed, nil
+}
+
+// pingReplica проверяет соединение с репликой
+func pingReplica(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return sqlDB.PingContext(ctx)
+}
+
+// read выполняет запрос на реплике, если это допустимо, иначе на основной базе.
+// При ошибке реплики, кроме отсутствия записи, реплика помечается неисправной и запрос повторяется на основной базе.
+func (r *SQLRepository) read(ctx context.Context, keys []string, query func(db *gorm.DB) error) error {
+	rep := r.pickReplica(ctx, keys)
+	if rep == nil {
+		return query(r.db.WithContext(ctx))
+	}
+
+	err := query(rep.db.WithContext(ctx))
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) || ctx.Err() != nil {
+		return err
+	}
+
+	if rep.healthy.Swap(false) {
+		r.logger.Warn(fmt.Sprintf("read replica %d failed, reads fall back to the primary", rep.index), slog.Any("error", err))
+	}
+	return query(r.db.WithContext(ctx))
+}
+
+// pickReplica выбирает исправную реплику по кругу; nil означает чтение из основной базы
+func (r *SQLRepository) pickReplica(ctx context.Context, keys []string) *replica {
+	set := r.replicas
+	if set == nil || r.inTx || usePrimary(ctx) || set.recentlyWritten(keys, time.Now()) {
+		return nil
+	}
+
+	start := set.next.Add(1)
+	for i := range set.replicas {
+		rep := set.replicas[(start+uint64(i))%uint64(len(set.replicas))]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// recentlyWritten сообщает, менялся ли пользователь с одним из ключей в пределах окна
+func (s *replicaSet) recentlyWritten(keys []string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		if until, ok := s.written[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// markWritten запоминает изменение пользователя, чтобы его чтения шли в основную базу до конца окна
+func (r *SQLRepository) markWritten(gormUsers ...*GormUser) {
+	set := r.replicas
+	if set == nil || set.window <= 0 {
+		return
+	}
+
+	now := time.Now()
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	// Истекшие записи удаляются не чаще одного раза за окно
+	if now.Sub(set.lastSweep) > set.window {
+		for key, until := range set.written {
+			if !now.Before(until) {
+				delete(set.written, key)
+			}
+		}
+		set.lastSweep = now
+	}
+
+	until := now.Add(set.window)
+	for _, gormUser := range gormUsers {
+		for _, key := range userKeys(gormUser) {
+			set.written[key] = until
+		}
+	}
+}
+
+// userKeys возвращает ключи, по которым пользователя ищут при чтении
+func userKeys(gormUser *GormUser) []string {
+	keys := []string{userIDKey(gormUser.ID)}
+	if gormUser.UsernameCanonical != nil {
+		keys = append(keys, usernameKey(*gormUser.UsernameCanonical))
+	}
+	if gormUser.EmailCanonical != nil {
+		keys = append(keys, emailKey(*gormUser.EmailCanonical))
+	}
+	return keys
+}
+
+func userIDKey(id uint) string { return fmt.Sprintf("id:%d", id) }
+
+func usernameKey(canonical string) string { return "username:" + canonical }
+
+func emailKey(canonical string) string { return "email:" + canonical }
@@ -0,0 +1,106 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"gorm.io/gorm"
+)
+
+// newReplicatedRepository создает репозиторий с отдельной базой в роли реплики.
+// Реплика не получает изменений основной базы, поэтому по результату чтения видно, какая база его выполнила.
+func newReplicatedRepository(t *testing.T, window time.Duration) (*repository.SQLRepository, *gorm.DB) {
+	t.Helper()
+	replica := repositorytest.OpenMigratedSQLite(t)
+	repo := repository.NewSQLRepository(repositorytest.OpenMigratedSQLite(t), repositorytest.DiscardLogger(), repository.WithReplicas([]*gorm.DB{replica}, window))
+	if changed, err := repo.CheckReplicas(context.Background()); err != nil || changed != 1 {
+		t.Fatalf("CheckReplicas = %d, %v; want 1 replica to become healthy", changed, err)
+	}
+	return repo, replica
+}
+
+func createUser(t *testing.T, repo *repository.SQLRepository, name string) *repository.User {
+	t.Helper()
+	created, err := repo.CreateUser(context.Background(), &user.User{Username: name, Email: name + "@example.com", Pwdhash: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", name, err)
+	}
+	return created
+}
+
+func TestReplicaReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+
+	// В пределах окна чтения измененного пользователя идут в основную базу
+	repo, _ := newReplicatedRepository(t, time.Hour)
+	created := createUser(t, repo, "alice")
+	if _, err := repo.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Errorf("GetUserByID within the window: %v", err)
+	}
+	if _, err := repo.GetUserByUsername(ctx, "ALICE"); err != nil {
+		t.Errorf("GetUserByUsername within the window: %v", err)
+	}
+
+	// Без окна чтения идут на реплику, где пользователя еще нет
+	repo, _ = newReplicatedRepository(t, 0)
+	created = createUser(t, repo, "bob")
+	if _, err := repo.GetUserByID(ctx, uint(created.Id)); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetUserByID without the window error = %v, want %v from the replica", err, repository.ErrUserNotFound)
+	}
+}
+
+func TestReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	repo, replica := newReplicatedRepository(t, time.Hour)
+
+	// Пользователь есть только на реплике
+	insertLegacyUser(t, replica, 7, "carol", "carol@example.com")
+
+	if _, err := repo.GetUserByID(ctx, 7); err != nil {
+		t.Errorf("GetUserByID: %v, want the user from the replica", err)
+	}
+	page, err := repo.ListUsers(ctx, repository.ListUsersOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].Id != 7 {
+		t.Errorf("ListUsers = %d users, want the user from the replica", len(page.Users))
+	}
+
+	// WithPrimary направляет чтения в основную базу
+	primaryCtx := repository.WithPrimary(ctx)
+	if _, err := repo.GetUserByID(primaryCtx, 7); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetUserByID with WithPrimary error = %v, want %v from the primary", err, repository.ErrUserNotFound)
+	}
+	page, err = repo.ListUsers(primaryCtx, repository.ListUsersOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers with WithPrimary: %v", err)
+	}
+	if len(page.Users) != 0 {
+		t.Errorf("ListUsers with WithPrimary = %d users, want none from the primary", len(page.Users))
+	}
+}
+
+func TestReplicaFallback(t *testing.T) {
+	ctx := context.Background()
+	repo, replica := newReplicatedRepository(t, 0)
+	created := createUser(t, repo, "dave")
+
+	// Ошибка реплики, кроме отсутствия записи, переводит чтение на основную базу
+	if err := replica.Exec(`DROP TABLE "user"`).Error; err != nil {
+		t.Fatalf("failed to break the replica: %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Errorf("GetUserByID after a replica error: %v, want the user from the primary", err)
+	}
+
+	// Реплика помечена неисправной: следующая проверка соединения возвращает ее в работу
+	if changed, err := repo.CheckReplicas(ctx); err != nil || changed != 1 {
+		t.Errorf("CheckReplicas = %d, %v; want the replica to become healthy again", changed, err)
+	}
+}
@@ -9,33 +9,76 @@ import (
 	"unicode/utf8"
 
 	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/canonical"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrVersionConflict = errors.New("user was modified concurrently")
+)
+
+// AnyVersion отключает проверку версии в UpdateUser
+const AnyVersion int64 = 0
+
+// User пользователь вместе с версией записи, которая увеличивается при каждом изменении.
+// UsernameCanonical и EmailCanonical хранят ключи, по которым запись находится при поиске;
+// они пусты, пока каноническая форма не заполнена.
+type User struct {
+	*user.User
+	Version           int64
+	UsernameCanonical string
+	EmailCanonical    string
+}
 
 type Repository interface {
-	CreateUser(ctx context.Context, user *user.User) (*user.User, error)
-	GetUserByID(ctx context.Context, id uint) (*user.User, error)
-	GetUserByUsername(ctx context.Context, username string) (*user.User, error)
-	GetUserByEmail(ctx context.Context, email string) (*user.User, error)
-	UpdateUser(ctx context.Context, user *user.User) (*user.User, error)
+	CreateUser(ctx context.Context, user *user.User) (*User, error)
+	GetUserByID(ctx context.Context, id uint) (*User, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUsersByIDs(ctx context.Context, ids []uint) ([]*User, []uint, error)
+	GetUsersByUsernames(ctx context.Context, usernames []string) ([]*User, []string, error)
+	UpdateUser(ctx context.Context, user *user.User, expectedVersion int64) (*User, error)
+	RehashPassword(ctx context.Context, id uint, previousPwdhash, pwdhash string) error
 	DeleteUser(ctx context.Context, id uint) error
+	ListUsers(ctx context.Context, opts ListUsersOptions) (*UserPage, error)
+	SearchUsers(ctx context.Context, opts SearchUsersOptions) (*UserPage, error)
+	RestoreUser(ctx context.Context, id uint) (*User, error)
+	WithinTx(ctx context.Context, fn func(repo Repository) error, opts ...TxOption) error
 }
 
-// PostgresRepository реализация репозитория с использованием GORM
-type PostgresRepository struct {
-	db     *gorm.DB
-	logger *slog.Logger
+// SQLRepository реализация репозитория с использованием GORM для Postgres и SQLite
+type SQLRepository struct {
+	db       *gorm.DB
+	logger   *slog.Logger
+	canon    canonical.Rules
+	dialect  dialect     // Особенности базы данных основного подключения
+	replicas *replicaSet // Реплики для чтения; nil, если все запросы идут в основную базу
+	inTx     bool        // Репозиторий единицы работы: все запросы, включая чтения, идут в транзакцию
 }
 
-// NewPostgresRepository создает новый экземпляр PostgresRepository
-func NewPostgresRepository(db *gorm.DB, logger *slog.Logger) *PostgresRepository {
-	return &PostgresRepository{db: db, logger: logger}
+// SQLOption задает необязательные параметры SQLRepository
+type SQLOption func(*SQLRepository)
+
+// WithCanonicalRules задает правила приведения имен и адресов почты к каноническому виду
+func WithCanonicalRules(rules canonical.Rules) SQLOption {
+	return func(r *SQLRepository) {
+		r.canon = rules
+	}
+}
+
+// NewSQLRepository создает новый экземпляр SQLRepository
+func NewSQLRepository(db *gorm.DB, logger *slog.Logger, opts ...SQLOption) *SQLRepository {
+	r := &SQLRepository{db: db, logger: logger, canon: canonical.Default(), dialect: dialectOf(db)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // validateUser проверяет основные поля пользователя
-func (r *PostgresRepository) validateUser(user *user.User) error {
+func validateUser(user *user.User) error {
 	if user == nil {
 		return fmt.Errorf("user cannot be nil")
 	}
@@ -52,15 +95,17 @@ func (r *PostgresRepository) validateUser(user *user.User) error {
 		return fmt.Errorf("invalid password hash: must be 1-1000 characters")
 	}
 
-	if user.Salt == "" || len(user.Salt) > 255 {
-		return fmt.Errorf("invalid salt: must be 1-255 characters")
+	// Соль хранится отдельно только у хешей старого формата, новые хеши содержат ее внутри
+	if len(user.Salt) > 255 {
+		return fmt.Errorf("invalid salt: must be at most 255 characters")
 	}
 
 	return nil
 }
 
-// CreateUser создает нового пользователя в базе данных
-func (r *PostgresRepository) CreateUser(ctx context.Context, user *user.User) (*user.User, error) {
+// CreateUser создает нового пользователя в базе данных.
+// Если имя или почта заняты, возвращает ErrUsernameTaken или ErrEmailTaken.
+func (r *SQLRepository) CreateUser(ctx context.Context, user *user.User) (*User, error) {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
@@ -70,60 +115,69 @@ func (r *PostgresRepository) CreateUser(ctx context.Context, user *user.User) (*
 	}
 
 	// Валидация входных данные
-	if err := r.validateUser(user); err != nil {
+	if err := validateUser(user); err != nil {
 		r.logger.Error(fmt.Sprintf("failed to create user: invalid data for user ID: %d", user.Id), slog.Any("error", err))
 		return nil, err
 	}
 
+	usernameCanonical := r.canon.Username(user.Username)
+	emailCanonical := r.canon.Email(user.Email)
 	gormUser := &GormUser{
-		Username: user.Username,
-		Email:    user.Email,
-		Pwdhash:  user.Pwdhash,
-		Salt:     user.Salt,
-	}
-
-	// Транзакционная операция
-	tx := r.db.Begin()
-	if tx.Error != nil {
-		r.logger.Error(fmt.Sprintf("failed to begin transaction for user ID: %d", user.Id), slog.Any("error", tx.Error))
-		return nil, tx.Error
+		Username:          user.Username,
+		Email:             user.Email,
+		Pwdhash:           user.Pwdhash,
+		Salt:              user.Salt,
+		UsernameCanonical: &usernameCanonical,
+		EmailCanonical:    &emailCanonical,
+		Version:           1,
 	}
 
-	// Проверка контекста перед созданием пользователя
-	select {
-	case <-ctx.Done():
-		tx.Rollback()
-		r.logger.Error(fmt.Sprintf("CreateUser operation canceled during transaction for user ID: %d", user.Id), slog.Any("error", ctx.Err()))
-		return nil, ctx.Err()
-	default:
-	}
+	// Транзакционная операция; внутри единицы работы выполняется в точке сохранения ее транзакции
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Проверка контекста перед созданием пользователя
+		select {
+		case <-ctx.Done():
+			r.logger.Error(fmt.Sprintf("CreateUser operation canceled during transaction for user ID: %d", user.Id), slog.Any("error", ctx.Err()))
+			return ctx.Err()
+		default:
+		}
 
-	if err := tx.Create(gormUser).Error; err != nil {
-		tx.Rollback()
-		r.logger.Error(fmt.Sprintf("failed to create user with username: %s", user.Username), slog.Any("error", err))
-		return nil, err
-	}
+		// Уникальность имени и почты обеспечивают ограничения базы, поэтому одновременные регистрации не создают дубликатов
+		if err := tx.Create(gormUser).Error; err != nil {
+			err = r.dialect.translateError(err)
+			if isUniqueViolation(err) {
+				r.logger.Warn(fmt.Sprintf("failed to create user with username: %s", user.Username), slog.Any("error", err))
+				return err
+			}
+			r.logger.Error(fmt.Sprintf("failed to create user with username: %s", user.Username), slog.Any("error", err))
+			return err
+		}
 
-	// Проверка контекста после создания пользователя
-	select {
-	case <-ctx.Done():
-		tx.Rollback()
-		r.logger.Error(fmt.Sprintf("CreateUser operation canceled after user creation for user ID: %d", user.Id), slog.Any("error", ctx.Err()))
-		return nil, ctx.Err()
-	default:
-	}
+		// Проверка контекста после создания пользователя
+		select {
+		case <-ctx.Done():
+			r.logger.Error(fmt.Sprintf("CreateUser operation canceled after user creation for user ID: %d", user.Id), slog.Any("error", ctx.Err()))
+			return ctx.Err()
+		default:
+		}
 
-	if err := tx.Commit().Error; err != nil {
-		r.logger.Error(fmt.Sprintf("failed to commit transaction for user ID: %d", user.Id), slog.Any("error", err))
+		if err := appendAudit(ctx, tx, AuditActionCreate, gormUser.ID, nil, gormUser); err != nil {
+			r.logger.Error(fmt.Sprintf("failed to write audit event for created user with username: %s", user.Username), slog.Any("error", err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
+	r.markWritten(gormUser)
 	r.logger.Info(fmt.Sprintf("user created successfully with username: %s", user.Username))
-	return convertToProtoUser(gormUser), nil
+	return convertToUser(gormUser), nil
 }
 
 // GetUserByID получает пользователя по ID
-func (r *PostgresRepository) GetUserByID(ctx context.Context, id uint) (*user.User, error) {
+func (r *SQLRepository) GetUserByID(ctx context.Context, id uint) (*User, error) {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
@@ -133,7 +187,10 @@ func (r *PostgresRepository) GetUserByID(ctx context.Context, id uint) (*user.Us
 	}
 
 	var gormUser GormUser
-	if err := r.db.First(&gormUser, id).Error; err != nil {
+	err := r.read(ctx, []string{userIDKey(id)}, func(db *gorm.DB) error {
+		return db.First(&gormUser, id).Error
+	})
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			r.logger.Warn(fmt.Sprintf("user not found with ID: %d", id))
 			return nil, ErrUserNotFound
@@ -143,11 +200,11 @@ func (r *PostgresRepository) GetUserByID(ctx context.Context, id uint) (*user.Us
 	}
 
 	r.logger.Info(fmt.Sprintf("user fetched successfully with ID: %d", id))
-	return convertToProtoUser(&gormUser), nil
+	return convertToUser(&gormUser), nil
 }
 
-// GetUserByUsername получает пользователя по имени пользователя
-func (r *PostgresRepository) GetUserByUsername(ctx context.Context, username string) (*user.User, error) {
+// GetUserByUsername получает пользователя по имени пользователя без учета регистра и формы записи символов
+func (r *SQLRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
@@ -156,8 +213,14 @@ func (r *PostgresRepository) GetUserByUsername(ctx context.Context, username str
 	default:
 	}
 
+	usernameCanonical := r.canon.Username(username)
 	var gormUser GormUser
-	if err := r.db.Where("username = ?", username).First(&gormUser).Error; err != nil {
+	err := r.read(ctx, []string{usernameKey(usernameCanonical)}, func(db *gorm.DB) error {
+		// Записи без канонической формы (созданные до ее заполнения) находятся по точному имени
+		return db.Where("username_canonical = ? OR (username_canonical IS NULL AND username = ?)", usernameCanonical, username).
+			First(&gormUser).Error
+	})
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			r.logger.Warn(fmt.Sprintf("user not found with username: %s", username))
 			return nil, ErrUserNotFound
@@ -167,11 +230,11 @@ func (r *PostgresRepository) GetUserByUsername(ctx context.Context, username str
 	}
 
 	r.logger.Info(fmt.Sprintf("user fetched successfully with username: %s", username))
-	return convertToProtoUser(&gormUser), nil
+	return convertToUser(&gormUser), nil
 }
 
-// GetUserByEmail получает пользователя по электронной почте
-func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (*user.User, error) {
+// GetUserByEmail получает пользователя по электронной почте с учетом правил канонизации адресов
+func (r *SQLRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
@@ -180,8 +243,14 @@ func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (
 	default:
 	}
 
+	emailCanonical := r.canon.Email(email)
 	var gormUser GormUser
-	if err := r.db.Where("email = ?", email).First(&gormUser).Error; err != nil {
+	err := r.read(ctx, []string{emailKey(emailCanonical)}, func(db *gorm.DB) error {
+		// Записи без канонической формы (созданные до ее заполнения) находятся по точному адресу
+		return db.Where("email_canonical = ? OR (email_canonical IS NULL AND email = ?)", emailCanonical, email).
+			First(&gormUser).Error
+	})
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			r.logger.Warn(fmt.Sprintf("user not found with email: %s", email))
 			return nil, ErrUserNotFound
@@ -191,11 +260,13 @@ func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (
 	}
 
 	r.logger.Info(fmt.Sprintf("user fetched successfully with email: %s", email))
-	return convertToProtoUser(&gormUser), nil
+	return convertToUser(&gormUser), nil
 }
 
-// UpdateUser обновляет информацию о пользователе
-func (r *PostgresRepository) UpdateUser(ctx context.Context, user *user.User) (*user.User, error) {
+// UpdateUser обновляет информацию о пользователе.
+// Обновление выполняется, только если версия записи равна expectedVersion (AnyVersion отключает проверку),
+// иначе возвращается ErrVersionConflict. Каждое обновление увеличивает версию.
+func (r *SQLRepository) UpdateUser(ctx context.Context, user *user.User, expectedVersion int64) (*User, error) {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
@@ -204,69 +275,177 @@ func (r *PostgresRepository) UpdateUser(ctx context.Context, user *user.User) (*
 	default:
 	}
 
-	// Преобразование proto-структуры в GORM-структуру
-	gormUser := &GormUser{
-		ID:       uint(user.Id),
-		Username: user.Username,
-		Email:    user.Email,
-		Pwdhash:  user.Pwdhash,
-		Salt:     user.Salt,
-	}
+	// Чтение, обновление и запись в журнал изменений выполняются в одной транзакции;
+	// внутри единицы работы - в точке сохранения ее транзакции
+	var previousUser, existingUser GormUser
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Проверка существования пользователя перед обновлением
+		if err := tx.Clauses(lockForUpdate).First(&existingUser, user.Id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				r.logger.Warn(fmt.Sprintf("user not found with ID: %d", user.Id))
+				return ErrUserNotFound
+			}
+			r.logger.Error(fmt.Sprintf("failed to check user existence for user ID: %d", user.Id), slog.Any("error", err))
+			return err
+		}
 
-	// Проверка существования пользователя перед обновлением
-	var existingUser GormUser
-	if err := r.db.First(&existingUser, gormUser.ID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			r.logger.Warn(fmt.Sprintf("user not found with ID: %d", user.Id))
-			return nil, ErrUserNotFound
+		// Выполнение обновления; поля перечислены явно, чтобы пустая соль тоже сохранялась.
+		// Канонические формы пересчитываются только при смене значения, чтобы не потерять
+		// форму с суффиксом, назначенную при коллизии. Смена почты сбрасывает ее подтверждение.
+		updates := map[string]interface{}{
+			"username": user.Username,
+			"email":    user.Email,
+			"pwdhash":  user.Pwdhash,
+			"salt":     user.Salt,
+			"version":  gorm.Expr("version + 1"),
 		}
-		r.logger.Error(fmt.Sprintf("failed to check user existence for user ID: %d", user.Id), slog.Any("error", err))
-		return nil, err
-	}
+		if existingUser.Username != user.Username {
+			updates["username_canonical"] = r.canon.Username(user.Username)
+		}
+		if existingUser.Email != user.Email {
+			updates["email_canonical"] = r.canon.Email(user.Email)
+			updates["email_verified_at"] = nil
+		}
+		previousUser = existingUser
 
-	// Выполнение обновления
-	result := r.db.Model(&existingUser).Updates(gormUser)
-	if result.Error != nil {
-		r.logger.Error(fmt.Sprintf("failed to update user with ID: %d", user.Id), slog.Any("error", result.Error))
-		return nil, result.Error
+		query := tx.Model(&existingUser).Clauses(clause.Returning{})
+		if expectedVersion != AnyVersion {
+			query = query.Where("version = ?", expectedVersion)
+		}
+		result := query.Updates(updates)
+		if result.Error != nil {
+			err := r.dialect.translateError(result.Error)
+			if isUniqueViolation(err) {
+				r.logger.Warn(fmt.Sprintf("failed to update user with ID: %d", user.Id), slog.Any("error", err))
+				return err
+			}
+			r.logger.Error(fmt.Sprintf("failed to update user with ID: %d", user.Id), slog.Any("error", err))
+			return err
+		}
+		if result.RowsAffected == 0 {
+			r.logger.Warn(fmt.Sprintf("version conflict updating user with ID: %d, expected version %d", user.Id, expectedVersion))
+			return ErrVersionConflict
+		}
+
+		if err := appendAudit(ctx, tx, AuditActionUpdate, existingUser.ID, &previousUser, &existingUser); err != nil {
+			r.logger.Error(fmt.Sprintf("failed to write audit event for updated user with ID: %d", user.Id), slog.Any("error", err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	// Прежние имя и почта тоже читаются из основной базы, пока реплики не увидели изменение
+	r.markWritten(&previousUser, &existingUser)
 	r.logger.Info(fmt.Sprintf("user updated successfully with ID: %d", user.Id))
-	updatedUser := convertToProtoUser(&existingUser)
-	return updatedUser, nil
+	return convertToUser(&existingUser), nil
 }
 
-// DeleteUser удаляет пользователя по ID
-func (r *PostgresRepository) DeleteUser(ctx context.Context, id uint) error {
+// RehashPassword заменяет хеш пароля равнозначным хешем текущего алгоритма и очищает соль.
+// Это не изменение пользователя: версия и дата обновления не затрагиваются, а в журнал изменений
+// попадает только факт замены хеша без значений.
+// Хеш заменяется, только если он все еще равен previousPwdhash, иначе возвращается ErrUserNotFound.
+func (r *SQLRepository) RehashPassword(ctx context.Context, id uint, previousPwdhash, pwdhash string) error {
 	// Проверка отмены контекста
 	select {
 	case <-ctx.Done():
-		r.logger.Error(fmt.Sprintf("DeleteUser operation canceled for user ID: %d", id), slog.Any("error", ctx.Err()))
+		r.logger.Error(fmt.Sprintf("RehashPassword operation canceled for user ID: %d", id), slog.Any("error", ctx.Err()))
 		return ctx.Err()
 	default:
 	}
 
-	var existingUser GormUser
-	if err := r.db.First(&existingUser, id).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			r.logger.Warn(fmt.Sprintf("user not found with ID: %d", id))
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previousUser GormUser
+		err := tx.Clauses(lockForUpdate).
+			Where("id = ? AND pwdhash = ?", id, previousPwdhash).
+			First(&previousUser).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		err = tx.Model(&GormUser{}).Where("id = ?", id).
+			UpdateColumns(map[string]interface{}{"pwdhash": pwdhash, "salt": ""}).Error
+		if err != nil {
+			return err
+		}
+		rehashedUser := previousUser
+		rehashedUser.Pwdhash = pwdhash
+		rehashedUser.Salt = ""
+		return appendAudit(ctx, tx, AuditActionRehashPassword, id, &previousUser, &rehashedUser)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			r.logger.Warn(fmt.Sprintf("password changed or user not found when rehashing password for user ID: %d", id))
 			return ErrUserNotFound
 		}
-		r.logger.Error(fmt.Sprintf("failed to check user existence for user ID: %d", id), slog.Any("error", err))
+		r.logger.Error(fmt.Sprintf("failed to rehash password for user ID: %d", id), slog.Any("error", err))
 		return err
 	}
 
-	// Выполнение удаления
-	result := r.db.Delete(&existingUser)
-	if result.Error != nil {
-		r.logger.Error(fmt.Sprintf("failed to delete user with ID: %d", id), slog.Any("error", result.Error))
-		return result.Error
+	r.logger.Info(fmt.Sprintf("password rehashed for user ID: %d", id))
+	return nil
+}
+
+// DeleteUser помечает пользователя удаленным; запись удаляется окончательно после срока хранения
+func (r *SQLRepository) DeleteUser(ctx context.Context, id uint) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("DeleteUser operation canceled for user ID: %d", id), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
 	}
 
+	var existingUser GormUser
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(lockForUpdate).First(&existingUser, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				r.logger.Warn(fmt.Sprintf("user not found with ID: %d", id))
+				return ErrUserNotFound
+			}
+			r.logger.Error(fmt.Sprintf("failed to check user existence for user ID: %d", id), slog.Any("error", err))
+			return err
+		}
+		previousUser := existingUser
+
+		// Выполнение удаления
+		if err := tx.Delete(&existingUser).Error; err != nil {
+			r.logger.Error(fmt.Sprintf("failed to delete user with ID: %d", id), slog.Any("error", err))
+			return err
+		}
+
+		if err := appendAudit(ctx, tx, AuditActionDelete, existingUser.ID, &previousUser, &existingUser); err != nil {
+			r.logger.Error(fmt.Sprintf("failed to write audit event for deleted user with ID: %d", id), slog.Any("error", err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.markWritten(&existingUser)
 	r.logger.Info(fmt.Sprintf("user deleted successfully with ID: %d", id))
 	return nil
 }
 
+// convertToUser преобразует GormUser в User с версией записи
+func convertToUser(gormUser *GormUser) *User {
+	u := &User{User: convertToProtoUser(gormUser), Version: gormUser.Version}
+	if gormUser.UsernameCanonical != nil {
+		u.UsernameCanonical = *gormUser.UsernameCanonical
+	}
+	if gormUser.EmailCanonical != nil {
+		u.EmailCanonical = *gormUser.EmailCanonical
+	}
+	return u
+}
+
 // convertToProtoUser преобразует GormUser в User для возврата из репозитория
 func convertToProtoUser(gormUser *GormUser) *user.User {
 	return &user.User{
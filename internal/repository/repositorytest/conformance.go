@@ -0,0 +1,847 @@
+// Package repositorytest содержит набор проверок контракта repository.Repository,
+// который можно запустить против любой реализации хранилища пользователей.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+)
+
+// Factory создает пустой репозиторий для одной проверки.
+// Освобождение ресурсов регистрируется через t.Cleanup.
+type Factory func(t *testing.T) repository.Repository
+
+// Run запускает все проверки контракта; каждая получает отдельный пустой репозиторий
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo repository.Repository)
+	}{
+		{"CreateUser", testCreateUser},
+		{"CreateUserUniqueness", testCreateUserUniqueness},
+		{"CreateUserValidation", testCreateUserValidation},
+		{"ConcurrentCreates", testConcurrentCreates},
+		{"Lookups", testLookups},
+		{"NotFound", testNotFound},
+		{"UpdateUser", testUpdateUser},
+		{"UpdateUserVersionConflict", testUpdateUserVersionConflict},
+		{"UpdateUserUniqueness", testUpdateUserUniqueness},
+		{"RehashPassword", testRehashPassword},
+		{"DeleteAndRestore", testDeleteAndRestore},
+		{"BatchLookups", testBatchLookups},
+		{"ListUsers", testListUsers},
+		{"ListUsersInvalidCursor", testListUsersInvalidCursor},
+		{"SearchUsers", testSearchUsers},
+		{"Cancellation", testCancellation},
+		{"WithinTxCommit", testWithinTxCommit},
+		{"WithinTxRollback", testWithinTxRollback},
+		{"WithinTxNested", testWithinTxNested},
+		{"WithinTxConcurrentUpdates", testWithinTxConcurrentUpdates},
+		{"AuditLog", testAuditLog},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+// newUser возвращает пользователя с уникальными именем и почтой на основе name
+func newUser(name string) *user.User {
+	return &user.User{
+		Username: name,
+		Email:    name + "@example.com",
+		Pwdhash:  "hash-" + name,
+	}
+}
+
+// mustCreate создает пользователя и прерывает проверку при ошибке
+func mustCreate(t *testing.T, repo repository.Repository, name string) *repository.User {
+	t.Helper()
+	created, err := repo.CreateUser(context.Background(), newUser(name))
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", name, err)
+	}
+	return created
+}
+
+// expectError проверяет, что err соответствует target
+func expectError(t *testing.T, op string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("%s: got error %v, want %v", op, err, target)
+	}
+}
+
+func testCreateUser(t *testing.T, repo repository.Repository) {
+	first := mustCreate(t, repo, "alice")
+	second := mustCreate(t, repo, "bob")
+
+	if first.Id <= 0 || second.Id <= 0 || first.Id == second.Id {
+		t.Fatalf("CreateUser assigned IDs %d and %d, want distinct positive IDs", first.Id, second.Id)
+	}
+	if first.Version != 1 {
+		t.Fatalf("CreateUser version = %d, want 1", first.Version)
+	}
+	if first.CreatedAt == "" || first.UpdatedAt == "" {
+		t.Fatalf("CreateUser did not set timestamps: created %q, updated %q", first.CreatedAt, first.UpdatedAt)
+	}
+	if first.Username != "alice" || first.Email != "alice@example.com" || first.Pwdhash != "hash-alice" {
+		t.Fatalf("CreateUser returned %+v, want the stored fields", first.User)
+	}
+
+	fetched, err := repo.GetUserByID(context.Background(), uint(first.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if fetched.Username != first.Username || fetched.Email != first.Email || fetched.Version != first.Version {
+		t.Fatalf("GetUserByID returned %+v (version %d), want %+v (version %d)", fetched.User, fetched.Version, first.User, first.Version)
+	}
+}
+
+func testCreateUserUniqueness(t *testing.T, repo repository.Repository) {
+	mustCreate(t, repo, "alice")
+	ctx := context.Background()
+
+	_, err := repo.CreateUser(ctx, &user.User{Username: "ALICE", Email: "other@example.com", Pwdhash: "hash"})
+	expectError(t, "CreateUser with a username differing only in case", err, repository.ErrUsernameTaken)
+
+	_, err = repo.CreateUser(ctx, &user.User{Username: "other", Email: "alice@EXAMPLE.com", Pwdhash: "hash"})
+	expectError(t, "CreateUser with an email differing only in domain case", err, repository.ErrEmailTaken)
+}
+
+func testCreateUserValidation(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	invalid := []*user.User{
+		{Username: "", Email: "empty@example.com", Pwdhash: "hash"},
+		{Username: "noemail", Email: "", Pwdhash: "hash"},
+		{Username: "nohash", Email: "nohash@example.com", Pwdhash: ""},
+	}
+	for _, u := range invalid {
+		if _, err := repo.CreateUser(ctx, u); err == nil {
+			t.Fatalf("CreateUser(%+v) succeeded, want validation error", u)
+		}
+	}
+}
+
+func testConcurrentCreates(t *testing.T, repo repository.Repository) {
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.CreateUser(context.Background(), &user.User{
+				Username: "racer",
+				Email:    fmt.Sprintf("racer%d@example.com", i),
+				Pwdhash:  "hash",
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, repository.ErrUsernameTaken):
+		default:
+			t.Fatalf("concurrent CreateUser: unexpected error %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("concurrent CreateUser created %d users with the same username, want 1", created)
+	}
+}
+
+func testLookups(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "carol")
+	ctx := context.Background()
+
+	byUsername, err := repo.GetUserByUsername(ctx, "Carol")
+	if err != nil || byUsername.Id != created.Id {
+		t.Fatalf("GetUserByUsername(%q) = %v, %v; want user %d", "Carol", byUsername, err, created.Id)
+	}
+
+	byEmail, err := repo.GetUserByEmail(ctx, "carol@Example.COM")
+	if err != nil || byEmail.Id != created.Id {
+		t.Fatalf("GetUserByEmail(%q) = %v, %v; want user %d", "carol@Example.COM", byEmail, err, created.Id)
+	}
+}
+
+func testNotFound(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	_, err := repo.GetUserByID(ctx, 4242)
+	expectError(t, "GetUserByID", err, repository.ErrUserNotFound)
+	_, err = repo.GetUserByUsername(ctx, "nobody")
+	expectError(t, "GetUserByUsername", err, repository.ErrUserNotFound)
+	_, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+	expectError(t, "GetUserByEmail", err, repository.ErrUserNotFound)
+	_, err = repo.UpdateUser(ctx, &user.User{Id: 4242, Username: "nobody", Email: "nobody@example.com", Pwdhash: "hash"}, repository.AnyVersion)
+	expectError(t, "UpdateUser", err, repository.ErrUserNotFound)
+	err = repo.DeleteUser(ctx, 4242)
+	expectError(t, "DeleteUser", err, repository.ErrUserNotFound)
+	_, err = repo.RestoreUser(ctx, 4242)
+	expectError(t, "RestoreUser", err, repository.ErrUserNotFound)
+}
+
+func testUpdateUser(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "dave")
+	ctx := context.Background()
+
+	updated, err := repo.UpdateUser(ctx, &user.User{
+		Id:       created.Id,
+		Username: "david",
+		Email:    "david@example.com",
+		Pwdhash:  "new-hash",
+	}, created.Version)
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.Username != "david" || updated.Email != "david@example.com" || updated.Pwdhash != "new-hash" || updated.Salt != "" {
+		t.Fatalf("UpdateUser returned %+v, want the new fields", updated.User)
+	}
+	if updated.Version != created.Version+1 {
+		t.Fatalf("UpdateUser version = %d, want %d", updated.Version, created.Version+1)
+	}
+
+	// Прежнее имя освобождается, новое находится
+	_, err = repo.GetUserByUsername(ctx, "dave")
+	expectError(t, "GetUserByUsername with the old username", err, repository.ErrUserNotFound)
+	if fetched, err := repo.GetUserByUsername(ctx, "david"); err != nil || fetched.Version != updated.Version {
+		t.Fatalf("GetUserByUsername after update = %v, %v; want version %d", fetched, err, updated.Version)
+	}
+
+	// AnyVersion обновляет без проверки версии
+	again, err := repo.UpdateUser(ctx, &user.User{Id: created.Id, Username: "david", Email: "david@example.com", Pwdhash: "hash"}, repository.AnyVersion)
+	if err != nil {
+		t.Fatalf("UpdateUser with AnyVersion: %v", err)
+	}
+	if again.Version != updated.Version+1 {
+		t.Fatalf("UpdateUser with AnyVersion version = %d, want %d", again.Version, updated.Version+1)
+	}
+}
+
+func testUpdateUserVersionConflict(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "erin")
+	ctx := context.Background()
+
+	if _, err := repo.UpdateUser(ctx, newUserWithID(created, "erin2"), created.Version); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	_, err := repo.UpdateUser(ctx, newUserWithID(created, "erin3"), created.Version)
+	expectError(t, "UpdateUser with a stale version", err, repository.ErrVersionConflict)
+
+	fetched, err := repo.GetUserByID(ctx, uint(created.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if fetched.Username != "erin2" {
+		t.Fatalf("stale UpdateUser changed the user to %q", fetched.Username)
+	}
+}
+
+func testUpdateUserUniqueness(t *testing.T, repo repository.Repository) {
+	mustCreate(t, repo, "frank")
+	grace := mustCreate(t, repo, "grace")
+	ctx := context.Background()
+
+	_, err := repo.UpdateUser(ctx, &user.User{Id: grace.Id, Username: "Frank", Email: grace.Email, Pwdhash: "hash"}, repository.AnyVersion)
+	expectError(t, "UpdateUser to a taken username", err, repository.ErrUsernameTaken)
+
+	_, err = repo.UpdateUser(ctx, &user.User{Id: grace.Id, Username: grace.Username, Email: "frank@example.com", Pwdhash: "hash"}, repository.AnyVersion)
+	expectError(t, "UpdateUser to a taken email", err, repository.ErrEmailTaken)
+}
+
+func testRehashPassword(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "rachel")
+	ctx := context.Background()
+	id := uint(created.Id)
+
+	auditEvents := func() []*repository.AuditEvent {
+		store, ok := repo.(repository.AuditRepository)
+		if !ok {
+			return nil
+		}
+		page, err := store.ListAuditEvents(ctx, repository.ListAuditEventsOptions{UserID: id})
+		if err != nil {
+			t.Fatalf("ListAuditEvents: %v", err)
+		}
+		return page.Events
+	}
+	eventsBefore := len(auditEvents())
+
+	if err := repo.RehashPassword(ctx, id, created.Pwdhash, "rehashed"); err != nil {
+		t.Fatalf("RehashPassword: %v", err)
+	}
+	fetched, err := repo.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if fetched.Pwdhash != "rehashed" || fetched.Salt != "" {
+		t.Fatalf("after RehashPassword hash = %q, salt = %q", fetched.Pwdhash, fetched.Salt)
+	}
+	// Замена хеша не меняет версию, поэтому клиенты с прежней версией могут продолжать обновления
+	if fetched.Version != created.Version {
+		t.Fatalf("RehashPassword changed version from %d to %d", created.Version, fetched.Version)
+	}
+	// В журнал попадает только факт замены хеша, без значений
+	if _, ok := repo.(repository.AuditRepository); ok {
+		events := auditEvents()
+		if len(events) != eventsBefore+1 {
+			t.Fatalf("RehashPassword wrote %d audit events, want 1", len(events)-eventsBefore)
+		}
+		if event := events[0]; event.Action != repository.AuditActionRehashPassword ||
+			len(event.Changes) != 1 || event.Changes["password"] != (repository.AuditChange{Redacted: true}) {
+			t.Fatalf("RehashPassword audit event = %+v, want a redacted password change", event)
+		}
+	}
+	if _, err := repo.UpdateUser(ctx, newUserWithID(created, "rachel2"), created.Version); err != nil {
+		t.Fatalf("UpdateUser with the version read before RehashPassword: %v", err)
+	}
+
+	// Хеш, который успели сменить, не перезаписывается
+	err = repo.RehashPassword(ctx, id, "rehashed", "stale")
+	expectError(t, "RehashPassword of a changed hash", err, repository.ErrUserNotFound)
+	err = repo.RehashPassword(ctx, 999999, "hash", "rehashed")
+	expectError(t, "RehashPassword of a missing user", err, repository.ErrUserNotFound)
+}
+
+func testDeleteAndRestore(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "heidi")
+	ctx := context.Background()
+	id := uint(created.Id)
+
+	_, err := repo.RestoreUser(ctx, id)
+	expectError(t, "RestoreUser of a user that is not deleted", err, repository.ErrUserNotFound)
+
+	if err := repo.DeleteUser(ctx, id); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	_, err = repo.GetUserByID(ctx, id)
+	expectError(t, "GetUserByID of a deleted user", err, repository.ErrUserNotFound)
+	_, err = repo.GetUserByUsername(ctx, "heidi")
+	expectError(t, "GetUserByUsername of a deleted user", err, repository.ErrUserNotFound)
+	err = repo.DeleteUser(ctx, id)
+	expectError(t, "DeleteUser of a deleted user", err, repository.ErrUserNotFound)
+
+	// Имя удаленного пользователя остается занятым до окончательного удаления
+	_, err = repo.CreateUser(ctx, &user.User{Username: "heidi", Email: "heidi2@example.com", Pwdhash: "hash"})
+	expectError(t, "CreateUser with the username of a deleted user", err, repository.ErrUsernameTaken)
+
+	restored, err := repo.RestoreUser(ctx, id)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored.Id != created.Id || restored.Username != "heidi" {
+		t.Fatalf("RestoreUser returned %+v, want user %d", restored.User, created.Id)
+	}
+	if _, err := repo.GetUserByID(ctx, id); err != nil {
+		t.Fatalf("GetUserByID after restore: %v", err)
+	}
+}
+
+func testBatchLookups(t *testing.T, repo repository.Repository) {
+	a := mustCreate(t, repo, "ivan")
+	b := mustCreate(t, repo, "judy")
+	deleted := mustCreate(t, repo, "mallory")
+	ctx := context.Background()
+	if err := repo.DeleteUser(ctx, uint(deleted.Id)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	users, missing, err := repo.GetUsersByIDs(ctx, []uint{uint(b.Id), 4242, uint(a.Id), uint(b.Id), uint(deleted.Id)})
+	if err != nil {
+		t.Fatalf("GetUsersByIDs: %v", err)
+	}
+	if len(users) != 2 || users[0].Id != b.Id || users[1].Id != a.Id {
+		t.Fatalf("GetUsersByIDs returned %v, want users %d and %d in request order", userIDs(users), b.Id, a.Id)
+	}
+	if len(missing) != 2 || missing[0] != 4242 || missing[1] != uint(deleted.Id) {
+		t.Fatalf("GetUsersByIDs missing = %v, want [4242 %d]", missing, deleted.Id)
+	}
+
+	users, missingNames, err := repo.GetUsersByUsernames(ctx, []string{"JUDY", "nobody", "ivan", "judy"})
+	if err != nil {
+		t.Fatalf("GetUsersByUsernames: %v", err)
+	}
+	if len(users) != 2 || users[0].Id != b.Id || users[1].Id != a.Id {
+		t.Fatalf("GetUsersByUsernames returned %v, want users %d and %d in request order", userIDs(users), b.Id, a.Id)
+	}
+	if len(missingNames) != 1 || missingNames[0] != "nobody" {
+		t.Fatalf("GetUsersByUsernames missing = %v, want [nobody]", missingNames)
+	}
+
+	tooMany := make([]uint, repository.MaxUserBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = uint(i + 1)
+	}
+	_, _, err = repo.GetUsersByIDs(ctx, tooMany)
+	expectError(t, "GetUsersByIDs over the size cap", err, repository.ErrBatchTooLarge)
+}
+
+func testListUsers(t *testing.T, repo repository.Repository) {
+	const total = 8
+	for i := 1; i < total; i++ {
+		mustCreate(t, repo, fmt.Sprintf("user%02d", total-i))
+	}
+	// Сортировка по байтам ставит заглавные буквы раньше строчных независимо от правил сортировки базы
+	mustCreate(t, repo, fmt.Sprintf("User%02d", total))
+	deleted := mustCreate(t, repo, "user99")
+	if err := repo.DeleteUser(context.Background(), uint(deleted.Id)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	for _, sortBy := range []repository.UserSortField{repository.SortByID, repository.SortByUsername, repository.SortByEmail, repository.SortByCreatedAt} {
+		for _, descending := range []bool{false, true} {
+			opts := repository.ListUsersOptions{SortBy: sortBy, Descending: descending, PageSize: 3}
+			users := listAll(t, repo, opts)
+			if len(users) != total {
+				t.Fatalf("ListUsers(%s, descending %v) returned %d users, want %d", sortBy, descending, len(users), total)
+			}
+
+			seen := make(map[int64]bool)
+			for _, u := range users {
+				if seen[u.Id] {
+					t.Fatalf("ListUsers(%s, descending %v) returned user %d twice", sortBy, descending, u.Id)
+				}
+				seen[u.Id] = true
+			}
+
+			var ordered bool
+			switch sortBy {
+			case repository.SortByUsername:
+				ordered = sort.SliceIsSorted(users, func(i, j int) bool {
+					return (users[i].Username < users[j].Username) != descending
+				})
+			case repository.SortByEmail:
+				ordered = sort.SliceIsSorted(users, func(i, j int) bool {
+					return (users[i].Email < users[j].Email) != descending
+				})
+			default:
+				ordered = sort.SliceIsSorted(users, func(i, j int) bool {
+					return (users[i].Id < users[j].Id) != descending
+				})
+			}
+			if !ordered {
+				t.Fatalf("ListUsers(%s, descending %v) returned users out of order: %v", sortBy, descending, userIDs(users))
+			}
+		}
+	}
+
+	deletedPage, err := repo.ListUsers(context.Background(), repository.ListUsersOptions{
+		Filter: repository.ListUsersFilter{Status: repository.UserStatusDeleted},
+	})
+	if err != nil {
+		t.Fatalf("ListUsers(deleted): %v", err)
+	}
+	if len(deletedPage.Users) != 1 || deletedPage.Users[0].Id != deleted.Id {
+		t.Fatalf("ListUsers(deleted) returned %v, want [%d]", userIDs(deletedPage.Users), deleted.Id)
+	}
+
+	prefixPage, err := repo.ListUsers(context.Background(), repository.ListUsersOptions{
+		Filter: repository.ListUsersFilter{UsernamePrefix: "USER0"},
+	})
+	if err != nil {
+		t.Fatalf("ListUsers(prefix): %v", err)
+	}
+	if len(prefixPage.Users) != total {
+		t.Fatalf("ListUsers(prefix) returned %d users, want %d", len(prefixPage.Users), total)
+	}
+}
+
+func testListUsersInvalidCursor(t *testing.T, repo repository.Repository) {
+	for i := 0; i < 3; i++ {
+		mustCreate(t, repo, fmt.Sprintf("page%d", i))
+	}
+	ctx := context.Background()
+
+	_, err := repo.ListUsers(ctx, repository.ListUsersOptions{Cursor: "not a cursor"})
+	expectError(t, "ListUsers with a malformed cursor", err, repository.ErrInvalidCursor)
+
+	page, err := repo.ListUsers(ctx, repository.ListUsersOptions{PageSize: 1})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.NextCursor == "" {
+		t.Fatalf("ListUsers returned no cursor for a partial page")
+	}
+	_, err = repo.ListUsers(ctx, repository.ListUsersOptions{PageSize: 1, Cursor: page.NextCursor, SortBy: repository.SortByUsername})
+	expectError(t, "ListUsers with a cursor for a different sort", err, repository.ErrInvalidCursor)
+}
+
+func testSearchUsers(t *testing.T, repo repository.Repository) {
+	mustCreate(t, repo, "moviebuff")
+	mustCreate(t, repo, "moviefan")
+	mustCreate(t, repo, "bookworm")
+	deleted := mustCreate(t, repo, "movieghost")
+	ctx := context.Background()
+	if err := repo.DeleteUser(ctx, uint(deleted.Id)); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	_, err := repo.SearchUsers(ctx, repository.SearchUsersOptions{Query: "mo"})
+	expectError(t, "SearchUsers with a short query", err, repository.ErrSearchQueryTooShort)
+
+	page, err := repo.SearchUsers(ctx, repository.SearchUsersOptions{Query: "MOVIE"})
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, u := range page.Users {
+		names[u.Username] = true
+	}
+	if len(page.Users) != 2 || !names["moviebuff"] || !names["moviefan"] {
+		t.Fatalf("SearchUsers(%q) returned %v, want moviebuff and moviefan", "MOVIE", names)
+	}
+
+	first, err := repo.SearchUsers(ctx, repository.SearchUsersOptions{Query: "movie", PageSize: 1})
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	if len(first.Users) != 1 || first.NextCursor == "" {
+		t.Fatalf("SearchUsers page 1 returned %d users and cursor %q, want 1 user and a cursor", len(first.Users), first.NextCursor)
+	}
+	second, err := repo.SearchUsers(ctx, repository.SearchUsersOptions{Query: "movie", PageSize: 1, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("SearchUsers page 2: %v", err)
+	}
+	if len(second.Users) != 1 || second.Users[0].Id == first.Users[0].Id {
+		t.Fatalf("SearchUsers page 2 returned %v after %v", userIDs(second.Users), userIDs(first.Users))
+	}
+}
+
+func testCancellation(t *testing.T, repo repository.Repository) {
+	created := mustCreate(t, repo, "oscar")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	id := uint(created.Id)
+
+	checks := map[string]func() error{
+		"CreateUser":  func() error { _, err := repo.CreateUser(ctx, newUser("peggy")); return err },
+		"GetUserByID": func() error { _, err := repo.GetUserByID(ctx, id); return err },
+		"GetUserByUsername": func() error {
+			_, err := repo.GetUserByUsername(ctx, "oscar")
+			return err
+		},
+		"GetUserByEmail": func() error {
+			_, err := repo.GetUserByEmail(ctx, "oscar@example.com")
+			return err
+		},
+		"GetUsersByIDs": func() error { _, _, err := repo.GetUsersByIDs(ctx, []uint{id}); return err },
+		"GetUsersByUsernames": func() error {
+			_, _, err := repo.GetUsersByUsernames(ctx, []string{"oscar"})
+			return err
+		},
+		"UpdateUser": func() error {
+			_, err := repo.UpdateUser(ctx, newUserWithID(created, "oscar2"), repository.AnyVersion)
+			return err
+		},
+		"WithinTx": func() error {
+			return repo.WithinTx(ctx, func(tx repository.Repository) error {
+				_, err := tx.CreateUser(ctx, newUser("peggy"))
+				return err
+			})
+		},
+		"DeleteUser":  func() error { return repo.DeleteUser(ctx, id) },
+		"RestoreUser": func() error { _, err := repo.RestoreUser(ctx, id); return err },
+		"ListUsers":   func() error { _, err := repo.ListUsers(ctx, repository.ListUsersOptions{}); return err },
+		"SearchUsers": func() error {
+			_, err := repo.SearchUsers(ctx, repository.SearchUsersOptions{Query: "oscar"})
+			return err
+		},
+	}
+	for name, check := range checks {
+		expectError(t, name+" with a canceled context", check(), context.Canceled)
+	}
+
+	// Отмененные вызовы ничего не изменили
+	fetched, err := repo.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetUserByID after canceled calls: %v", err)
+	}
+	if fetched.Username != "oscar" || fetched.Version != created.Version {
+		t.Fatalf("canceled calls changed the user to %+v (version %d)", fetched.User, fetched.Version)
+	}
+	_, err = repo.GetUserByUsername(context.Background(), "peggy")
+	expectError(t, "GetUserByUsername of a user created with a canceled context", err, repository.ErrUserNotFound)
+}
+
+func testWithinTxCommit(t *testing.T, repo repository.Repository) {
+	existing := mustCreate(t, repo, "quentin")
+	ctx := context.Background()
+
+	var created *repository.User
+	err := repo.WithinTx(ctx, func(tx repository.Repository) error {
+		var err error
+		if created, err = tx.CreateUser(ctx, newUser("rupert")); err != nil {
+			return err
+		}
+		// Внутри транзакции видны ее собственные изменения
+		if _, err := tx.GetUserByUsername(ctx, "rupert"); err != nil {
+			return fmt.Errorf("created user is not visible inside the transaction: %w", err)
+		}
+		_, err = tx.UpdateUser(ctx, newUserWithID(existing, "quinn"), existing.Version)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+
+	if _, err := repo.GetUserByID(ctx, uint(created.Id)); err != nil {
+		t.Fatalf("GetUserByID of a user created in a committed transaction: %v", err)
+	}
+	updated, err := repo.GetUserByID(ctx, uint(existing.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if updated.Username != "quinn" || updated.Version != existing.Version+1 {
+		t.Fatalf("committed update left %q (version %d), want quinn (version %d)", updated.Username, updated.Version, existing.Version+1)
+	}
+}
+
+func testWithinTxRollback(t *testing.T, repo repository.Repository) {
+	existing := mustCreate(t, repo, "sybil")
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	err := repo.WithinTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.CreateUser(ctx, newUser("trent")); err != nil {
+			return err
+		}
+		if _, err := tx.UpdateUser(ctx, newUserWithID(existing, "sybil2"), existing.Version); err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, uint(existing.Id)); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	expectError(t, "WithinTx with a failing function", err, errAbort)
+
+	_, err = repo.GetUserByUsername(ctx, "trent")
+	expectError(t, "GetUserByUsername of a user created in a rolled back transaction", err, repository.ErrUserNotFound)
+	fetched, err := repo.GetUserByID(ctx, uint(existing.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID of a user deleted in a rolled back transaction: %v", err)
+	}
+	if fetched.Username != "sybil" || fetched.Version != existing.Version {
+		t.Fatalf("rolled back transaction changed the user to %q (version %d)", fetched.Username, fetched.Version)
+	}
+
+	// Имя из откаченной транзакции свободно
+	mustCreate(t, repo, "trent")
+}
+
+func testWithinTxNested(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	err := repo.WithinTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.CreateUser(ctx, newUser("uma")); err != nil {
+			return err
+		}
+		nestedErr := tx.WithinTx(ctx, func(nested repository.Repository) error {
+			if _, err := nested.CreateUser(ctx, newUser("victor")); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(nestedErr, errAbort) {
+			return fmt.Errorf("nested WithinTx returned %v, want %v", nestedErr, errAbort)
+		}
+		// Откат вложенной транзакции не затрагивает внешнюю
+		_, err := tx.CreateUser(ctx, newUser("walter"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+
+	for name, want := range map[string]error{"uma": nil, "victor": repository.ErrUserNotFound, "walter": nil} {
+		_, err := repo.GetUserByUsername(ctx, name)
+		if !errors.Is(err, want) {
+			t.Fatalf("GetUserByUsername(%q) after nested transactions: got error %v, want %v", name, err, want)
+		}
+	}
+}
+
+func testWithinTxConcurrentUpdates(t *testing.T, repo repository.Repository) {
+	const workers = 4
+	created := mustCreate(t, repo, "xavier")
+	id := uint(created.Id)
+
+	// Каждая транзакция читает версию и обновляет по ней; изоляция и повторы не дают им конфликтовать
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			errs <- repo.WithinTx(ctx, func(tx repository.Repository) error {
+				current, err := tx.GetUserByID(ctx, id)
+				if err != nil {
+					return err
+				}
+				_, err = tx.UpdateUser(ctx, newUserWithID(current, fmt.Sprintf("xavier%d", i)), current.Version)
+				return err
+			}, repository.WithMaxAttempts(20))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent WithinTx: %v", err)
+		}
+	}
+	final, err := repo.GetUserByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if final.Version != created.Version+workers {
+		t.Fatalf("after %d concurrent transactions version = %d, want %d", workers, final.Version, created.Version+workers)
+	}
+}
+
+func testAuditLog(t *testing.T, repo repository.Repository) {
+	store, ok := repo.(repository.AuditRepository)
+	if !ok {
+		t.Skip("repository does not keep an audit log")
+	}
+	ctx := repository.WithAuditInfo(context.Background(), repository.AuditInfo{Actor: "support", RequestID: "req-1"})
+
+	created, err := repo.CreateUser(ctx, newUser("yvonne"))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	id := uint(created.Id)
+	updated, err := repo.UpdateUser(ctx, newUserWithID(created, "yvette"), created.Version)
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := repo.DeleteUser(ctx, id); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := repo.RestoreUser(ctx, id); err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	other := mustCreate(t, repo, "zach")
+
+	// Событие откаченной транзакции не остается в журнале
+	errAbort := errors.New("abort")
+	err = repo.WithinTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.UpdateUser(ctx, newUserWithID(updated, "yolanda"), repository.AnyVersion); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	expectError(t, "WithinTx with a failing function", err, errAbort)
+
+	page, err := store.ListAuditEvents(ctx, repository.ListAuditEventsOptions{UserID: id, PageSize: 3})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(page.Events) != 3 || page.NextCursor == "" {
+		t.Fatalf("first page has %d events (next cursor %q), want 3 and a cursor", len(page.Events), page.NextCursor)
+	}
+	rest, err := store.ListAuditEvents(ctx, repository.ListAuditEventsOptions{UserID: id, PageSize: 3, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatalf("ListAuditEvents with cursor: %v", err)
+	}
+	if rest.NextCursor != "" {
+		t.Fatalf("last page has next cursor %q", rest.NextCursor)
+	}
+	events := append(page.Events, rest.Events...)
+
+	// События идут от новых к старым
+	wantActions := []string{repository.AuditActionRestore, repository.AuditActionDelete, repository.AuditActionUpdate, repository.AuditActionCreate}
+	if len(events) != len(wantActions) {
+		t.Fatalf("got %d audit events for the user, want %d", len(events), len(wantActions))
+	}
+	for i, event := range events {
+		if event.Action != wantActions[i] || event.UserID != id || event.Actor != "support" || event.RequestID != "req-1" {
+			t.Fatalf("event %d = %+v, want action %q for user %d by support in req-1", i, event, wantActions[i], id)
+		}
+	}
+
+	changes := events[2].Changes
+	if email := changes["email"]; email.Before != "yvonne@example.com" || email.After != "yvette@example.com" {
+		t.Fatalf("update recorded email change %+v", email)
+	}
+	if password := changes["password"]; !password.Redacted || password.Before != "" || password.After != "" {
+		t.Fatalf("update recorded password change %+v, want a redacted change without values", password)
+	}
+	for _, event := range events {
+		for field := range event.Changes {
+			if field == "pwdhash" || field == "salt" {
+				t.Fatalf("%s event recorded secret field %q", event.Action, field)
+			}
+		}
+	}
+	if deleted := events[1].Changes["deleted_at"]; deleted.Before != "" || deleted.After == "" {
+		t.Fatalf("delete recorded deleted_at change %+v", deleted)
+	}
+
+	all, err := store.ListAuditEvents(ctx, repository.ListAuditEventsOptions{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents for all users: %v", err)
+	}
+	if len(all.Events) != 5 || all.Events[0].UserID != uint(other.Id) {
+		t.Fatalf("audit log for all users has %d events, want 5 starting with user %d", len(all.Events), other.Id)
+	}
+	if all.Events[0].Actor != repository.UnknownAuditActor {
+		t.Fatalf("event without caller info has actor %q, want %q", all.Events[0].Actor, repository.UnknownAuditActor)
+	}
+
+	_, err = store.ListAuditEvents(ctx, repository.ListAuditEventsOptions{UserID: uint(other.Id), Cursor: page.NextCursor})
+	expectError(t, "ListAuditEvents with a cursor for another user", err, repository.ErrInvalidCursor)
+}
+
+// newUserWithID возвращает копию пользователя с новым именем и почтой
+func newUserWithID(u *repository.User, name string) *user.User {
+	updated := newUser(name)
+	updated.Id = u.Id
+	return updated
+}
+
+// listAll обходит все страницы списка пользователей
+func listAll(t *testing.T, repo repository.Repository, opts repository.ListUsersOptions) []*repository.User {
+	t.Helper()
+	var users []*repository.User
+	for {
+		page, err := repo.ListUsers(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		users = append(users, page.Users...)
+		if page.NextCursor == "" {
+			return users
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// userIDs возвращает ID пользователей для сообщений об ошибках
+func userIDs(users []*repository.User) []int64 {
+	ids := make([]int64, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.Id)
+	}
+	return ids
+}
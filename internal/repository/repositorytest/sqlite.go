@@ -0,0 +1,58 @@
+package repositorytest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/watchlist-kata/user/internal/config"
+	"github.com/watchlist-kata/user/internal/migrate"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/pkg/utils"
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+)
+
+// DiscardLogger возвращает логгер, который ничего не выводит
+func DiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// NewSQLiteRepository создает SQLRepository над пустой базой SQLite во временном каталоге теста
+func NewSQLiteRepository(t *testing.T) *repository.SQLRepository {
+	t.Helper()
+	return repository.NewSQLRepository(OpenMigratedSQLite(t), DiscardLogger())
+}
+
+// OpenMigratedSQLite открывает базу SQLite со схемой этой сборки
+func OpenMigratedSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := OpenSQLite(t)
+	migrator, err := migrate.New(db, DiscardLogger())
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	return db
+}
+
+// OpenSQLite открывает пустую базу SQLite без схемы во временном каталоге теста.
+// Соединение закрывается через t.Cleanup.
+func OpenSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := utils.ConnectToSQLite(&config.Config{DBSQLitePath: filepath.Join(t.TempDir(), "user.db")})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	db.Logger = gormLogger.Discard
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
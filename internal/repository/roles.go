@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// RoleRepository хранилище ролей пользователей
+type RoleRepository interface {
+	UserRoles(ctx context.Context, userID uint) ([]string, error)
+}
+
+// UserRoles возвращает роли пользователя в алфавитном порядке; у пользователя без ролей список пуст
+func (r *SQLRepository) UserRoles(ctx context.Context, userID uint) ([]string, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("UserRoles operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var roles []string
+	err := r.db.WithContext(ctx).Model(&GormUserRole{}).
+		Where("user_id = ?", userID).
+		Order("role").
+		Pluck("role", &roles).Error
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to get roles for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+	return roles, nil
+}
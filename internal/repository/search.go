@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+
+	"github.com/watchlist-kata/user/internal/canonical"
+	"gorm.io/gorm"
+)
+
+// Ограничения поиска пользователей
+const (
+	MinSearchQueryLength  = 3 // Минимальная длина запроса в символах: короче триграммы не дают осмысленного сходства
+	DefaultSearchPageSize = 20
+	MaxSearchPageSize     = 50
+	maxSearchResults      = 1000 // Результаты дальше этой позиции не выдаются: они уже мало похожи на запрос
+)
+
+var ErrSearchQueryTooShort = fmt.Errorf("search query must contain at least %d characters", MinSearchQueryLength)
+
+// SearchUsersOptions параметры поиска пользователей
+type SearchUsersOptions struct {
+	Query    string
+	PageSize int
+	Cursor   string // Курсор из предыдущей страницы, пусто для первой
+}
+
+// searchCursor содержимое курсора поиска: отпечаток запроса и позиция следующей страницы
+type searchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+// SearchUsers ищет пользователей по подстроке имени; в Postgres - с допуском опечаток (pg_trgm),
+// в SQLite - только по подстроке. Удаленные пользователи не находятся.
+// Отображаемого имени у пользователей нет, поэтому поиск идет только по имени пользователя.
+// Приостановленных учетных записей в модели тоже нет; временная блокировка входа на поиск не влияет.
+func (r *SQLRepository) SearchUsers(ctx context.Context, opts SearchUsersOptions) (*UserPage, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error("SearchUsers operation canceled", slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	query, offset, pageSize, err := searchWindow(r.canon, opts)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize == 0 {
+		return &UserPage{Users: []*User{}}, nil
+	}
+
+	var gormUsers []GormUser
+	err = r.read(ctx, nil, func(db *gorm.DB) error {
+		gormUsers = nil
+		return r.dialect.searchUsers(db.Model(&GormUser{}), query).
+			Offset(offset).
+			Limit(pageSize + 1).
+			Find(&gormUsers).Error
+	})
+	if err != nil {
+		r.logger.Error("failed to search users", slog.Any("error", err))
+		return nil, err
+	}
+
+	page := newSearchPage(query, offset, pageSize, gormUsers)
+	r.logger.Debug(fmt.Sprintf("found %d users", len(page.Users)))
+	return page, nil
+}
+
+// searchWindow проверяет параметры поиска и возвращает канонический запрос, смещение и размер страницы.
+// Нулевой размер страницы означает, что допустимое количество результатов уже выдано.
+func searchWindow(canon canonical.Rules, opts SearchUsersOptions) (string, int, int, error) {
+	query := canon.Username(opts.Query)
+	if utf8.RuneCountInString(query) < MinSearchQueryLength {
+		return "", 0, 0, ErrSearchQueryTooShort
+	}
+
+	pageSize := opts.PageSize
+	if pageSize < 0 {
+		return "", 0, 0, fmt.Errorf("%w: negative page size", ErrInvalidListOptions)
+	}
+	if pageSize == 0 {
+		pageSize = DefaultSearchPageSize
+	}
+	if pageSize > MaxSearchPageSize {
+		pageSize = MaxSearchPageSize
+	}
+
+	offset := 0
+	if opts.Cursor != "" {
+		cursor, err := decodeSearchCursor(opts.Cursor, query)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		offset = cursor.Offset
+	}
+	if offset+pageSize > maxSearchResults {
+		pageSize = max(maxSearchResults-offset, 0)
+	}
+	return query, offset, pageSize, nil
+}
+
+// newSearchPage формирует страницу из результатов, запрошенных с одним лишним для определения следующей страницы
+func newSearchPage(query string, offset, pageSize int, gormUsers []GormUser) *UserPage {
+	page := &UserPage{Users: make([]*User, 0, len(gormUsers))}
+	if len(gormUsers) > pageSize {
+		gormUsers = gormUsers[:pageSize]
+		page.NextCursor = encodeSearchCursor(query, offset+pageSize)
+	}
+	for i := range gormUsers {
+		page.Users = append(page.Users, convertToUser(&gormUsers[i]))
+	}
+	return page
+}
+
+// encodeSearchCursor формирует непрозрачный курсор следующей страницы поиска
+func encodeSearchCursor(query string, offset int) string {
+	data, _ := json.Marshal(searchCursor{Query: searchFingerprint(query), Offset: offset})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor разбирает курсор и проверяет, что он выдан для того же запроса
+func decodeSearchCursor(value, query string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Query != searchFingerprint(query) {
+		return nil, fmt.Errorf("%w: cursor was issued for a different query", ErrInvalidCursor)
+	}
+	return &cursor, nil
+}
+
+// searchFingerprint возвращает короткий отпечаток запроса для привязки к нему курсора
+func searchFingerprint(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSessionNotFound сеанс не существует, уже отозван или принадлежит другому пользователю
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session представляет сеанс входа пользователя
+type Session struct {
+	ID          string
+	UserID      uint
+	DeviceLabel string
+	UserAgent   string
+	PeerAddress string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time
+}
+
+// SessionRepository хранилище сеансов.
+// Отзыв сеанса отзывает и refresh-токены его цепочки, поэтому реализация хранит их в той же базе.
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session *Session) (*Session, error)
+	ListSessions(ctx context.Context, userID uint, now time.Time) ([]*Session, error)
+	TouchSession(ctx context.Context, id string, seenAt, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, userID uint, id string) error
+	RevokeUserSessions(ctx context.Context, userID uint, exceptID string) (int64, error)
+	PurgeExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// CreateSession сохраняет новый сеанс
+func (r *SQLRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("CreateSession operation canceled for user ID: %d", session.UserID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	gormSession := convertToGormSession(session)
+	if err := r.db.WithContext(ctx).Create(gormSession).Error; err != nil {
+		r.logger.Error(fmt.Sprintf("failed to create session for user ID: %d", session.UserID), slog.Any("error", err))
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("session created for user ID: %d", session.UserID))
+	return convertToSession(gormSession), nil
+}
+
+// ListSessions возвращает действующие сеансы пользователя, начиная с последнего активного
+func (r *SQLRepository) ListSessions(ctx context.Context, userID uint, now time.Time) ([]*Session, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("ListSessions operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormSessions []GormSession
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&gormSessions).Error
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to list sessions for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(gormSessions))
+	for i := range gormSessions {
+		sessions = append(sessions, convertToSession(&gormSessions[i]))
+	}
+	return sessions, nil
+}
+
+// TouchSession отмечает активность в действующем сеансе и продлевает его.
+// Отозванный или истекший к моменту seenAt сеанс не продлевается, возвращается ErrSessionNotFound.
+func (r *SQLRepository) TouchSession(ctx context.Context, id string, seenAt, expiresAt time.Time) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("TouchSession operation canceled for session: %s", id), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormSession{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, seenAt).
+		Updates(map[string]interface{}{"last_seen_at": seenAt, "expires_at": expiresAt})
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to touch session: %s", id), slog.Any("error", result.Error))
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeSession отзывает сеанс пользователя вместе с refresh-токенами его цепочки
+func (r *SQLRepository) RevokeSession(ctx context.Context, userID uint, id string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RevokeSession operation canceled for session: %s", id), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&GormSession{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+			Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSessionNotFound
+		}
+		return tx.Model(&GormRefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			r.logger.Error(fmt.Sprintf("failed to revoke session: %s", id), slog.Any("error", err))
+		}
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("session %s revoked for user ID: %d", id, userID))
+	return nil
+}
+
+// RevokeUserSessions отзывает все сеансы и refresh-токены пользователя, кроме сеанса exceptID.
+// Пустой exceptID отзывает все сеансы. Возвращает количество отозванных сеансов.
+func (r *SQLRepository) RevokeUserSessions(ctx context.Context, userID uint, exceptID string) (int64, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("RevokeUserSessions operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return 0, ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	var revoked int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Model(&GormSession{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+		tokens := tx.Model(&GormRefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+		if exceptID != "" {
+			sessions = sessions.Where("id <> ?", exceptID)
+			tokens = tokens.Where("family_id <> ?", exceptID)
+		}
+
+		result := sessions.Update("revoked_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		revoked = result.RowsAffected
+		return tokens.Update("revoked_at", now).Error
+	})
+	if err != nil {
+		r.logger.Error(fmt.Sprintf("failed to revoke sessions for user ID: %d", userID), slog.Any("error", err))
+		return 0, err
+	}
+
+	r.logger.Info(fmt.Sprintf("revoked %d sessions for user ID: %d", revoked, userID))
+	return revoked, nil
+}
+
+// PurgeExpiredSessions удаляет сеансы, истекшие или отозванные до указанного момента
+func (r *SQLRepository) PurgeExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).
+		Where("expires_at < ? OR revoked_at < ?", before, before).
+		Delete(&GormSession{})
+	if result.Error != nil {
+		r.logger.Error("failed to purge expired sessions", slog.Any("error", result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// convertToGormSession преобразует Session в GormSession
+func convertToGormSession(session *Session) *GormSession {
+	return &GormSession{
+		ID:          session.ID,
+		UserID:      session.UserID,
+		DeviceLabel: session.DeviceLabel,
+		UserAgent:   session.UserAgent,
+		PeerAddress: session.PeerAddress,
+		LastSeenAt:  session.LastSeenAt,
+		ExpiresAt:   session.ExpiresAt,
+		RevokedAt:   session.RevokedAt,
+	}
+}
+
+// convertToSession преобразует GormSession в Session
+func convertToSession(gormSession *GormSession) *Session {
+	return &Session{
+		ID:          gormSession.ID,
+		UserID:      gormSession.UserID,
+		DeviceLabel: gormSession.DeviceLabel,
+		UserAgent:   gormSession.UserAgent,
+		PeerAddress: gormSession.PeerAddress,
+		CreatedAt:   gormSession.CreatedAt,
+		LastSeenAt:  gormSession.LastSeenAt,
+		ExpiresAt:   gormSession.ExpiresAt,
+		RevokedAt:   gormSession.RevokedAt,
+	}
+}
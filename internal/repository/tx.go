@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Параметры единицы работы по умолчанию
+const (
+	DefaultTxIsolation   = sql.LevelSerializable
+	DefaultTxMaxAttempts = 3
+	txRetryDelay         = 10 * time.Millisecond // Пауза перед повтором; растет с номером попытки
+)
+
+// ErrTxConflict возвращается WithinTx, если транзакция не сериализовалась с параллельными за все попытки
+var ErrTxConflict = errors.New("transaction conflicts with concurrent transactions")
+
+// txOptions параметры единицы работы
+type txOptions struct {
+	isolation   sql.IsolationLevel
+	maxAttempts int
+}
+
+// TxOption задает необязательные параметры WithinTx
+type TxOption func(*txOptions)
+
+// WithIsolationLevel задает уровень изоляции транзакции.
+// В SQLite транзакции всегда сериализуемы, и уровень не учитывается.
+func WithIsolationLevel(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// WithMaxAttempts задает, сколько раз выполнить транзакцию, если она не сериализуется с параллельными
+func WithMaxAttempts(attempts int) TxOption {
+	return func(o *txOptions) {
+		o.maxAttempts = max(attempts, 1)
+	}
+}
+
+// newTxOptions применяет опции к параметрам по умолчанию
+func newTxOptions(opts []TxOption) txOptions {
+	options := txOptions{isolation: DefaultTxIsolation, maxAttempts: DefaultTxMaxAttempts}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// WithinTx выполняет fn в транзакции; все вызовы repo внутри fn атомарны и видят изменения друг друга.
+// Если fn возвращает ошибку, изменения откатываются, а ошибка возвращается без изменений.
+// При ошибке сериализации транзакция повторяется целиком, поэтому fn не должна иметь внешних побочных эффектов;
+// если все попытки не сериализовались, возвращается ошибка с ErrTxConflict.
+// Вложенный вызов на repo выполняется в точке сохранения внешней транзакции и не повторяется отдельно.
+func (r *SQLRepository) WithinTx(ctx context.Context, fn func(repo Repository) error, opts ...TxOption) error {
+	options := newTxOptions(opts)
+	if r.inTx {
+		return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(r.withTx(tx))
+		})
+	}
+
+	var err error
+	for attempt := 1; attempt <= options.maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(txRetryDelay * time.Duration(attempt-1)):
+			}
+		}
+
+		err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(r.withTx(tx))
+		}, &sql.TxOptions{Isolation: options.isolation})
+		if err == nil || !r.dialect.isSerializationFailure(err) {
+			return err
+		}
+		r.logger.Warn(fmt.Sprintf("transaction attempt %d of %d failed to serialize", attempt, options.maxAttempts), slog.Any("error", err))
+	}
+
+	r.logger.Error(fmt.Sprintf("transaction failed to serialize after %d attempts", options.maxAttempts), slog.Any("error", err))
+	return fmt.Errorf("%w after %d attempts: %w", ErrTxConflict, options.maxAttempts, err)
+}
+
+// withTx возвращает репозиторий, выполняющий все запросы в транзакции tx
+func (r *SQLRepository) withTx(tx *gorm.DB) *SQLRepository {
+	txRepo := *r
+	txRepo.db = tx
+	txRepo.inTx = true
+	return &txRepo
+}
+
+// TxVerifications возвращает хранилище токенов подтверждения, работающее в той же транзакции, что и repo,
+// полученный внутри WithinTx. ok=false, если хранилище пользователей не хранит токены подтверждения.
+func TxVerifications(repo Repository) (store VerificationRepository, ok bool) {
+	for {
+		if store, ok := repo.(VerificationRepository); ok {
+			return store, true
+		}
+		wrapper, ok := repo.(interface{ Unwrap() Repository })
+		if !ok {
+			return nil, false
+		}
+		repo = wrapper.Unwrap()
+	}
+}
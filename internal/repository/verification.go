@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Назначения одноразовых токенов
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposePasswordReset     = "password_reset"
+)
+
+var ErrVerificationTokenInvalid = errors.New("verification token is invalid, expired or already used")
+
+// VerificationToken одноразовый токен подтверждения; сам токен хранится только в виде хеша
+type VerificationToken struct {
+	ID        uint
+	UserID    uint
+	Purpose   string
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// VerificationRepository хранилище одноразовых токенов и состояния подтверждения почты
+type VerificationRepository interface {
+	CreateVerificationToken(ctx context.Context, token *VerificationToken) (*VerificationToken, error)
+	GetVerificationToken(ctx context.Context, purpose, tokenHash string, now time.Time) (*VerificationToken, error)
+	ConsumeVerificationToken(ctx context.Context, purpose, tokenHash string, now time.Time) (*VerificationToken, error)
+	InvalidateVerificationTokens(ctx context.Context, userID uint, purpose string) error
+	PurgeExpiredVerificationTokens(ctx context.Context, before time.Time) (int64, error)
+	MarkEmailVerified(ctx context.Context, userID uint, email string, at time.Time) error
+	GetEmailVerifiedAt(ctx context.Context, userID uint) (*time.Time, error)
+}
+
+// CreateVerificationToken сохраняет новый одноразовый токен
+func (r *SQLRepository) CreateVerificationToken(ctx context.Context, token *VerificationToken) (*VerificationToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("CreateVerificationToken operation canceled for user ID: %d", token.UserID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	gormToken := &GormVerificationToken{
+		UserID:    token.UserID,
+		Purpose:   token.Purpose,
+		TokenHash: token.TokenHash,
+		Email:     token.Email,
+		ExpiresAt: token.ExpiresAt,
+	}
+	if err := r.db.WithContext(ctx).Create(gormToken).Error; err != nil {
+		r.logger.Error(fmt.Sprintf("failed to create %s token for user ID: %d", token.Purpose, token.UserID), slog.Any("error", err))
+		return nil, err
+	}
+
+	r.logger.Debug(fmt.Sprintf("%s token created for user ID: %d", token.Purpose, token.UserID))
+	return convertToVerificationToken(gormToken), nil
+}
+
+// GetVerificationToken возвращает действующий токен, не помечая его использованным.
+// Возвращает ErrVerificationTokenInvalid, если токен не найден, истек или уже использован.
+func (r *SQLRepository) GetVerificationToken(ctx context.Context, purpose, tokenHash string, now time.Time) (*VerificationToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetVerificationToken operation canceled for purpose: %s", purpose), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormToken GormVerificationToken
+	err := r.db.WithContext(ctx).
+		Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, now).
+		First(&gormToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrVerificationTokenInvalid
+		}
+		r.logger.Error(fmt.Sprintf("failed to get %s token", purpose), slog.Any("error", err))
+		return nil, err
+	}
+
+	return convertToVerificationToken(&gormToken), nil
+}
+
+// ConsumeVerificationToken атомарно помечает токен использованным.
+// Возвращает ErrVerificationTokenInvalid, если токен не найден, истек или уже использован.
+func (r *SQLRepository) ConsumeVerificationToken(ctx context.Context, purpose, tokenHash string, now time.Time) (*VerificationToken, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("ConsumeVerificationToken operation canceled for purpose: %s", purpose), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormToken GormVerificationToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Условное обновление гарантирует, что токен сработает ровно один раз даже при параллельных запросах
+		result := tx.Model(&GormVerificationToken{}).
+			Where("purpose = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", purpose, tokenHash, now).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVerificationTokenInvalid
+		}
+		return tx.Where("purpose = ? AND token_hash = ?", purpose, tokenHash).First(&gormToken).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrVerificationTokenInvalid) {
+			r.logger.Error(fmt.Sprintf("failed to consume %s token", purpose), slog.Any("error", err))
+		}
+		return nil, err
+	}
+
+	return convertToVerificationToken(&gormToken), nil
+}
+
+// InvalidateVerificationTokens аннулирует все неиспользованные токены пользователя с данным назначением
+func (r *SQLRepository) InvalidateVerificationTokens(ctx context.Context, userID uint, purpose string) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("InvalidateVerificationTokens operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Model(&GormVerificationToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		r.logger.Error(fmt.Sprintf("failed to invalidate %s tokens for user ID: %d", purpose, userID), slog.Any("error", result.Error))
+		return result.Error
+	}
+
+	return nil
+}
+
+// PurgeExpiredVerificationTokens удаляет токены, истекшие до указанного момента
+func (r *SQLRepository) PurgeExpiredVerificationTokens(ctx context.Context, before time.Time) (int64, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	result := r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&GormVerificationToken{})
+	if result.Error != nil {
+		r.logger.Error("failed to purge expired verification tokens", slog.Any("error", result.Error))
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+// MarkEmailVerified отмечает почту подтвержденной, если адрес пользователя не изменился с момента отправки токена
+func (r *SQLRepository) MarkEmailVerified(ctx context.Context, userID uint, email string, at time.Time) error {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("MarkEmailVerified operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return ctx.Err()
+	default:
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previousUser GormUser
+		err := tx.Clauses(lockForUpdate).
+			Where("id = ? AND email = ?", userID, email).
+			First(&previousUser).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		if err := tx.Model(&GormUser{}).Where("id = ?", userID).Update("email_verified_at", at).Error; err != nil {
+			return err
+		}
+		verifiedUser := previousUser
+		verifiedUser.EmailVerifiedAt = &at
+		return appendAudit(ctx, tx, AuditActionVerifyEmail, userID, &previousUser, &verifiedUser)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			r.logger.Warn(fmt.Sprintf("email changed or user not found when verifying user ID: %d", userID))
+			return ErrUserNotFound
+		}
+		r.logger.Error(fmt.Sprintf("failed to mark email verified for user ID: %d", userID), slog.Any("error", err))
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("email verified for user ID: %d", userID))
+	return nil
+}
+
+// GetEmailVerifiedAt возвращает дату подтверждения почты или nil, если почта не подтверждена
+func (r *SQLRepository) GetEmailVerifiedAt(ctx context.Context, userID uint) (*time.Time, error) {
+	// Проверка отмены контекста
+	select {
+	case <-ctx.Done():
+		r.logger.Error(fmt.Sprintf("GetEmailVerifiedAt operation canceled for user ID: %d", userID), slog.Any("error", ctx.Err()))
+		return nil, ctx.Err()
+	default:
+	}
+
+	var gormUser GormUser
+	if err := r.db.WithContext(ctx).Select("id", "email_verified_at").First(&gormUser, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		r.logger.Error(fmt.Sprintf("failed to get email verification state for user ID: %d", userID), slog.Any("error", err))
+		return nil, err
+	}
+
+	return gormUser.EmailVerifiedAt, nil
+}
+
+// convertToVerificationToken преобразует GormVerificationToken в VerificationToken
+func convertToVerificationToken(gormToken *GormVerificationToken) *VerificationToken {
+	return &VerificationToken{
+		ID:        gormToken.ID,
+		UserID:    gormToken.UserID,
+		Purpose:   gormToken.Purpose,
+		TokenHash: gormToken.TokenHash,
+		Email:     gormToken.Email,
+		ExpiresAt: gormToken.ExpiresAt,
+		UsedAt:    gormToken.UsedAt,
+		CreatedAt: gormToken.CreatedAt,
+	}
+}
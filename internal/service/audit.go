@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ключи метаданных со сведениями о вызове для журнала изменений
+const (
+	actorMetadataKey     = "x-actor"
+	requestIDMetadataKey = "x-request-id"
+)
+
+// Ограничения длины сведений о вызове, записываемых в журнал
+const (
+	maxActorLength     = 255
+	maxRequestIDLength = 128
+)
+
+// WithAudit включает чтение журнала изменений пользователей
+func WithAudit(store repository.AuditRepository) Option {
+	return func(s *UserService) {
+		s.audits = store
+	}
+}
+
+// AuditUnaryInterceptor передает в контекст запроса исполнителя из x-actor и идентификатор запроса
+// из x-request-id; без x-request-id идентификатор создается и возвращается клиенту в заголовке ответа.
+// Изменения пользователей в запросе записываются в журнал с этими сведениями.
+func AuditUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		audit := repository.AuditInfo{
+			Actor:     truncate(firstMetadataValue(md, actorMetadataKey), maxActorLength),
+			RequestID: truncate(firstMetadataValue(md, requestIDMetadataKey), maxRequestIDLength),
+		}
+		if audit.RequestID == "" {
+			audit.RequestID = newRequestID()
+			_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, audit.RequestID))
+		}
+		return handler(repository.WithAuditInfo(ctx, audit), req)
+	}
+}
+
+// newRequestID создает случайный идентификатор запроса
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// ListAuditEvents возвращает страницу журнала изменений пользователей, от новых событий к старым.
+// Размер страницы больше допустимого уменьшается до максимума.
+func (s *UserService) ListAuditEvents(ctx context.Context, req *userProto.ListAuditEventsRequest) (*userProto.ListAuditEventsResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ListAuditEvents"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.audits == nil {
+		return nil, status.Error(codes.Unimplemented, "audit log is not configured")
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	if req.UserId < 0 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "user_id",
+			Description: "must not be negative",
+		})
+	}
+	if req.PageSize < 0 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "page_size",
+			Description: "must not be negative",
+		})
+	}
+	if len(violations) > 0 {
+		return nil, invalidArgumentError("invalid audit log request", violations)
+	}
+
+	page, err := s.audits.ListAuditEvents(ctx, repository.ListAuditEventsOptions{
+		UserID:   uint(req.UserId),
+		PageSize: int(req.PageSize),
+		Cursor:   req.PageToken,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		s.logger.ErrorContext(ctx, "failed to list audit events", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to list audit events")
+	}
+
+	resp := &userProto.ListAuditEventsResponse{
+		Events:        make([]*userProto.AuditEvent, 0, len(page.Events)),
+		NextPageToken: page.NextCursor,
+	}
+	for _, event := range page.Events {
+		resp.Events = append(resp.Events, convertToProtoAuditEvent(event))
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("listed %d audit events", len(resp.Events)))
+	return resp, nil
+}
+
+// convertToProtoAuditEvent преобразует событие журнала репозитория в ответ RPC
+func convertToProtoAuditEvent(event *repository.AuditEvent) *userProto.AuditEvent {
+	fields := make([]string, 0, len(event.Changes))
+	for field := range event.Changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	changes := make([]*userProto.AuditFieldChange, 0, len(fields))
+	for _, field := range fields {
+		change := event.Changes[field]
+		changes = append(changes, &userProto.AuditFieldChange{
+			Field:    field,
+			Before:   change.Before,
+			After:    change.After,
+			Redacted: change.Redacted,
+		})
+	}
+
+	return &userProto.AuditEvent{
+		Id:        int64(event.ID),
+		Actor:     event.Actor,
+		Action:    event.Action,
+		UserId:    int64(event.UserID),
+		Changes:   changes,
+		RequestId: event.RequestID,
+		CreatedAt: event.CreatedAt.Format(time.RFC3339),
+	}
+}
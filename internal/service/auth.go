@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/token"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidCredentials единый ответ на неизвестного пользователя и неверный пароль
+var errInvalidCredentials = status.Error(codes.Unauthenticated, "invalid credentials")
+
+// Authenticate проверяет пароль пользователя, заданного именем или электронной почтой
+func (s *UserService) Authenticate(ctx context.Context, req *userProto.AuthenticateRequest) (*userProto.AuthenticateResponse, error) {
+	if err := s.checkContextCancelled(ctx, "Authenticate"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if req.Identifier == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "identifier and password are required")
+	}
+
+	// Пароль проверяется по основной базе: реплика может еще хранить прежний хеш
+	user, err := s.findByIdentifier(repository.WithPrimary(ctx), req.Identifier)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			// Хешируем пароль впустую, чтобы время ответа не выдавало отсутствие пользователя
+			_, _ = s.passwords.Hash(req.Password)
+			s.logger.DebugContext(ctx, "authentication failed: unknown identifier")
+			return nil, errInvalidCredentials
+		}
+		s.logger.ErrorContext(ctx, "failed to get user for authentication", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	valid, err := s.checkCredentials(ctx, user, req.Password, req.OtpCode)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		s.logger.DebugContext(ctx, fmt.Sprintf("authentication failed for user with ID: %d", user.Id))
+		return nil, errInvalidCredentials
+	}
+
+	sessionID, err := s.startSession(ctx, uint(user.Id))
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to start session for user with ID: %d", user.Id), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+
+	resp := &userProto.AuthenticateResponse{User: withoutCredentials(user.User), SessionId: sessionID}
+	if s.tokens != nil {
+		subject, err := s.tokenSubject(ctx, user.User, sessionID)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+		accessToken, expiresAt, err := s.tokens.Issue(subject)
+		if err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to issue access token for user with ID: %d", user.Id), slog.Any("error", err))
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+		resp.AccessToken = accessToken
+		resp.TokenType = "Bearer"
+		resp.ExpiresAt = expiresAt.Format(time.RFC3339)
+	}
+
+	if s.tokens != nil && s.refreshTokens != nil {
+		refreshToken, record, err := s.newRefreshToken(uint(user.Id), sessionID)
+		if err == nil {
+			_, err = s.refreshTokens.CreateRefreshToken(ctx, record)
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to issue refresh token for user with ID: %d", user.Id), slog.Any("error", err))
+			return nil, status.Error(codes.Internal, "failed to authenticate")
+		}
+		resp.RefreshToken = refreshToken
+		resp.RefreshExpiresAt = record.ExpiresAt.Format(time.RFC3339)
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("user authenticated successfully with ID: %d", user.Id))
+	return resp, nil
+}
+
+// GetJWKS возвращает открытые ключи, которыми другие сервисы проверяют access-токены
+func (s *UserService) GetJWKS(ctx context.Context, _ *userProto.GetJWKSRequest) (*userProto.GetJWKSResponse, error) {
+	if err := s.checkContextCancelled(ctx, "GetJWKS"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.tokens == nil {
+		return nil, status.Error(codes.Unimplemented, "access tokens are not configured")
+	}
+
+	jwks := s.tokens.JWKS()
+	resp := &userProto.GetJWKSResponse{Keys: make([]*userProto.JWK, 0, len(jwks.Keys))}
+	for _, key := range jwks.Keys {
+		resp.Keys = append(resp.Keys, &userProto.JWK{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			Crv: key.Crv,
+			X:   key.X,
+			N:   key.N,
+			E:   key.E,
+		})
+	}
+	return resp, nil
+}
+
+// tokenSubject собирает данные пользователя, его роли и сеанс для access-токена
+func (s *UserService) tokenSubject(ctx context.Context, user *userProto.User, sessionID string) (token.Subject, error) {
+	subject := token.Subject{
+		UserID:   user.Id,
+		Username: user.Username,
+		Session:  sessionID,
+	}
+	if s.roles != nil {
+		roles, err := s.roles.UserRoles(ctx, uint(user.Id))
+		if err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get roles for user with ID: %d", user.Id), slog.Any("error", err))
+			return token.Subject{}, err
+		}
+		subject.Roles = roles
+	}
+	return subject, nil
+}
+
+// findByIdentifier ищет пользователя по электронной почте, если идентификатор содержит "@", иначе по имени
+func (s *UserService) findByIdentifier(ctx context.Context, identifier string) (*repository.User, error) {
+	if strings.Contains(identifier, "@") {
+		return s.repo.GetUserByEmail(ctx, identifier)
+	}
+	return s.repo.GetUserByUsername(ctx, identifier)
+}
+
+// withoutCredentials возвращает копию пользователя без хеша пароля и соли
+func withoutCredentials(user *userProto.User) *userProto.User {
+	return &userProto.User{
+		Id:        user.Id,
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/encryption"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"github.com/watchlist-kata/user/internal/token"
+	"github.com/watchlist-kata/user/internal/totp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// insertLegacyUser добавляет запись с паролем так, как ее создавала версия сервиса без канонических форм
+func insertLegacyUser(t *testing.T, s *UserService, db *gorm.DB, id uint, username, email, password string) {
+	t.Helper()
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	err = db.Exec(`INSERT INTO "user" (id, username, email, pwdhash, salt) VALUES (?, ?, ?, ?, '')`, id, username, email, hashedPassword).Error
+	if err != nil {
+		t.Fatalf("failed to insert legacy user %q: %v", username, err)
+	}
+}
+
+// errorReason возвращает причину из ErrorInfo в деталях статуса или пустую строку
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+func TestAuthenticateCollidedIdentity(t *testing.T) {
+	ctx := context.Background()
+	db := repositorytest.OpenMigratedSQLite(t)
+	repo := repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+	s := NewUserService(repo, repositorytest.DiscardLogger())
+
+	// Второй пользователь при заполнении форм получает имя и почту с суффиксом ID
+	insertLegacyUser(t, s, db, 1, "Alice", "alice@example.com", "first-password")
+	insertLegacyUser(t, s, db, 2, "ALICE", "Alice@Example.com", "second-password")
+	if _, err := repo.BackfillCanonical(ctx); err != nil {
+		t.Fatalf("BackfillCanonical: %v", err)
+	}
+
+	// Прежнее имя находит владельца формы, поэтому пароль второго пользователя к нему не подходит
+	_, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "ALICE", Password: "second-password"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Authenticate by the original username error = %v, want code %v", err, codes.Unauthenticated)
+	}
+
+	// По форме с суффиксом пользователь находится, но до переименования не входит
+	_, err = s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice#2", Password: "second-password"})
+	if status.Code(err) != codes.FailedPrecondition || errorReason(err) != "IDENTITY_COLLISION" {
+		t.Errorf("Authenticate by the suffixed username error = %v, want code %v with reason IDENTITY_COLLISION", err, codes.FailedPrecondition)
+	}
+	_, err = s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice#2", Password: "wrong-password"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Authenticate with a wrong password error = %v, want code %v", err, codes.Unauthenticated)
+	}
+
+	// Владелец формы входит как прежде
+	if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "first-password"}); err != nil {
+		t.Errorf("Authenticate of the earlier user: %v", err)
+	}
+
+	// После смены имени и почты суффикс снимается
+	later, err := repo.GetUserByID(ctx, 2)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	later.Username = "alice2"
+	later.Email = "alice2@example.com"
+	if _, err := repo.UpdateUser(ctx, later.User, later.Version); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice2", Password: "second-password"}); err != nil {
+		t.Errorf("Authenticate after rename: %v", err)
+	}
+}
+
+func TestAuthenticateIncludesUserRoles(t *testing.T) {
+	ctx := context.Background()
+	db := repositorytest.OpenMigratedSQLite(t)
+	repo := repository.NewSQLRepository(db, repositorytest.DiscardLogger())
+
+	key, err := token.GenerateEd25519SigningKey("test")
+	if err != nil {
+		t.Fatalf("GenerateEd25519SigningKey: %v", err)
+	}
+	issuer, err := token.NewIssuer(token.Config{Issuer: "watchlist-user", TTL: time.Minute}, []*token.SigningKey{key}, "")
+	if err != nil {
+		t.Fatalf("NewIssuer: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithTokenIssuer(issuer), WithRoles(repo))
+
+	insertLegacyUser(t, s, db, 1, "alice", "alice@example.com", "password")
+	insertLegacyUser(t, s, db, 2, "bob", "bob@example.com", "password")
+	if err := db.Exec(`INSERT INTO user_role (user_id, role) VALUES (1, 'editor'), (1, 'admin'), (2, 'viewer')`).Error; err != nil {
+		t.Fatalf("failed to insert roles: %v", err)
+	}
+
+	resp, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	claims, err := issuer.Verify(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if want := []string{"admin", "editor"}; !slices.Equal(claims.Roles, want) {
+		t.Errorf("roles claim = %v, want %v", claims.Roles, want)
+	}
+}
+
+// createUserWithPassword создает пользователя с хешем пароля, который примет Authenticate
+func createUserWithPassword(t *testing.T, s *UserService, repo repository.Repository, username, password string) *repository.User {
+	t.Helper()
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	user, err := repo.CreateUser(context.Background(), &userProto.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Pwdhash:  hashedPassword,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+func TestAuthenticateIdentifier(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	s := NewUserService(repo, repositorytest.DiscardLogger())
+	user := createUserWithPassword(t, s, repo, "alice", "password")
+
+	tests := []struct {
+		name       string
+		identifier string
+		password   string
+		want       codes.Code
+	}{
+		{name: "username", identifier: "alice", password: "password", want: codes.OK},
+		{name: "username in another case", identifier: "ALICE", password: "password", want: codes.OK},
+		{name: "email", identifier: "alice@example.com", password: "password", want: codes.OK},
+		{name: "email in another case", identifier: "Alice@Example.COM", password: "password", want: codes.OK},
+		{name: "username as email", identifier: "alice@", password: "password", want: codes.Unauthenticated},
+		{name: "wrong password", identifier: "alice", password: "wrong", want: codes.Unauthenticated},
+		{name: "unknown username", identifier: "bob", password: "password", want: codes.Unauthenticated},
+		{name: "unknown email", identifier: "bob@example.com", password: "password", want: codes.Unauthenticated},
+		{name: "no identifier", password: "password", want: codes.InvalidArgument},
+		{name: "no password", identifier: "alice", want: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: tt.identifier, Password: tt.password})
+			if got := status.Code(err); got != tt.want {
+				t.Fatalf("Authenticate error = %v, want code %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if resp.User.Id != user.Id || resp.User.Pwdhash != "" || resp.User.Salt != "" {
+				t.Errorf("Authenticate returned user %v, want user %d without credentials", resp.User, user.Id)
+			}
+			if resp.SessionId != "" || resp.AccessToken != "" {
+				t.Errorf("Authenticate returned session %q and access token %q without sessions and tokens configured", resp.SessionId, resp.AccessToken)
+			}
+		})
+	}
+
+	// Неизвестный пользователь и неверный пароль неразличимы для клиента
+	_, unknown := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "bob", Password: "password"})
+	_, wrong := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "wrong"})
+	if unknown.Error() != wrong.Error() {
+		t.Errorf("unknown identifier error %q differs from wrong password error %q", unknown, wrong)
+	}
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	s := NewUserService(repo, repositorytest.DiscardLogger(),
+		WithLockout(repo, LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: time.Hour, ResetAfter: time.Hour}))
+	createUserWithPassword(t, s, repo, "alice", "password")
+	createUserWithPassword(t, s, repo, "bob", "password")
+
+	// Успешный вход снимает засчитанные неудачные попытки
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Authenticate with a wrong password error = %v, want code %v", err, codes.Unauthenticated)
+		}
+	}
+	if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "password"}); err != nil {
+		t.Fatalf("Authenticate below the threshold: %v", err)
+	}
+
+	// После порога неудачных попыток отклоняется и верный пароль, в том числе при входе по почте
+	for i := 0; i < 3; i++ {
+		if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Authenticate with a wrong password error = %v, want code %v", err, codes.Unauthenticated)
+		}
+	}
+	_, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice@example.com", Password: "password"})
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != "ACCOUNT_LOCKED" {
+		t.Errorf("Authenticate of a locked account error = %v, want code %v with reason ACCOUNT_LOCKED", err, codes.ResourceExhausted)
+	}
+
+	// Блокировка не затрагивает других пользователей
+	if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "bob", Password: "password"}); err != nil {
+		t.Errorf("Authenticate of another user: %v", err)
+	}
+}
+
+func TestAuthenticateSecondFactor(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	cipher, err := encryption.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithMFA(repo, cipher, "Watchlist"))
+	user := createUserWithPassword(t, s, repo, "alice", "password")
+	secret, step, recoveryCodes := enrollTOTP(t, s, user.Id)
+
+	nextCode, err := totp.Code(secret, step+1)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		otpCode string
+		wantErr error
+	}{
+		{name: "no code", wantErr: errSecondFactorRequired},
+		{name: "wrong code", otpCode: "000000", wantErr: errInvalidSecondFactor},
+		{name: "valid code", otpCode: nextCode},
+		{name: "reused code", otpCode: nextCode, wantErr: errInvalidSecondFactor},
+		{name: "recovery code", otpCode: recoveryCodes[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "password", OtpCode: tt.otpCode})
+			if err != tt.wantErr {
+				t.Errorf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Код второго фактора не проверяется при неверном пароле
+	_, err = s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "wrong", OtpCode: recoveryCodes[1]})
+	if err != errInvalidCredentials {
+		t.Fatalf("Authenticate with a wrong password error = %v, want %v", err, errInvalidCredentials)
+	}
+	if _, err := s.Authenticate(ctx, &userProto.AuthenticateRequest{Identifier: "alice", Password: "password", OtpCode: recoveryCodes[1]}); err != nil {
+		t.Errorf("Authenticate with an unspent recovery code: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetUsersByIDs возвращает пользователей без хешей паролей одним запросом в порядке запроса.
+// Повторяющиеся ID возвращаются один раз, ненайденные перечисляются в MissingIds.
+func (s *UserService) GetUsersByIDs(ctx context.Context, req *userProto.GetUsersByIDsRequest) (*userProto.GetUsersByIDsResponse, error) {
+	if err := s.checkContextCancelled(ctx, "GetUsersByIDs"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	ids := make([]uint, 0, len(req.Ids))
+	for i, id := range req.Ids {
+		if id <= 0 {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprintf("ids[%d]", i),
+				Description: "must be positive",
+			})
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(req.Ids) > repository.MaxUserBatchSize {
+		violations = append(violations, batchSizeViolation("ids"))
+	}
+	if len(violations) > 0 {
+		return nil, invalidArgumentError("invalid batch request", violations)
+	}
+
+	users, missing, err := s.repo.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get %d users by ID", len(ids)), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	resp := &userProto.GetUsersByIDsResponse{
+		Users:      usersWithoutCredentials(users),
+		MissingIds: make([]int64, 0, len(missing)),
+	}
+	for _, id := range missing {
+		resp.MissingIds = append(resp.MissingIds, int64(id))
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("fetched %d users by ID, %d missing", len(resp.Users), len(resp.MissingIds)))
+	return resp, nil
+}
+
+// GetUsersByUsernames возвращает пользователей без хешей паролей одним запросом в порядке запроса.
+// Имена сравниваются без учета регистра; ненайденные перечисляются в MissingUsernames.
+func (s *UserService) GetUsersByUsernames(ctx context.Context, req *userProto.GetUsersByUsernamesRequest) (*userProto.GetUsersByUsernamesResponse, error) {
+	if err := s.checkContextCancelled(ctx, "GetUsersByUsernames"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	for i, username := range req.Usernames {
+		if username == "" {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fmt.Sprintf("usernames[%d]", i),
+				Description: "must not be empty",
+			})
+		}
+	}
+	if len(req.Usernames) > repository.MaxUserBatchSize {
+		violations = append(violations, batchSizeViolation("usernames"))
+	}
+	if len(violations) > 0 {
+		return nil, invalidArgumentError("invalid batch request", violations)
+	}
+
+	users, missing, err := s.repo.GetUsersByUsernames(ctx, req.Usernames)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get %d users by username", len(req.Usernames)), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	resp := &userProto.GetUsersByUsernamesResponse{
+		Users:            usersWithoutCredentials(users),
+		MissingUsernames: missing,
+	}
+	if resp.MissingUsernames == nil {
+		resp.MissingUsernames = []string{}
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("fetched %d users by username, %d missing", len(resp.Users), len(resp.MissingUsernames)))
+	return resp, nil
+}
+
+// batchSizeViolation описывает превышение размера пакетного запроса
+func batchSizeViolation(field string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf("must contain at most %d entries", repository.MaxUserBatchSize),
+	}
+}
+
+// usersWithoutCredentials возвращает копии пользователей без хешей паролей и соли
+func usersWithoutCredentials(users []*repository.User) []*userProto.User {
+	result := make([]*userProto.User, 0, len(users))
+	for _, user := range users {
+		result = append(result, withoutCredentials(user.User))
+	}
+	return result
+}
@@ -0,0 +1,422 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Идентификаторы поддерживаемых алгоритмов хеширования паролей
+const (
+	AlgorithmArgon2id     = "argon2id"
+	AlgorithmBcryptSHA256 = "bcrypt-sha256"
+	AlgorithmScrypt       = "scrypt"
+)
+
+// ErrUnknownHashAlgorithm возвращается, если закодированный хеш создан неизвестным алгоритмом
+var ErrUnknownHashAlgorithm = errors.New("unknown password hash algorithm")
+
+// errMalformedHash возвращается, если закодированный хеш не удается разобрать
+var errMalformedHash = errors.New("malformed password hash")
+
+// errPepperNotConfigured возвращается, если хеш создан с перцем, а перец не задан
+var errPepperNotConfigured = errors.New("password hash requires a pepper that is not configured")
+
+// PasswordHasher описывает алгоритм хеширования паролей.
+// Закодированный хеш самоописываемый: он начинается с "$<алгоритм>$" и содержит параметры и соль.
+type PasswordHasher interface {
+	// Algorithm возвращает идентификатор алгоритма
+	Algorithm() string
+	// Hash хеширует пароль и возвращает закодированный хеш
+	Hash(password string) (string, error)
+	// Verify сравнивает пароль с закодированным хешем
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash сообщает, что хеш создан с параметрами слабее текущих
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswordHasher создает алгоритм хеширования по имени из конфигурации с параметрами по умолчанию.
+// pepper используется только bcrypt-sha256; пустой pepper оставляет прежний формат без перца.
+func NewPasswordHasher(algorithm string, pepper []byte) (PasswordHasher, error) {
+	switch algorithm {
+	case AlgorithmArgon2id, "":
+		return NewArgon2idHasher(), nil
+	case AlgorithmBcryptSHA256, "bcrypt":
+		return NewBcryptHasher(bcrypt.DefaultCost, pepper), nil
+	case AlgorithmScrypt:
+		return NewScryptHasher(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHashAlgorithm, algorithm)
+	}
+}
+
+// PasswordHashers хеширует новые пароли текущим алгоритмом и проверяет хеши любого известного алгоритма
+type PasswordHashers struct {
+	current PasswordHasher
+	known   map[string]PasswordHasher
+}
+
+// NewPasswordHashers создает набор алгоритмов, в котором current используется для новых паролей.
+// Встроенные алгоритмы регистрируются автоматически, чтобы старые хеши оставались проверяемыми.
+func NewPasswordHashers(current PasswordHasher, others ...PasswordHasher) *PasswordHashers {
+	p := &PasswordHashers{
+		current: current,
+		known:   make(map[string]PasswordHasher),
+	}
+	for _, h := range []PasswordHasher{NewArgon2idHasher(), NewBcryptHasher(bcrypt.DefaultCost, nil), NewScryptHasher()} {
+		p.known[h.Algorithm()] = h
+	}
+	for _, h := range others {
+		p.known[h.Algorithm()] = h
+	}
+	p.known[current.Algorithm()] = current
+	return p
+}
+
+// DefaultPasswordHashers возвращает набор с argon2id в качестве текущего алгоритма
+func DefaultPasswordHashers() *PasswordHashers {
+	return NewPasswordHashers(NewArgon2idHasher())
+}
+
+// Hash хеширует пароль текущим алгоритмом
+func (p *PasswordHashers) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify проверяет пароль и сообщает, нужно ли перехешировать его текущим алгоритмом.
+// Непустой legacySalt означает запись старого формата: bcrypt от password + salt.
+func (p *PasswordHashers) Verify(password, encoded, legacySalt string) (valid bool, rehash bool, err error) {
+	if legacySalt != "" {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password+legacySalt))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, fmt.Errorf("failed to verify legacy password hash: %w", err)
+		}
+		return true, true, nil
+	}
+
+	algorithm := hashAlgorithm(encoded)
+	hasher, ok := p.known[algorithm]
+	if !ok {
+		return false, false, fmt.Errorf("%w: %q", ErrUnknownHashAlgorithm, algorithm)
+	}
+
+	valid, err = hasher.Verify(password, encoded)
+	if err != nil || !valid {
+		return false, false, err
+	}
+
+	rehash = algorithm != p.current.Algorithm() || hasher.NeedsRehash(encoded)
+	return true, rehash, nil
+}
+
+// hashAlgorithm извлекает идентификатор алгоритма из закодированного хеша
+func hashAlgorithm(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+	algorithm, _, _ := strings.Cut(encoded[1:], "$")
+	return algorithm
+}
+
+// randomBytes возвращает n криптографически случайных байт
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// parseParams разбирает параметры вида "k1=v1,k2=v2" в целые числа
+func parseParams(s string, keys ...string) (map[string]int, error) {
+	params := make(map[string]int, len(keys))
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errMalformedHash
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, errMalformedHash
+		}
+		params[key] = n
+	}
+	for _, key := range keys {
+		if _, ok := params[key]; !ok {
+			return nil, errMalformedHash
+		}
+	}
+	return params, nil
+}
+
+// Argon2idHasher хеширует пароли алгоритмом argon2id в формате PHC:
+// $argon2id$v=19$m=<KiB>,t=<итерации>,p=<потоки>$<соль>$<хеш>
+type Argon2idHasher struct {
+	Memory     uint32 // Объем памяти в KiB
+	Iterations uint32 // Количество проходов
+	Threads    uint8  // Степень параллелизма
+	SaltLength int    // Длина соли в байтах
+	KeyLength  uint32 // Длина хеша в байтах
+}
+
+// NewArgon2idHasher создает argon2id с параметрами, рекомендованными OWASP
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:     19 * 1024,
+		Iterations: 2,
+		Threads:    1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+// Algorithm возвращает идентификатор алгоритма
+func (h *Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+// Hash хеширует пароль
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomBytes(h.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Threads, h.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", AlgorithmArgon2id, argon2.Version,
+		h.Memory, h.Iterations, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify сравнивает пароль с хешем
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, uint32(params["t"]), uint32(params["m"]), uint8(params["p"]), uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// NeedsRehash сообщает, что хеш создан с более слабыми параметрами
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return uint32(params["m"]) < h.Memory || uint32(params["t"]) < h.Iterations ||
+		uint8(params["p"]) < h.Threads || uint32(len(key)) < h.KeyLength
+}
+
+// decode разбирает закодированный хеш argon2id
+func (h *Argon2idHasher) decode(encoded string) (map[string]int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, nil, nil, errMalformedHash
+	}
+	params, err := parseParams(parts[3], "m", "t", "p")
+	if err != nil || params["p"] > 255 {
+		return nil, nil, nil, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errMalformedHash
+	}
+	return params, salt, key, nil
+}
+
+// ScryptHasher хеширует пароли алгоритмом scrypt в формате
+// $scrypt$ln=<log2 N>,r=<размер блока>,p=<параллелизм>$<соль>$<хеш>
+type ScryptHasher struct {
+	LogN       int // Логарифм параметра стоимости N
+	R          int // Размер блока
+	P          int // Степень параллелизма
+	SaltLength int // Длина соли в байтах
+	KeyLength  int // Длина хеша в байтах
+}
+
+// NewScryptHasher создает scrypt с параметрами N=2^15, r=8, p=1
+func NewScryptHasher() *ScryptHasher {
+	return &ScryptHasher{
+		LogN:       15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+// Algorithm возвращает идентификатор алгоритма
+func (h *ScryptHasher) Algorithm() string {
+	return AlgorithmScrypt
+}
+
+// Hash хеширует пароль
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomBytes(h.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, h.KeyLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", AlgorithmScrypt, h.LogN, h.R, h.P,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify сравнивает пароль с хешем
+func (h *ScryptHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual, err := scrypt.Key([]byte(password), salt, 1<<params["ln"], params["r"], params["p"], len(key))
+	if err != nil {
+		return false, fmt.Errorf("failed to verify password hash: %w", err)
+	}
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+// NeedsRehash сообщает, что хеш создан с более слабыми параметрами
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params["ln"] < h.LogN || params["r"] < h.R || params["p"] < h.P || len(key) < h.KeyLength
+}
+
+// decode разбирает закодированный хеш scrypt
+func (h *ScryptHasher) decode(encoded string) (map[string]int, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != AlgorithmScrypt {
+		return nil, nil, nil, errMalformedHash
+	}
+	params, err := parseParams(parts[2], "ln", "r", "p")
+	if err != nil || params["ln"] > 30 {
+		return nil, nil, nil, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, nil, nil, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errMalformedHash
+	}
+	return params, salt, key, nil
+}
+
+// bcryptPepperedMarker отмечает хеши bcrypt-sha256, в которых пароль сведен через HMAC-SHA256 с перцем
+const bcryptPepperedMarker = "$v=2"
+
+// BcryptHasher хеширует пароли bcrypt поверх SHA-256 от пароля, чтобы обойти ограничение bcrypt в 72 байта.
+// С перцем пароль сводится через HMAC-SHA256 с секретным ключом: хеш нельзя подобрать по утекшим
+// несоленым SHA-256 без знания перца. Форматы:
+//
+//	$bcrypt-sha256$<хеш bcrypt без ведущего "$">     - base64(SHA-256(пароль))
+//	$bcrypt-sha256$v=2$<хеш bcrypt без ведущего "$"> - base64(HMAC-SHA256(перец, пароль))
+//
+// Смена перца делает все хеши второго формата непроверяемыми, поэтому перец не ротируется.
+type BcryptHasher struct {
+	Cost   int    // Стоимость bcrypt
+	Pepper []byte // Секретный ключ HMAC; пусто - хеши создаются без перца
+}
+
+// NewBcryptHasher создает bcrypt-sha256 с заданной стоимостью и перцем
+func NewBcryptHasher(cost int, pepper []byte) *BcryptHasher {
+	return &BcryptHasher{Cost: cost, Pepper: pepper}
+}
+
+// Algorithm возвращает идентификатор алгоритма
+func (h *BcryptHasher) Algorithm() string {
+	return AlgorithmBcryptSHA256
+}
+
+// Hash хеширует пароль
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	prefix, prehashed := "$"+AlgorithmBcryptSHA256, prehash(password)
+	if len(h.Pepper) > 0 {
+		prefix, prehashed = prefix+bcryptPepperedMarker, prehashHMAC(password, h.Pepper)
+	}
+	hash, err := bcrypt.GenerateFromPassword(prehashed, h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return prefix + string(hash), nil
+}
+
+// Verify сравнивает пароль с хешем
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	hash, peppered, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	prehashed := prehash(password)
+	if peppered {
+		if len(h.Pepper) == 0 {
+			return false, errPepperNotConfigured
+		}
+		prehashed = prehashHMAC(password, h.Pepper)
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), prehashed)
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to verify password hash: %w", err)
+	}
+	return true, nil
+}
+
+// NeedsRehash сообщает, что хеш создан с меньшей стоимостью или без перца, когда перец задан
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	hash, peppered, err := h.decode(encoded)
+	if err != nil || peppered != (len(h.Pepper) > 0) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// decode отделяет хеш bcrypt от префикса и сообщает, создан ли он с перцем
+func (h *BcryptHasher) decode(encoded string) (string, bool, error) {
+	hash, ok := strings.CutPrefix(encoded, "$"+AlgorithmBcryptSHA256)
+	if !ok {
+		return "", false, errMalformedHash
+	}
+	if hash, ok := strings.CutPrefix(hash, bcryptPepperedMarker); ok {
+		return hash, true, nil
+	}
+	return hash, false, nil
+}
+
+// prehash сводит пароль произвольной длины к 44 байтам base64(SHA-256)
+func prehash(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// prehashHMAC сводит пароль произвольной длины к 44 байтам base64(HMAC-SHA256) с ключом pepper
+func prehashHMAC(password string, pepper []byte) []byte {
+	mac := hmac.New(sha256.New, pepper)
+	mac.Write([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
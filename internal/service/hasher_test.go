@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Параметры ниже минимально допустимых, чтобы тесты выполнялись быстро
+func fastArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 64, Iterations: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func fastScryptHasher() *ScryptHasher {
+	return &ScryptHasher{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	hashers := []PasswordHasher{
+		fastArgon2idHasher(),
+		fastScryptHasher(),
+		NewBcryptHasher(bcrypt.MinCost, nil),
+		NewBcryptHasher(bcrypt.MinCost, []byte("pepper")),
+	}
+	// Пароль длиннее 72 байт проверяет, что bcrypt-sha256 не обрезает пароль
+	long := string(make([]byte, 100)) + "tail"
+	for _, h := range hashers {
+		for _, password := range []string{"correct horse battery staple", "пароль", long} {
+			encoded, err := h.Hash(password)
+			if err != nil {
+				t.Fatalf("%s: Hash: %v", h.Algorithm(), err)
+			}
+			if got := hashAlgorithm(encoded); got != h.Algorithm() {
+				t.Errorf("%s: encoded hash %q has algorithm %q", h.Algorithm(), encoded, got)
+			}
+			if ok, err := h.Verify(password, encoded); err != nil || !ok {
+				t.Errorf("%s: Verify(correct) = %v, %v; want true, nil", h.Algorithm(), ok, err)
+			}
+			if ok, err := h.Verify(password+"x", encoded); err != nil || ok {
+				t.Errorf("%s: Verify(wrong) = %v, %v; want false, nil", h.Algorithm(), ok, err)
+			}
+			if h.NeedsRehash(encoded) {
+				t.Errorf("%s: NeedsRehash of a fresh hash = true", h.Algorithm())
+			}
+		}
+	}
+}
+
+func TestPasswordHasherKnownVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		hasher  PasswordHasher
+		encoded string
+	}{
+		{
+			name:    "argon2id",
+			hasher:  fastArgon2idHasher(),
+			encoded: "$argon2id$v=19$m=64,t=1,p=1$MDEyMzQ1Njc4OWFiY2RlZg$c8unoZL1fMiTW9VzsIzI4XiLIjeYmhsk2dCIUg21RpY",
+		},
+		{
+			name:    "scrypt",
+			hasher:  fastScryptHasher(),
+			encoded: "$scrypt$ln=4,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$jU+wVnnRO8xMJ6kk2pn2W1IFgOT9r8PK+dHZ+HH3bt4",
+		},
+		{
+			name:    "bcrypt-sha256 without pepper",
+			hasher:  NewBcryptHasher(bcrypt.MinCost, nil),
+			encoded: "$bcrypt-sha256$2a$04$FknZrXv5VUV6HX0Po5Owa.154bmGvy4q/aygAQcFELfsaIgumOmRq",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, err := tt.hasher.Verify("password", tt.encoded); err != nil || !ok {
+				t.Errorf("Verify(correct) = %v, %v; want true, nil", ok, err)
+			}
+			if ok, err := tt.hasher.Verify("Password", tt.encoded); err != nil || ok {
+				t.Errorf("Verify(wrong) = %v, %v; want false, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestPrehash(t *testing.T) {
+	// SHA-256("password") в base64
+	if got, want := string(prehash("password")), "XohImNooBHFR0OVvjcYpJ3NgPQ1qq73WKhHvch0VQtg="; got != want {
+		t.Errorf("prehash = %q, want %q", got, want)
+	}
+	// RFC 4231, тест 2
+	if got, want := string(prehashHMAC("what do ya want for nothing?", []byte("Jefe"))), "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM="; got != want {
+		t.Errorf("prehashHMAC = %q, want %q", got, want)
+	}
+}
+
+func TestBcryptHasherPepper(t *testing.T) {
+	plain := NewBcryptHasher(bcrypt.MinCost, nil)
+	peppered := NewBcryptHasher(bcrypt.MinCost, []byte("pepper"))
+
+	encoded, err := peppered.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := NewBcryptHasher(bcrypt.MinCost, []byte("other")).Verify("password", encoded); err != nil || ok {
+		t.Errorf("Verify with another pepper = %v, %v; want false, nil", ok, err)
+	}
+	if _, err := plain.Verify("password", encoded); !errors.Is(err, errPepperNotConfigured) {
+		t.Errorf("Verify without pepper error = %v, want %v", err, errPepperNotConfigured)
+	}
+
+	legacy, err := plain.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, err := peppered.Verify("password", legacy); err != nil || !ok {
+		t.Errorf("Verify of a hash without pepper = %v, %v; want true, nil", ok, err)
+	}
+	if !peppered.NeedsRehash(legacy) {
+		t.Error("NeedsRehash of a hash without pepper = false, want true when the pepper is configured")
+	}
+}
+
+func TestPasswordHashersVerify(t *testing.T) {
+	hash := func(h PasswordHasher, password string) string {
+		t.Helper()
+		encoded, err := h.Hash(password)
+		if err != nil {
+			t.Fatalf("%s: Hash: %v", h.Algorithm(), err)
+		}
+		return encoded
+	}
+	legacyHash := func(password, salt string) string {
+		t.Helper()
+		encoded, err := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("GenerateFromPassword: %v", err)
+		}
+		return string(encoded)
+	}
+
+	current := fastArgon2idHasher()
+	hashers := NewPasswordHashers(current, fastScryptHasher(), NewBcryptHasher(bcrypt.MinCost, nil))
+	weaker := &Argon2idHasher{Memory: 32, Iterations: 1, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+	tests := []struct {
+		name       string
+		password   string
+		encoded    string
+		legacySalt string
+		wantValid  bool
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "current algorithm", password: "password", encoded: hash(current, "password"), wantValid: true},
+		{name: "current algorithm wrong password", password: "wrong", encoded: hash(current, "password")},
+		{name: "weaker parameters", password: "password", encoded: hash(weaker, "password"), wantValid: true, wantRehash: true},
+		{name: "scrypt", password: "password", encoded: hash(fastScryptHasher(), "password"), wantValid: true, wantRehash: true},
+		{name: "bcrypt-sha256", password: "password", encoded: hash(NewBcryptHasher(bcrypt.MinCost, nil), "password"), wantValid: true, wantRehash: true},
+		{name: "legacy bcrypt with salt", password: "password", encoded: legacyHash("password", "salt"), legacySalt: "salt", wantValid: true, wantRehash: true},
+		{name: "legacy bcrypt wrong password", password: "wrong", encoded: legacyHash("password", "salt"), legacySalt: "salt"},
+		{name: "legacy bcrypt wrong salt", password: "password", encoded: legacyHash("password", "salt"), legacySalt: "other"},
+		{name: "unknown algorithm", password: "password", encoded: "$md5$abc", wantErr: ErrUnknownHashAlgorithm},
+		{name: "legacy bcrypt without salt", password: "password", encoded: legacyHash("password", ""), wantErr: ErrUnknownHashAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, rehash, err := hashers.Verify(tt.password, tt.encoded, tt.legacySalt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if valid != tt.wantValid || rehash != tt.wantRehash {
+				t.Errorf("Verify = valid %v, rehash %v; want valid %v, rehash %v", valid, rehash, tt.wantValid, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "", want: AlgorithmArgon2id},
+		{name: AlgorithmArgon2id, want: AlgorithmArgon2id},
+		{name: "bcrypt", want: AlgorithmBcryptSHA256},
+		{name: AlgorithmBcryptSHA256, want: AlgorithmBcryptSHA256},
+		{name: AlgorithmScrypt, want: AlgorithmScrypt},
+		{name: "md5", wantErr: true},
+	}
+	for _, tt := range tests {
+		h, err := NewPasswordHasher(tt.name, nil)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnknownHashAlgorithm) {
+				t.Errorf("NewPasswordHasher(%q) error = %v, want %v", tt.name, err, ErrUnknownHashAlgorithm)
+			}
+			continue
+		}
+		if err != nil || h.Algorithm() != tt.want {
+			t.Errorf("NewPasswordHasher(%q) = %v, %v; want %s", tt.name, h, err, tt.want)
+		}
+	}
+}
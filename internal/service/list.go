@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListUsers возвращает страницу пользователей без хешей паролей.
+// Размер страницы больше допустимого уменьшается до максимума.
+func (s *UserService) ListUsers(ctx context.Context, req *userProto.ListUsersRequest) (*userProto.ListUsersResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ListUsers"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	opts, violations := listUsersOptions(req)
+	if len(violations) > 0 {
+		return nil, invalidArgumentError("invalid list request", violations)
+	}
+
+	page, err := s.repo.ListUsers(ctx, opts)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		if errors.Is(err, repository.ErrInvalidListOptions) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s.logger.ErrorContext(ctx, "failed to list users", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to list users")
+	}
+
+	resp := &userProto.ListUsersResponse{
+		Users:         usersWithoutCredentials(page.Users),
+		NextPageToken: page.NextCursor,
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("listed %d users", len(resp.Users)))
+	return resp, nil
+}
+
+// listUsersOptions переводит запрос в параметры репозитория и собирает ошибки в полях
+func listUsersOptions(req *userProto.ListUsersRequest) (repository.ListUsersOptions, []*errdetails.BadRequest_FieldViolation) {
+	var violations []*errdetails.BadRequest_FieldViolation
+	opts := repository.ListUsersOptions{
+		Filter: repository.ListUsersFilter{
+			UsernamePrefix: req.UsernamePrefix,
+			EmailPrefix:    req.EmailPrefix,
+			Status:         repository.UserStatus(req.Status),
+		},
+		SortBy:     repository.UserSortField(req.SortBy),
+		Descending: req.Descending,
+		PageSize:   int(req.PageSize),
+		Cursor:     req.PageToken,
+	}
+
+	if req.PageSize < 0 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "page_size",
+			Description: "must not be negative",
+		})
+	}
+
+	switch opts.SortBy {
+	case "", repository.SortByID, repository.SortByCreatedAt, repository.SortByUsername, repository.SortByEmail:
+	default:
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "sort_by",
+			Description: "must be one of id, created_at, username, email",
+		})
+	}
+
+	switch opts.Filter.Status {
+	case repository.UserStatusAny, repository.UserStatusVerified, repository.UserStatusUnverified,
+		repository.UserStatusLocked, repository.UserStatusDeleted:
+	default:
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "status",
+			Description: "must be one of verified, unverified, locked, deleted",
+		})
+	}
+
+	for _, bound := range []struct {
+		field string
+		value string
+		dest  **time.Time
+	}{
+		{"created_after", req.CreatedAfter, &opts.Filter.CreatedFrom},
+		{"created_before", req.CreatedBefore, &opts.Filter.CreatedTo},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       bound.field,
+				Description: "must be an RFC3339 timestamp",
+			})
+			continue
+		}
+		*bound.dest = &t
+	}
+
+	return opts, violations
+}
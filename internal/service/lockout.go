@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// LockoutPolicy параметры блокировки учетной записи после неудачных попыток входа
+type LockoutPolicy struct {
+	Threshold    int           // Количество неудачных попыток до первой блокировки
+	BaseDuration time.Duration // Длительность первой блокировки; каждая следующая вдвое дольше
+	MaxDuration  time.Duration // Максимальная длительность блокировки
+	ResetAfter   time.Duration // Период без неудач, после которого счетчик начинается заново
+}
+
+// LockDuration возвращает длительность блокировки после failedCount неудачных попыток подряд
+func (p LockoutPolicy) LockDuration(failedCount int) time.Duration {
+	if p.Threshold <= 0 || p.BaseDuration <= 0 || failedCount < p.Threshold {
+		return 0
+	}
+
+	d := p.BaseDuration
+	for i := p.Threshold; i < failedCount && d < p.MaxDuration; i++ {
+		// Удвоение сверх половины максимума сразу дает максимум и не переполняет time.Duration
+		if d > p.MaxDuration/2 {
+			d = p.MaxDuration
+			break
+		}
+		d *= 2
+	}
+	if d > p.MaxDuration {
+		d = p.MaxDuration
+	}
+	return d
+}
+
+// WithLockout включает блокировку учетных записей после неудачных попыток входа
+func WithLockout(store repository.LockoutRepository, policy LockoutPolicy) Option {
+	return func(s *UserService) {
+		s.lockouts = store
+		s.lockoutPolicy = policy
+	}
+}
+
+// GetLockout возвращает состояние неудачных попыток входа пользователя
+func (s *UserService) GetLockout(ctx context.Context, req *userProto.GetLockoutRequest) (*userProto.GetLockoutResponse, error) {
+	if err := s.checkContextCancelled(ctx, "GetLockout"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.lockouts == nil {
+		return nil, status.Error(codes.Unimplemented, "account lockout is not configured")
+	}
+
+	userID := uint(req.UserId)
+	if _, err := s.repo.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for lockout check with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to get lockout")
+	}
+
+	attempts, err := s.lockouts.GetLoginAttempts(ctx, userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get login attempts for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to get lockout")
+	}
+
+	resp := &userProto.GetLockoutResponse{
+		FailedAttempts: int32(attempts.FailedCount),
+		Locked:         attempts.Locked(time.Now()),
+	}
+	if attempts.LastFailedAt != nil {
+		resp.LastFailedAt = attempts.LastFailedAt.Format(time.RFC3339)
+	}
+	if resp.Locked {
+		resp.LockedUntil = attempts.LockedUntil.Format(time.RFC3339)
+	}
+	return resp, nil
+}
+
+// ClearLockout сбрасывает счетчик неудачных попыток и снимает блокировку
+func (s *UserService) ClearLockout(ctx context.Context, req *userProto.ClearLockoutRequest) (*userProto.ClearLockoutResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ClearLockout"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.lockouts == nil {
+		return nil, status.Error(codes.Unimplemented, "account lockout is not configured")
+	}
+
+	userID := uint(req.UserId)
+	if _, err := s.repo.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for lockout reset with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to clear lockout")
+	}
+
+	if err := s.lockouts.ClearLoginAttempts(ctx, userID); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to clear lockout for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to clear lockout")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("lockout cleared for user with ID: %d", userID))
+	return &userProto.ClearLockoutResponse{Success: true}, nil
+}
+
+// reserveLoginAttempt засчитывает попытку входа как неудачную до проверки пароля и возвращает статус
+// ResourceExhausted, если вход пользователя заблокирован. Успешный вход снимает попытку через clearLoginAttempts.
+func (s *UserService) reserveLoginAttempt(ctx context.Context, userID uint) error {
+	if s.lockouts == nil {
+		return nil
+	}
+
+	now := time.Now()
+	attempts, err := s.lockouts.RecordFailedLogin(ctx, userID, now, s.lockoutPolicy.ResetAfter, s.lockoutPolicy.LockDuration)
+	if err != nil {
+		if errors.Is(err, repository.ErrAccountLocked) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("login attempt for locked account with user ID: %d", userID))
+			return accountLockedError(attempts.LockedUntil.Sub(now))
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to record login attempt for user with ID: %d", userID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to check lockout")
+	}
+	return nil
+}
+
+// clearLoginAttempts сбрасывает счетчик неудачных попыток и блокировку после успешного входа
+func (s *UserService) clearLoginAttempts(ctx context.Context, userID uint) {
+	if s.lockouts == nil {
+		return
+	}
+
+	if err := s.lockouts.ClearLoginAttempts(ctx, userID); err != nil {
+		s.logger.WarnContext(ctx, fmt.Sprintf("failed to reset login attempts for user with ID: %d", userID), slog.Any("error", err))
+	}
+}
+
+// accountLockedError формирует статус блокировки с подсказкой, когда повторить попытку
+func accountLockedError(retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "account is temporarily locked")
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: "ACCOUNT_LOCKED", Domain: "user.watchlist-kata"},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter.Round(time.Second))},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLockDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, BaseDuration: time.Minute, MaxDuration: time.Hour}
+	huge := LockoutPolicy{Threshold: 1, BaseDuration: time.Hour, MaxDuration: math.MaxInt64}
+
+	tests := []struct {
+		name        string
+		policy      LockoutPolicy
+		failedCount int
+		want        time.Duration
+	}{
+		{name: "below threshold", policy: policy, failedCount: 4, want: 0},
+		{name: "at threshold", policy: policy, failedCount: 5, want: time.Minute},
+		{name: "doubles", policy: policy, failedCount: 6, want: 2 * time.Minute},
+		{name: "doubles again", policy: policy, failedCount: 8, want: 8 * time.Minute},
+		{name: "capped", policy: policy, failedCount: 12, want: time.Hour},
+		{name: "capped far past threshold", policy: policy, failedCount: math.MaxInt32, want: time.Hour},
+		{name: "no overflow near max duration", policy: huge, failedCount: 100, want: math.MaxInt64},
+		{name: "lockout disabled", policy: LockoutPolicy{BaseDuration: time.Minute, MaxDuration: time.Hour}, failedCount: 10, want: 0},
+		{name: "zero base duration", policy: LockoutPolicy{Threshold: 1, MaxDuration: time.Hour}, failedCount: 10, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.LockDuration(tt.failedCount); got != tt.want {
+				t.Errorf("LockDuration(%d) = %v, want %v", tt.failedCount, got, tt.want)
+			}
+		})
+	}
+}
+
+// stubLockouts хранилище попыток входа, которое ничего не хранит
+type stubLockouts struct{}
+
+func (stubLockouts) GetLoginAttempts(_ context.Context, userID uint) (*repository.LoginAttempts, error) {
+	return &repository.LoginAttempts{UserID: userID}, nil
+}
+
+func (stubLockouts) RecordFailedLogin(_ context.Context, userID uint, _ time.Time, _ time.Duration, _ repository.LockDurationFunc) (*repository.LoginAttempts, error) {
+	return &repository.LoginAttempts{UserID: userID, FailedCount: 1}, nil
+}
+
+func (stubLockouts) LockAccount(context.Context, uint, time.Time) error { return nil }
+
+func (stubLockouts) ClearLoginAttempts(context.Context, uint) error { return nil }
+
+func TestClearLockoutUnknownUser(t *testing.T) {
+	s := NewUserService(repository.NewMemoryRepository(repositorytest.DiscardLogger()), repositorytest.DiscardLogger(),
+		WithLockout(stubLockouts{}, LockoutPolicy{Threshold: 5, BaseDuration: time.Minute, MaxDuration: time.Hour}))
+
+	_, err := s.ClearLockout(context.Background(), &userProto.ClearLockoutRequest{UserId: 42})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("ClearLockout error = %v, want code %v", err, codes.NotFound)
+	}
+}
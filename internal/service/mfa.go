@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/encryption"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/totp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// otpCodeMetadataKey ключ метаданных, в котором CheckPass принимает код второго фактора
+const otpCodeMetadataKey = "x-otp-code"
+
+// Параметры кодов восстановления
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// totpSkew допустимое расхождение часов клиента в шагах TOTP
+const totpSkew = 1
+
+var (
+	// errSecondFactorRequired ответ на верный пароль без кода второго фактора при включенной 2FA
+	errSecondFactorRequired = secondFactorRequiredError()
+	// errInvalidSecondFactor ответ на неверный или повторно использованный код
+	errInvalidSecondFactor = status.Error(codes.Unauthenticated, "invalid second factor")
+)
+
+// recoveryCodeEncoding кодировка кодов восстановления без неоднозначных для чтения символов выравнивания
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// WithMFA включает двухфакторную аутентификацию; секреты TOTP шифруются cipher перед сохранением.
+// Без cipher 2FA остается выключенной.
+func WithMFA(store repository.MFARepository, cipher *encryption.Cipher, issuer string) Option {
+	return func(s *UserService) {
+		if cipher == nil {
+			return
+		}
+		s.mfa = store
+		s.mfaCipher = cipher
+		s.mfaIssuer = issuer
+	}
+}
+
+// EnrollTOTP создает секрет TOTP; 2FA начинает действовать после подтверждения первым кодом
+func (s *UserService) EnrollTOTP(ctx context.Context, req *userProto.EnrollTOTPRequest) (*userProto.EnrollTOTPResponse, error) {
+	if err := s.checkContextCancelled(ctx, "EnrollTOTP"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.mfa == nil {
+		return nil, status.Error(codes.Unimplemented, "two-factor authentication is not configured")
+	}
+
+	userID := uint(req.UserId)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for MFA enrollment with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to enroll TOTP")
+	}
+
+	existing, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil && !errors.Is(err, repository.ErrMFANotFound) {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get MFA settings for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to enroll TOTP")
+	}
+	if existing != nil && existing.Enabled() {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to generate TOTP secret", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to enroll TOTP")
+	}
+	encrypted, err := s.mfaCipher.Encrypt([]byte(secret), mfaAssociatedData(userID))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to encrypt TOTP secret", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to enroll TOTP")
+	}
+
+	if err := s.mfa.SaveMFAEnrollment(ctx, userID, encrypted); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to save MFA enrollment for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to enroll TOTP")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("TOTP enrollment started for user with ID: %d", userID))
+	return &userProto.EnrollTOTPResponse{
+		Secret:     secret,
+		OtpauthUri: totp.URI(s.mfaIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmTOTP включает 2FA после проверки первого кода и возвращает коды восстановления.
+// Коды показываются только один раз и хранятся лишь в виде хешей.
+func (s *UserService) ConfirmTOTP(ctx context.Context, req *userProto.ConfirmTOTPRequest) (*userProto.ConfirmTOTPResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ConfirmTOTP"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.mfa == nil {
+		return nil, status.Error(codes.Unimplemented, "two-factor authentication is not configured")
+	}
+
+	userID := uint(req.UserId)
+	mfa, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return nil, status.Error(codes.FailedPrecondition, "TOTP enrollment not started")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get MFA settings for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to confirm TOTP")
+	}
+	if mfa.Enabled() {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication already enabled")
+	}
+
+	step, valid, err := s.validateTOTP(mfa, req.Code)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to validate TOTP code for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to confirm TOTP")
+	}
+	if !valid {
+		return nil, status.Error(codes.InvalidArgument, "invalid TOTP code")
+	}
+
+	recoveryCodes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to generate recovery codes", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to confirm TOTP")
+	}
+
+	if err := s.mfa.ConfirmMFA(ctx, userID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return nil, status.Error(codes.FailedPrecondition, "TOTP enrollment not started")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to confirm MFA for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to confirm TOTP")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("TOTP enabled for user with ID: %d", userID))
+	return &userProto.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// DisableTOTP отключает 2FA после проверки кода TOTP или кода восстановления.
+// Неверные коды засчитываются как неудачные попытки входа.
+func (s *UserService) DisableTOTP(ctx context.Context, req *userProto.DisableTOTPRequest) (*userProto.DisableTOTPResponse, error) {
+	if err := s.checkContextCancelled(ctx, "DisableTOTP"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.mfa == nil {
+		return nil, status.Error(codes.Unimplemented, "two-factor authentication is not configured")
+	}
+
+	userID := uint(req.UserId)
+	mfa, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return nil, status.Error(codes.FailedPrecondition, "two-factor authentication is not enabled")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get MFA settings for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to disable TOTP")
+	}
+
+	// Незавершенную попытку подключения можно отменить без кода.
+	// Подбор кода ограничен той же блокировкой, что и вход: попытка засчитывается до проверки
+	// и снимается только при верном коде.
+	if mfa.Enabled() {
+		if err := s.reserveLoginAttempt(ctx, userID); err != nil {
+			return nil, err
+		}
+		valid, err := s.verifySecondFactor(ctx, mfa, req.Code)
+		if err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to verify second factor for user with ID: %d", userID), slog.Any("error", err))
+			return nil, status.Error(codes.Internal, "failed to disable TOTP")
+		}
+		if !valid {
+			s.logger.InfoContext(ctx, fmt.Sprintf("invalid second factor for disabling TOTP for user with ID: %d", userID))
+			return nil, errInvalidSecondFactor
+		}
+		s.clearLoginAttempts(ctx, userID)
+	}
+
+	if err := s.mfa.DeleteMFA(ctx, userID); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to disable MFA for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to disable TOTP")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("TOTP disabled for user with ID: %d", userID))
+	return &userProto.DisableTOTPResponse{Success: true}, nil
+}
+
+// checkSecondFactor требует код второго фактора, если у пользователя включена 2FA
+func (s *UserService) checkSecondFactor(ctx context.Context, userID uint, code string) error {
+	if s.mfa == nil {
+		return nil
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrMFANotFound) {
+			return nil
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get MFA settings for user with ID: %d", userID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to check second factor")
+	}
+	if !mfa.Enabled() {
+		return nil
+	}
+
+	if code == "" {
+		return errSecondFactorRequired
+	}
+
+	valid, err := s.verifySecondFactor(ctx, mfa, code)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to verify second factor for user with ID: %d", userID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to check second factor")
+	}
+	if !valid {
+		s.logger.InfoContext(ctx, fmt.Sprintf("invalid second factor for user with ID: %d", userID))
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// verifySecondFactor проверяет код TOTP или, если код не похож на TOTP, код восстановления.
+// Оба варианта одноразовые: использованный шаг TOTP и код восстановления повторно не принимаются.
+func (s *UserService) verifySecondFactor(ctx context.Context, mfa *repository.MFA, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		if _, err := strconv.Atoi(code); err == nil {
+			step, valid, err := s.validateTOTP(mfa, code)
+			if err != nil || !valid {
+				return false, err
+			}
+			return s.mfa.UseTOTPStep(ctx, mfa.UserID, step)
+		}
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+	return s.mfa.UseRecoveryCode(ctx, mfa.UserID, hashToken(normalized))
+}
+
+// validateTOTP расшифровывает секрет и проверяет код
+func (s *UserService) validateTOTP(mfa *repository.MFA, code string) (int64, bool, error) {
+	secret, err := s.mfaCipher.Decrypt(mfa.SecretEncrypted, mfaAssociatedData(mfa.UserID))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return totp.Validate(string(secret), code, time.Now(), totpSkew)
+}
+
+// mfaAssociatedData привязывает зашифрованный секрет к пользователю, чтобы его нельзя было перенести в чужую запись
+func mfaAssociatedData(userID uint) []byte {
+	return []byte("user_mfa:" + strconv.FormatUint(uint64(userID), 10))
+}
+
+// generateRecoveryCodes возвращает коды восстановления в виде xxxxx-xxxxx и их хеши
+func generateRecoveryCodes() ([]string, []string, error) {
+	codesList := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := randomBytes(recoveryCodeLength * 5 / 8)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		codesList = append(codesList, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codesList, hashes, nil
+}
+
+// normalizeRecoveryCode приводит введенный код восстановления к хранимому виду
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	if len(code) != recoveryCodeLength {
+		return ""
+	}
+	return code
+}
+
+// otpCodeFromMetadata извлекает код второго фактора из метаданных запроса
+func otpCodeFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	return firstMetadataValue(md, otpCodeMetadataKey)
+}
+
+// secondFactorRequiredError формирует статус, по которому клиент понимает, что нужно запросить код
+func secondFactorRequiredError() error {
+	st := status.New(codes.Unauthenticated, "second factor required")
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: "MFA_REQUIRED", Domain: "user.watchlist-kata"})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
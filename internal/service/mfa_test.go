@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/encryption"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"github.com/watchlist-kata/user/internal/totp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// enrollTOTP включает 2FA пользователю и возвращает секрет, подтвержденный шаг и коды восстановления
+func enrollTOTP(t *testing.T, s *UserService, userID int64) (string, int64, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := s.EnrollTOTP(ctx, &userProto.EnrollTOTPRequest{UserId: userID})
+	if err != nil {
+		t.Fatalf("EnrollTOTP: %v", err)
+	}
+	step := totp.Step(time.Now())
+	code, err := totp.Code(enrollment.Secret, step)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	confirmation, err := s.ConfirmTOTP(ctx, &userProto.ConfirmTOTPRequest{UserId: userID, Code: code})
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return enrollment.Secret, step, confirmation.RecoveryCodes
+}
+
+func newMFATestService(t *testing.T) (*UserService, int64) {
+	t.Helper()
+	repo := repositorytest.NewSQLiteRepository(t)
+	cipher, err := encryption.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithMFA(repo, cipher, "Watchlist"))
+	return s, createTestUser(t, repo, "alice").Id
+}
+
+func TestSecondFactorRejectsRepeatedStep(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newMFATestService(t)
+	secret, step, _ := enrollTOTP(t, s, userID)
+
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return c
+	}
+
+	// Код, которым подтверждено подключение, уже использован
+	if err := s.checkSecondFactor(ctx, uint(userID), code(step)); err != errInvalidSecondFactor {
+		t.Fatalf("second factor with the confirmation code error = %v, want %v", err, errInvalidSecondFactor)
+	}
+
+	// Следующий шаг в пределах расхождения часов принимается один раз
+	if err := s.checkSecondFactor(ctx, uint(userID), code(step+1)); err != nil {
+		t.Fatalf("second factor with a fresh code: %v", err)
+	}
+	if err := s.checkSecondFactor(ctx, uint(userID), code(step+1)); err != errInvalidSecondFactor {
+		t.Fatalf("repeated second factor error = %v, want %v", err, errInvalidSecondFactor)
+	}
+
+	// Шаги раньше последнего использованного тоже отклоняются
+	if err := s.checkSecondFactor(ctx, uint(userID), code(step-1)); err != errInvalidSecondFactor {
+		t.Fatalf("second factor with an older step error = %v, want %v", err, errInvalidSecondFactor)
+	}
+}
+
+func TestSecondFactorRequired(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newMFATestService(t)
+
+	if err := s.checkSecondFactor(ctx, uint(userID), ""); err != nil {
+		t.Fatalf("second factor before enrollment: %v", err)
+	}
+	enrollTOTP(t, s, userID)
+	if err := s.checkSecondFactor(ctx, uint(userID), ""); err != errSecondFactorRequired {
+		t.Fatalf("second factor without a code error = %v, want %v", err, errSecondFactorRequired)
+	}
+}
+
+func TestRecoveryCodeWorksOnce(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newMFATestService(t)
+	_, _, recoveryCodes := enrollTOTP(t, s, userID)
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for _, code := range recoveryCodes {
+		if seen[code] {
+			t.Fatalf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+
+	if err := s.checkSecondFactor(ctx, uint(userID), recoveryCodes[0]); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := s.checkSecondFactor(ctx, uint(userID), recoveryCodes[0]); err != errInvalidSecondFactor {
+		t.Fatalf("second use of a recovery code error = %v, want %v", err, errInvalidSecondFactor)
+	}
+
+	// Регистр и разделители при вводе не важны
+	relaxed := strings.ToUpper(strings.ReplaceAll(recoveryCodes[1], "-", " "))
+	if err := s.checkSecondFactor(ctx, uint(userID), relaxed); err != nil {
+		t.Fatalf("recovery code typed in upper case with a space: %v", err)
+	}
+
+	if err := s.checkSecondFactor(ctx, uint(userID), "aaaaa-aaaaa"); err != errInvalidSecondFactor {
+		t.Fatalf("unknown recovery code error = %v, want %v", err, errInvalidSecondFactor)
+	}
+}
+
+func TestDisableTOTPRequiresValidCode(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newMFATestService(t)
+	_, _, recoveryCodes := enrollTOTP(t, s, userID)
+
+	_, err := s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: "000000"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("DisableTOTP with a wrong code error = %v, want code %v", err, codes.Unauthenticated)
+	}
+	if _, err := s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: recoveryCodes[0]}); err != nil {
+		t.Fatalf("DisableTOTP with a recovery code: %v", err)
+	}
+	if err := s.checkSecondFactor(ctx, uint(userID), ""); err != nil {
+		t.Fatalf("second factor after disabling: %v", err)
+	}
+}
+
+func TestDisableTOTPCountsFailedAttempts(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	cipher, err := encryption.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(),
+		WithMFA(repo, cipher, "Watchlist"),
+		WithLockout(repo, LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: time.Hour, ResetAfter: time.Hour}),
+	)
+	userID := createTestUser(t, repo, "alice").Id
+	_, _, recoveryCodes := enrollTOTP(t, s, userID)
+
+	// Верный код снимает засчитанные неудачные попытки
+	if _, err := s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: "000000"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("DisableTOTP with a wrong code error = %v, want code %v", err, codes.Unauthenticated)
+	}
+	if _, err := s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: recoveryCodes[0]}); err != nil {
+		t.Fatalf("DisableTOTP with a recovery code: %v", err)
+	}
+	attempts, err := repo.GetLoginAttempts(ctx, uint(userID))
+	if err != nil {
+		t.Fatalf("GetLoginAttempts: %v", err)
+	}
+	if attempts.FailedCount != 0 {
+		t.Errorf("failed attempts after disabling = %d, want 0", attempts.FailedCount)
+	}
+
+	// После порога неудачных попыток не принимается и верный код
+	_, _, recoveryCodes = enrollTOTP(t, s, userID)
+	for i := 0; i < 3; i++ {
+		if _, err := s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: "000000"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("DisableTOTP with a wrong code error = %v, want code %v", err, codes.Unauthenticated)
+		}
+	}
+	_, err = s.DisableTOTP(ctx, &userProto.DisableTOTPRequest{UserId: userID, Code: recoveryCodes[0]})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("DisableTOTP of a locked account error = %v, want code %v", err, codes.ResourceExhausted)
+	}
+	if err := s.checkSecondFactor(ctx, uint(userID), ""); err != errSecondFactorRequired {
+		t.Errorf("second factor after rejected disabling error = %v, want %v", err, errSecondFactorRequired)
+	}
+}
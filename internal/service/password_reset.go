@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/notify"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidResetToken единый ответ на неизвестный, истекший или использованный токен сброса пароля
+var errInvalidResetToken = status.Error(codes.InvalidArgument, "invalid or expired password reset token")
+
+// WithPasswordReset включает самостоятельный сброс пароля по токену с заданным временем жизни
+func WithPasswordReset(store repository.VerificationRepository, ttl time.Duration) Option {
+	return func(s *UserService) {
+		s.verifications = store
+		s.passwordResetTTL = ttl
+	}
+}
+
+// RequestPasswordReset отправляет токен сброса пароля на почту.
+// Ответ не зависит от существования адреса, а отправка идет в фоне, чтобы время ответа тоже его не выдавало.
+func (s *UserService) RequestPasswordReset(ctx context.Context, req *userProto.RequestPasswordResetRequest) (*userProto.RequestPasswordResetResponse, error) {
+	if err := s.checkContextCancelled(ctx, "RequestPasswordReset"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.verifications == nil || s.notifier == nil || s.passwordResetTTL <= 0 {
+		return nil, status.Error(codes.Unimplemented, "password reset is not configured")
+	}
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.ErrorContext(ctx, "failed to get user for password reset", slog.Any("error", err))
+		}
+		return &userProto.RequestPasswordResetResponse{Success: true}, nil
+	}
+
+	go func(ctx context.Context) {
+		if err := s.sendPasswordReset(ctx, user.User); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to send password reset for user with ID: %d", user.Id), slog.Any("error", err))
+			return
+		}
+		s.logger.InfoContext(ctx, fmt.Sprintf("password reset sent for user with ID: %d", user.Id))
+	}(context.WithoutCancel(ctx))
+
+	return &userProto.RequestPasswordResetResponse{Success: true}, nil
+}
+
+// ResetPassword устанавливает новый пароль по токену сброса и отзывает все refresh-токены пользователя
+func (s *UserService) ResetPassword(ctx context.Context, req *userProto.ResetPasswordRequest) (*userProto.ResetPasswordResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ResetPassword"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.verifications == nil || s.passwordResetTTL <= 0 {
+		return nil, status.Error(codes.Unimplemented, "password reset is not configured")
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		return nil, status.Error(codes.InvalidArgument, "token and new password are required")
+	}
+
+	// Токен сначала только проверяется, чтобы пароль, не прошедший политику, не сжигал его
+	tokenHash := hashToken(req.Token)
+	reset, err := s.verifications.GetVerificationToken(ctx, repository.PurposePasswordReset, tokenHash, time.Now())
+	if err != nil {
+		if errors.Is(err, repository.ErrVerificationTokenInvalid) {
+			s.logger.DebugContext(ctx, "password reset failed: invalid token")
+			return nil, errInvalidResetToken
+		}
+		s.logger.ErrorContext(ctx, "failed to get password reset token", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	user, err := s.repo.GetUserByID(repository.WithPrimary(ctx), reset.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, errInvalidResetToken
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for password reset with ID: %d", reset.UserID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+	if user.Email != reset.Email {
+		s.logger.WarnContext(ctx, fmt.Sprintf("password reset failed: email changed for user with ID: %d", user.Id))
+		return nil, errInvalidResetToken
+	}
+
+	if err := s.validatePassword(ctx, req.NewPassword, user.Username, user.Email); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := s.passwords.Hash(req.NewPassword)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to hash password for reset", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	// Токен сжигается в одной транзакции со сменой пароля, аннулированием остальных токенов сброса
+	// и подтверждением почты: если пароль не сменится, токен останется действующим
+	now := time.Now()
+	err = s.repo.WithinTx(ctx, func(repo repository.Repository) error {
+		store := s.txVerifications(repo)
+		if _, err := store.ConsumeVerificationToken(ctx, repository.PurposePasswordReset, tokenHash, now); err != nil {
+			return err
+		}
+
+		userToUpdate := &userProto.User{
+			Id:       user.Id,
+			Username: user.Username,
+			Email:    user.Email,
+			Pwdhash:  hashedPassword,
+			Salt:     "",
+		}
+		if _, err := repo.UpdateUser(ctx, userToUpdate, user.Version); err != nil {
+			return err
+		}
+
+		if err := store.InvalidateVerificationTokens(ctx, uint(user.Id), repository.PurposePasswordReset); err != nil {
+			return err
+		}
+		// Владение почтой только что доказано токеном, отправленным на нее
+		return store.MarkEmailVerified(ctx, uint(user.Id), user.Email, now)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrVerificationTokenInvalid) {
+			return nil, errInvalidResetToken
+		}
+		if errors.Is(err, repository.ErrVersionConflict) {
+			return nil, errConcurrentUpdate
+		}
+		if errors.Is(err, repository.ErrTxConflict) {
+			return nil, errTxConflict
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, errInvalidResetToken
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to reset password for user with ID: %d", user.Id), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	s.afterPasswordReset(ctx, uint(user.Id))
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("password reset successfully for user with ID: %d", user.Id))
+	return &userProto.ResetPasswordResponse{Success: true}, nil
+}
+
+// sendPasswordReset аннулирует прежние токены сброса, создает новый и отправляет его пользователю
+func (s *UserService) sendPasswordReset(ctx context.Context, user *userProto.User) error {
+	userID := uint(user.Id)
+	if err := s.verifications.InvalidateVerificationTokens(ctx, userID, repository.PurposePasswordReset); err != nil {
+		return err
+	}
+
+	rawToken, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.passwordResetTTL)
+	_, err = s.verifications.CreateVerificationToken(ctx, &repository.VerificationToken{
+		UserID:    userID,
+		Purpose:   repository.PurposePasswordReset,
+		TokenHash: tokenHash,
+		Email:     user.Email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.notifier.Send(ctx, notify.Message{
+		Type:      notify.TypePasswordReset,
+		UserID:    user.Id,
+		Email:     user.Email,
+		Token:     rawToken,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// afterPasswordReset завершает сброс: отзывает сессии и снимает блокировку.
+// Ошибки логируются, пароль к этому моменту уже сменен.
+func (s *UserService) afterPasswordReset(ctx context.Context, userID uint) {
+	s.revokeAllSessions(ctx, userID)
+
+	if s.lockouts != nil {
+		if err := s.lockouts.ClearLoginAttempts(ctx, userID); err != nil {
+			s.logger.WarnContext(ctx, fmt.Sprintf("failed to clear lockout after password reset for user with ID: %d", userID), slog.Any("error", err))
+		}
+	}
+}
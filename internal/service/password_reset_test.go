@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// conflictingRepository отклоняет обновления пользователей внутри WithinTx как устаревшие
+type conflictingRepository struct {
+	repository.Repository
+}
+
+func (r conflictingRepository) WithinTx(ctx context.Context, fn func(repo repository.Repository) error, opts ...repository.TxOption) error {
+	return r.Repository.WithinTx(ctx, func(repo repository.Repository) error {
+		return fn(conflictingTxRepository{Repository: repo})
+	}, opts...)
+}
+
+type conflictingTxRepository struct {
+	repository.Repository
+}
+
+func (r conflictingTxRepository) Unwrap() repository.Repository {
+	return r.Repository
+}
+
+func (r conflictingTxRepository) UpdateUser(context.Context, *userProto.User, int64) (*repository.User, error) {
+	return nil, repository.ErrVersionConflict
+}
+
+func TestResetPasswordKeepsTokenWhenUpdateFails(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	user := createTestUser(t, repo, "alice")
+
+	rawToken, tokenHash, err := generateToken()
+	if err != nil {
+		t.Fatalf("generateToken: %v", err)
+	}
+	_, err = repo.CreateVerificationToken(ctx, &repository.VerificationToken{
+		UserID:    uint(user.Id),
+		Purpose:   repository.PurposePasswordReset,
+		TokenHash: tokenHash,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateVerificationToken: %v", err)
+	}
+	req := &userProto.ResetPasswordRequest{Token: rawToken, NewPassword: "correct horse battery"}
+
+	failing := NewUserService(conflictingRepository{Repository: repo}, repositorytest.DiscardLogger(), WithPasswordReset(repo, time.Hour))
+	if _, err := failing.ResetPassword(ctx, req); status.Code(err) != codes.Aborted {
+		t.Fatalf("ResetPassword with a conflicting update error = %v, want code %v", err, codes.Aborted)
+	}
+	if _, err := repo.GetVerificationToken(ctx, repository.PurposePasswordReset, tokenHash, time.Now()); err != nil {
+		t.Fatalf("token must stay valid after a failed reset: %v", err)
+	}
+
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithPasswordReset(repo, time.Hour))
+	if _, err := s.ResetPassword(ctx, req); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	updated, err := repo.GetUserByID(ctx, uint(user.Id))
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if valid, _, err := s.passwords.Verify(req.NewPassword, updated.Pwdhash, updated.Salt); err != nil || !valid {
+		t.Errorf("new password does not verify: %v, %v", valid, err)
+	}
+	if _, err := repo.GetVerificationToken(ctx, repository.PurposePasswordReset, tokenHash, time.Now()); !errors.Is(err, repository.ErrVerificationTokenInvalid) {
+		t.Errorf("GetVerificationToken after reset error = %v, want %v", err, repository.ErrVerificationTokenInvalid)
+	}
+	if verifiedAt, err := repo.GetEmailVerifiedAt(ctx, uint(user.Id)); err != nil || verifiedAt == nil {
+		t.Errorf("GetEmailVerifiedAt after reset = %v, %v; want the email verified", verifiedAt, err)
+	}
+	if _, err := s.ResetPassword(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("second ResetPassword error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
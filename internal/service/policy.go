@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/watchlist-kata/user/internal/policy"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// WithPasswordPolicy задает правила, которым должны соответствовать новые пароли
+func WithPasswordPolicy(p *policy.Policy) Option {
+	return func(s *UserService) {
+		s.policy = p
+	}
+}
+
+// validatePassword проверяет пароль по политике и возвращает InvalidArgument с нарушениями по полям
+func (s *UserService) validatePassword(ctx context.Context, password, username, email string) error {
+	violations := s.policy.Validate(password, username, email)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	codesList := make([]string, 0, len(violations))
+	fieldViolations := make([]*errdetails.BadRequest_FieldViolation, 0, len(violations))
+	for _, v := range violations {
+		codesList = append(codesList, v.Code)
+		fieldViolations = append(fieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+	s.logger.DebugContext(ctx, "password rejected by policy: "+strings.Join(codesList, ", "))
+
+	return invalidArgumentError("password does not satisfy policy", fieldViolations)
+}
+
+// invalidArgumentError возвращает InvalidArgument с нарушениями по полям в деталях
+func invalidArgumentError(message string, violations []*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(codes.InvalidArgument, message)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidRefreshToken единый ответ на неизвестный, истекший, отозванный или повторно использованный токен
+var errInvalidRefreshToken = status.Error(codes.Unauthenticated, "invalid refresh token")
+
+// Refresh обменивает refresh-токен на новую пару токенов.
+// Повторное предъявление уже замененного токена считается утечкой и отзывает всю цепочку.
+func (s *UserService) Refresh(ctx context.Context, req *userProto.RefreshRequest) (*userProto.RefreshResponse, error) {
+	if err := s.checkContextCancelled(ctx, "Refresh"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.tokens == nil || s.refreshTokens == nil {
+		return nil, status.Error(codes.Unimplemented, "refresh tokens are not configured")
+	}
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	stored, err := s.refreshTokens.GetRefreshToken(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			s.logger.DebugContext(ctx, "refresh failed: unknown refresh token")
+			return nil, errInvalidRefreshToken
+		}
+		s.logger.ErrorContext(ctx, "failed to get refresh token", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	if stored.RevokedAt != nil {
+		s.logger.DebugContext(ctx, fmt.Sprintf("refresh failed: revoked token for user with ID: %d", stored.UserID))
+		return nil, errInvalidRefreshToken
+	}
+	if stored.RotatedAt != nil {
+		return nil, s.handleRefreshTokenReuse(ctx, stored)
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		s.logger.DebugContext(ctx, fmt.Sprintf("refresh failed: expired token for user with ID: %d", stored.UserID))
+		return nil, errInvalidRefreshToken
+	}
+
+	user, err := s.repo.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("refresh failed: user not found with ID: %d", stored.UserID))
+			return nil, errInvalidRefreshToken
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for refresh with ID: %d", stored.UserID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	// Токен завершенного или истекшего сеанса не обновляется
+	var sessionID string
+	if s.sessions != nil {
+		sessionID = stored.FamilyID
+		if err := s.touchSession(ctx, sessionID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Роли читаются до замены токена, чтобы ошибка не оставила клиента без действующего refresh-токена
+	subject, err := s.tokenSubject(ctx, user.User, sessionID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	refreshToken, next, err := s.newRefreshToken(stored.UserID, stored.FamilyID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to generate refresh token", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+	if _, err := s.refreshTokens.RotateRefreshToken(ctx, stored.ID, next); err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			// Токен успели заменить параллельным запросом
+			return nil, s.handleRefreshTokenReuse(ctx, stored)
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to rotate refresh token for user with ID: %d", stored.UserID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	accessToken, expiresAt, err := s.tokens.Issue(subject)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to issue access token for user with ID: %d", user.Id), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("tokens refreshed successfully for user with ID: %d", user.Id))
+	return &userProto.RefreshResponse{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresAt:        expiresAt.Format(time.RFC3339),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: next.ExpiresAt.Format(time.RFC3339),
+	}, nil
+}
+
+// Revoke отзывает refresh-токен и всю его цепочку ротаций.
+// Неизвестный токен не считается ошибкой, чтобы ответ не раскрывал его существование.
+func (s *UserService) Revoke(ctx context.Context, req *userProto.RevokeRequest) (*userProto.RevokeResponse, error) {
+	if err := s.checkContextCancelled(ctx, "Revoke"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.refreshTokens == nil {
+		return nil, status.Error(codes.Unimplemented, "refresh tokens are not configured")
+	}
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	stored, err := s.refreshTokens.GetRefreshToken(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			return &userProto.RevokeResponse{Success: true}, nil
+		}
+		s.logger.ErrorContext(ctx, "failed to get refresh token for revocation", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+
+	if err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke refresh tokens for user with ID: %d", stored.UserID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+	s.endSession(ctx, stored.UserID, stored.FamilyID)
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("refresh token revoked for user with ID: %d", stored.UserID))
+	return &userProto.RevokeResponse{Success: true}, nil
+}
+
+// handleRefreshTokenReuse отзывает цепочку, в которой повторно предъявлен уже замененный токен
+func (s *UserService) handleRefreshTokenReuse(ctx context.Context, stored *repository.RefreshToken) error {
+	s.logger.WarnContext(ctx, fmt.Sprintf("refresh token reuse detected for user with ID: %d, revoking token family", stored.UserID))
+	if err := s.refreshTokens.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke refresh token family for user with ID: %d", stored.UserID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to refresh token")
+	}
+	s.endSession(ctx, stored.UserID, stored.FamilyID)
+	return errInvalidRefreshToken
+}
+
+// newRefreshToken генерирует refresh-токен и запись для хранения; пустой familyID начинает новую цепочку
+func (s *UserService) newRefreshToken(userID uint, familyID string) (string, *repository.RefreshToken, error) {
+	refreshToken, tokenHash, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	if familyID == "" {
+		familyID, err = randomID()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return refreshToken, &repository.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	}, nil
+}
+
+// randomID возвращает случайный идентификатор цепочки токенов или сеанса
+func randomID() (string, error) {
+	raw, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// generateToken возвращает случайный токен для передачи клиенту и его хеш для хранения
+func generateToken() (string, string, error) {
+	raw, err := randomBytes(32)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+// hashToken возвращает SHA-256 от токена; токены высокоэнтропийны, поэтому соль не нужна
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
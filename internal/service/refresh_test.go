@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"github.com/watchlist-kata/user/internal/token"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newRefreshTestService(t *testing.T) (*UserService, *repository.SQLRepository, *repository.User) {
+	t.Helper()
+	repo := repositorytest.NewSQLiteRepository(t)
+	key, err := token.GenerateEd25519SigningKey("test")
+	if err != nil {
+		t.Fatalf("GenerateEd25519SigningKey: %v", err)
+	}
+	issuer, err := token.NewIssuer(token.Config{Issuer: "watchlist-user", TTL: time.Minute}, []*token.SigningKey{key}, "")
+	if err != nil {
+		t.Fatalf("NewIssuer: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(),
+		WithTokenIssuer(issuer),
+		WithRefreshTokens(repo, time.Hour),
+	)
+	return s, repo, createTestUser(t, repo, "alice")
+}
+
+// issueRefreshToken сохраняет refresh-токен новой цепочки и возвращает его вместе с записью
+func issueRefreshToken(t *testing.T, s *UserService, repo *repository.SQLRepository, userID int64) (string, *repository.RefreshToken) {
+	t.Helper()
+	rawToken, record, err := s.newRefreshToken(uint(userID), "")
+	if err != nil {
+		t.Fatalf("newRefreshToken: %v", err)
+	}
+	stored, err := repo.CreateRefreshToken(context.Background(), record)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	return rawToken, stored
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	ctx := context.Background()
+	s, repo, user := newRefreshTestService(t)
+	first, _ := issueRefreshToken(t, s, repo, user.Id)
+
+	resp, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: first})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.RefreshToken == first {
+		t.Fatalf("Refresh returned access token %q and refresh token %q, want a new pair", resp.AccessToken, resp.RefreshToken)
+	}
+
+	// Замененный токен помечен, новый продолжает ту же цепочку
+	rotated, err := repo.GetRefreshToken(ctx, hashToken(first))
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	next, err := repo.GetRefreshToken(ctx, hashToken(resp.RefreshToken))
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if rotated.RotatedAt == nil || !next.Active() || next.FamilyID != rotated.FamilyID {
+		t.Errorf("after rotation: previous %+v, next %+v; want the previous rotated and the next active in the same family", rotated, next)
+	}
+
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: resp.RefreshToken}); err != nil {
+		t.Errorf("Refresh with the rotated-in token: %v", err)
+	}
+}
+
+func TestRefreshReplayRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	s, repo, user := newRefreshTestService(t)
+	first, _ := issueRefreshToken(t, s, repo, user.Id)
+	other, _ := issueRefreshToken(t, s, repo, user.Id)
+
+	resp, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: first})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	// Повторное предъявление замененного токена отзывает и токен, выданный при замене
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: first}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Refresh with a replayed token error = %v, want code %v", err, codes.Unauthenticated)
+	}
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: resp.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh after replay error = %v, want code %v", err, codes.Unauthenticated)
+	}
+
+	// Другие цепочки пользователя не затрагиваются
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: other}); err != nil {
+		t.Errorf("Refresh of another family: %v", err)
+	}
+}
+
+func TestRefreshConcurrentRotation(t *testing.T) {
+	ctx := context.Background()
+	s, repo, user := newRefreshTestService(t)
+	_, stored := issueRefreshToken(t, s, repo, user.Id)
+	const rotations = 8
+
+	// Из одновременных замен одного токена проходит только одна
+	errs := make([]error, rotations)
+	var wg sync.WaitGroup
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, next, err := s.newRefreshToken(stored.UserID, stored.FamilyID)
+			if err == nil {
+				_, err = repo.RotateRefreshToken(ctx, stored.ID, next)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	var rotated int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			rotated++
+		case !errors.Is(err, repository.ErrRefreshTokenReused):
+			t.Errorf("RotateRefreshToken error = %v, want %v", err, repository.ErrRefreshTokenReused)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("%d concurrent rotations succeeded, want 1", rotated)
+	}
+}
+
+func TestRefreshConcurrentRequests(t *testing.T) {
+	ctx := context.Background()
+	s, repo, user := newRefreshTestService(t)
+	rawToken, _ := issueRefreshToken(t, s, repo, user.Id)
+	const requests = 8
+
+	// Проигравшие запросы считаются повторным предъявлением и отзывают цепочку
+	responses := make([]*userProto.RefreshResponse, requests)
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: rawToken})
+		}(i)
+	}
+	wg.Wait()
+
+	var winner *userProto.RefreshResponse
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner != nil {
+				t.Fatal("more than one concurrent refresh succeeded")
+			}
+			winner = responses[i]
+		case status.Code(err) != codes.Unauthenticated:
+			t.Errorf("concurrent Refresh error = %v, want code %v", err, codes.Unauthenticated)
+		}
+	}
+	if winner == nil {
+		t.Fatal("no concurrent refresh succeeded")
+	}
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: winner.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh with the winning token error = %v, want code %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestPurgeExpiredRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s, repo, user := newRefreshTestService(t)
+	active, _ := issueRefreshToken(t, s, repo, user.Id)
+
+	rawExpired, expired, err := s.newRefreshToken(uint(user.Id), "")
+	if err != nil {
+		t.Fatalf("newRefreshToken: %v", err)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := repo.CreateRefreshToken(ctx, expired); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	purged, err := repo.PurgeExpiredRefreshTokens(ctx, time.Now())
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeExpiredRefreshTokens = %d, %v; want 1", purged, err)
+	}
+	if _, err := repo.GetRefreshToken(ctx, hashToken(rawExpired)); !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		t.Errorf("GetRefreshToken of the purged token error = %v, want %v", err, repository.ErrRefreshTokenNotFound)
+	}
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: rawExpired}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh with the purged token error = %v, want code %v", err, codes.Unauthenticated)
+	}
+	if _, err := s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: active}); err != nil {
+		t.Errorf("Refresh with the active token: %v", err)
+	}
+}
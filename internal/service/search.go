@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSearchQueryLength максимальная длина поискового запроса, равная максимальной длине имени
+const maxSearchQueryLength = 50
+
+// SearchUsers ищет пользователей по части имени с допуском опечаток и возвращает их без хешей паролей.
+// Размер страницы больше допустимого уменьшается до максимума.
+func (s *UserService) SearchUsers(ctx context.Context, req *userProto.SearchUsersRequest) (*userProto.SearchUsersResponse, error) {
+	if err := s.checkContextCancelled(ctx, "SearchUsers"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	switch length := utf8.RuneCountInString(strings.TrimSpace(req.Query)); {
+	case length < repository.MinSearchQueryLength:
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "query",
+			Description: fmt.Sprintf("must contain at least %d characters", repository.MinSearchQueryLength),
+		})
+	case length > maxSearchQueryLength:
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "query",
+			Description: fmt.Sprintf("must contain at most %d characters", maxSearchQueryLength),
+		})
+	}
+	if req.PageSize < 0 {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{
+			Field:       "page_size",
+			Description: "must not be negative",
+		})
+	}
+	if len(violations) > 0 {
+		return nil, invalidArgumentError("invalid search request", violations)
+	}
+
+	page, err := s.repo.SearchUsers(ctx, repository.SearchUsersOptions{
+		Query:    req.Query,
+		PageSize: int(req.PageSize),
+		Cursor:   req.PageToken,
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		if errors.Is(err, repository.ErrSearchQueryTooShort) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s.logger.ErrorContext(ctx, "failed to search users", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to search users")
+	}
+
+	resp := &userProto.SearchUsersResponse{
+		Users:         usersWithoutCredentials(page.Users),
+		NextPageToken: page.NextCursor,
+	}
+
+	s.logger.DebugContext(ctx, fmt.Sprintf("found %d users", len(resp.Users)))
+	return resp, nil
+}
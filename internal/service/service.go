@@ -2,15 +2,19 @@ package service
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/netip"
+	"time"
 
 	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/encryption"
+	"github.com/watchlist-kata/user/internal/notify"
+	"github.com/watchlist-kata/user/internal/policy"
 	"github.com/watchlist-kata/user/internal/repository"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/watchlist-kata/user/internal/token"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -18,16 +22,84 @@ import (
 // UserService представляет собой структуру сервиса пользователей
 type UserService struct {
 	userProto.UnimplementedUserServiceServer
-	repo   repository.Repository
-	logger *slog.Logger
+	repo      repository.Repository
+	logger    *slog.Logger
+	passwords *PasswordHashers
+	tokens    *token.Issuer
+	roles     repository.RoleRepository
+
+	refreshTokens   repository.RefreshTokenRepository
+	refreshTokenTTL time.Duration
+
+	lockouts      repository.LockoutRepository
+	lockoutPolicy LockoutPolicy
+
+	policy *policy.Policy
+
+	notifier             notify.Notifier
+	verifications        repository.VerificationRepository
+	verificationTTL      time.Duration
+	requireVerifiedEmail bool
+	passwordResetTTL     time.Duration
+
+	mfa       repository.MFARepository
+	mfaCipher *encryption.Cipher
+	mfaIssuer string
+
+	sessions       repository.SessionRepository
+	sessionTTL     time.Duration
+	trustedProxies []netip.Prefix
+
+	audits repository.AuditRepository
+}
+
+// UserService реализует все RPC сервиса пользователей из proto-описания
+var _ userProto.UserServiceServer = (*UserService)(nil)
+
+// Option задает необязательные параметры UserService
+type Option func(*UserService)
+
+// WithPasswordHashers задает алгоритмы хеширования паролей
+func WithPasswordHashers(passwords *PasswordHashers) Option {
+	return func(s *UserService) {
+		s.passwords = passwords
+	}
+}
+
+// WithTokenIssuer включает выпуск access-токенов при входе
+func WithTokenIssuer(issuer *token.Issuer) Option {
+	return func(s *UserService) {
+		s.tokens = issuer
+	}
+}
+
+// WithRoles включает в access-токены роли пользователя из хранилища
+func WithRoles(store repository.RoleRepository) Option {
+	return func(s *UserService) {
+		s.roles = store
+	}
+}
+
+// WithRefreshTokens включает выдачу refresh-токенов с заданным временем жизни
+func WithRefreshTokens(store repository.RefreshTokenRepository, ttl time.Duration) Option {
+	return func(s *UserService) {
+		s.refreshTokens = store
+		s.refreshTokenTTL = ttl
+	}
 }
 
 // NewUserService создает новый экземпляр UserService
-func NewUserService(repo repository.Repository, logger *slog.Logger) *UserService {
-	return &UserService{
-		repo:   repo,
-		logger: logger,
+func NewUserService(repo repository.Repository, logger *slog.Logger, opts ...Option) *UserService {
+	s := &UserService{
+		repo:      repo,
+		logger:    logger,
+		passwords: DefaultPasswordHashers(),
+		policy:    policy.Default(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // checkContextCancelled проверяет отмену контекста и логирует ошибку
@@ -41,61 +113,19 @@ func (s *UserService) checkContextCancelled(ctx context.Context, method string)
 	}
 }
 
-// GenerateSalt генерирует случайную соль
-func GenerateSalt() (string, error) {
-	salt := make([]byte, 16)
-	_, err := rand.Read(salt)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate salt: %w", err)
-	}
-	return base64.StdEncoding.EncodeToString(salt), nil
-}
-
-// HashPassword хэширует пароль с использованием соли
-func HashPassword(password string, salt string) (string, error) {
-	hashedPassword := password + salt
-	hash, err := bcrypt.GenerateFromPassword([]byte(hashedPassword), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
-	}
-	return string(hash), nil
-}
-
 // Create создает нового пользователя
 func (s *UserService) Create(ctx context.Context, req *userProto.CreateUserRequest) (*userProto.CreateUserResponse, error) {
 	if err := s.checkContextCancelled(ctx, "Create"); err != nil {
 		return nil, status.Error(codes.Canceled, err.Error())
 	}
 
-	// Проверка уникальности имени пользователя
-	_, err := s.repo.GetUserByUsername(ctx, req.Username)
-	if err == nil {
-		s.logger.WarnContext(ctx, fmt.Sprintf("username already exists: %s", req.Username))
-		return nil, status.Error(codes.AlreadyExists, "username already exists")
-	} else if !errors.Is(err, repository.ErrUserNotFound) {
-		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to check username uniqueness: %s", req.Username), slog.Any("error", err))
-		return nil, status.Error(codes.Internal, "failed to check username uniqueness")
-	}
-
-	// Проверка уникальности электронной почты
-	_, err = s.repo.GetUserByEmail(ctx, req.Email)
-	if err == nil {
-		s.logger.WarnContext(ctx, fmt.Sprintf("email already exists: %s", req.Email))
-		return nil, status.Error(codes.AlreadyExists, "email already exists")
-	} else if !errors.Is(err, repository.ErrUserNotFound) {
-		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to check email uniqueness: %s", req.Email), slog.Any("error", err))
-		return nil, status.Error(codes.Internal, "failed to check email uniqueness")
-	}
-
-	// Генерация соли
-	salt, err := GenerateSalt()
-	if err != nil {
-		s.logger.ErrorContext(ctx, "failed to generate salt", slog.Any("error", err))
-		return nil, status.Error(codes.Internal, "failed to generate salt")
+	// Проверка пароля по парольной политике
+	if err := s.validatePassword(ctx, req.Password, req.Username, req.Email); err != nil {
+		return nil, err
 	}
 
 	// Хеширование пароля
-	hashedPassword, err := HashPassword(req.Password, salt)
+	hashedPassword, err := s.passwords.Hash(req.Password)
 	if err != nil {
 		s.logger.ErrorContext(ctx, "failed to hash password", slog.Any("error", err))
 		return nil, status.Error(codes.Internal, "failed to hash password")
@@ -106,18 +136,45 @@ func (s *UserService) Create(ctx context.Context, req *userProto.CreateUserReque
 		Username: req.Username,
 		Email:    req.Email,
 		Pwdhash:  hashedPassword,
-		Salt:     salt,
 	}
 
-	// Сохранение пользователя в базе данных
-	createdUser, err := s.repo.CreateUser(ctx, newUser)
+	// Сохранение пользователя вместе с токеном подтверждения почты в одной транзакции,
+	// чтобы не появился пользователь, которому нечем подтвердить почту
+	sendVerification := s.emailVerificationEnabled() && s.notifier != nil
+	var createdUser *repository.User
+	var verification *issuedVerification
+	err = s.repo.WithinTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if createdUser, err = repo.CreateUser(ctx, newUser); err != nil {
+			return err
+		}
+		if !sendVerification {
+			return nil
+		}
+		verification, err = s.issueVerification(ctx, s.txVerifications(repo), createdUser.User)
+		return err
+	})
 	if err != nil {
+		if alreadyExists := s.alreadyExistsError(ctx, err); alreadyExists != nil {
+			return nil, alreadyExists
+		}
+		if errors.Is(err, repository.ErrTxConflict) {
+			return nil, errTxConflict
+		}
 		s.logger.ErrorContext(ctx, "failed to create user", slog.Any("error", err))
 		return nil, status.Error(codes.Internal, "failed to create user")
 	}
 
+	// Уведомление отправляется после фиксации; ошибка не отменяет регистрацию, токен можно запросить повторно
+	if verification != nil {
+		if err := s.notifyVerification(ctx, createdUser.User, verification); err != nil {
+			s.logger.WarnContext(ctx, fmt.Sprintf("failed to send verification for user with ID: %d", createdUser.Id), slog.Any("error", err))
+		}
+	}
+
+	s.setUserVersion(ctx, createdUser.Version)
 	s.logger.InfoContext(ctx, fmt.Sprintf("user created successfully with username: %s", req.Username))
-	return &userProto.CreateUserResponse{User: createdUser}, nil
+	return &userProto.CreateUserResponse{User: createdUser.User}, nil
 }
 
 // GetByID получает пользователя по ID
@@ -137,8 +194,9 @@ func (s *UserService) GetByID(ctx context.Context, req *userProto.GetUserRequest
 		return nil, status.Error(codes.Internal, "failed to get user")
 	}
 
+	s.setUserVersion(ctx, user.Version)
 	s.logger.InfoContext(ctx, fmt.Sprintf("user fetched successfully with ID: %d", userID))
-	return &userProto.GetUserResponse{User: user}, nil
+	return &userProto.GetUserResponse{User: user.User}, nil
 }
 
 // Update обновляет информацию о пользователе
@@ -150,64 +208,96 @@ func (s *UserService) Update(ctx context.Context, req *userProto.UpdateUserReque
 	userID := req.Id
 	s.logger.DebugContext(ctx, fmt.Sprintf("received request to update user with ID: %d", userID), slog.Any("request", req))
 
-	// Получаем существующего пользователя по ID
-	existingUser, err := s.repo.GetUserByID(ctx, uint(req.Id))
+	// Версия, которую видел клиент; если она передана, обновление устаревшей копии отклоняется
+	expectedVersion, err := expectedVersionFromMetadata(ctx)
 	if err != nil {
-		if errors.Is(err, repository.ErrUserNotFound) {
-			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
-			return nil, status.Error(codes.NotFound, "user not found")
-		}
-		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for update with ID: %d", userID), slog.Any("error", err))
-		return nil, status.Error(codes.Internal, "failed to get user")
+		return nil, err
 	}
 
-	// Создаем объект для обновления
-	userToUpdate := &userProto.User{
-		Id:        req.Id,
-		Username:  existingUser.Username,
-		Email:     existingUser.Email,
-		Pwdhash:   existingUser.Pwdhash,
-		Salt:      existingUser.Salt,
-		CreatedAt: existingUser.CreatedAt,
-		UpdatedAt: existingUser.UpdatedAt,
-	}
+	// Чтение, проверки и обновление выполняются в одной транзакции, чтобы между ними пользователя не изменили
+	var updatedUser *repository.User
+	var hashedPassword string
+	err = s.repo.WithinTx(ctx, func(repo repository.Repository) error {
+		existingUser, err := repo.GetUserByID(ctx, uint(req.Id))
+		if err != nil {
+			return err
+		}
+		if expectedVersion != repository.AnyVersion && existingUser.Version != expectedVersion {
+			s.logger.InfoContext(ctx, fmt.Sprintf("stale update rejected for user with ID: %d, expected version %d, current %d", userID, expectedVersion, existingUser.Version))
+			return staleVersionError(existingUser.Version)
+		}
 
-	// Обновляем разрешенные поля
-	if req.Username != "" {
-		userToUpdate.Username = req.Username
-	}
-	if req.Email != "" {
-		userToUpdate.Email = req.Email
-	}
+		// Создаем объект для обновления
+		userToUpdate := &userProto.User{
+			Id:        req.Id,
+			Username:  existingUser.Username,
+			Email:     existingUser.Email,
+			Pwdhash:   existingUser.Pwdhash,
+			Salt:      existingUser.Salt,
+			CreatedAt: existingUser.CreatedAt,
+			UpdatedAt: existingUser.UpdatedAt,
+		}
 
-	// Если передан новый пароль, генерируем соль и хэшируем
-	if req.Password != "" {
-		salt, err := GenerateSalt()
-		if err != nil {
-			s.logger.ErrorContext(ctx, "failed to generate salt for password update", slog.Any("error", err))
-			return nil, status.Error(codes.Internal, "failed to update password")
+		// Обновляем разрешенные поля
+		if req.Username != "" {
+			userToUpdate.Username = req.Username
 		}
-		hashedPassword, err := HashPassword(req.Password, salt)
-		if err != nil {
-			s.logger.ErrorContext(ctx, "failed to hash password for update", slog.Any("error", err))
-			return nil, status.Error(codes.Internal, "failed to update password")
+		if req.Email != "" {
+			userToUpdate.Email = req.Email
 		}
-		userToUpdate.Pwdhash = hashedPassword
-		userToUpdate.Salt = salt
-	}
 
-	// Обновляем пользователя в репозитории
-	updatedUser, err := s.repo.UpdateUser(ctx, userToUpdate)
+		// Если передан новый пароль, проверяем его по политике и хэшируем текущим алгоритмом.
+		// Хеш вычисляется один раз: при повторе транзакции пароль тот же.
+		if req.Password != "" {
+			if err := s.validatePassword(ctx, req.Password, userToUpdate.Username, userToUpdate.Email); err != nil {
+				return err
+			}
+			if hashedPassword == "" {
+				if hashedPassword, err = s.passwords.Hash(req.Password); err != nil {
+					s.logger.ErrorContext(ctx, "failed to hash password for update", slog.Any("error", err))
+					return status.Error(codes.Internal, "failed to update password")
+				}
+			}
+			userToUpdate.Pwdhash = hashedPassword
+			userToUpdate.Salt = ""
+		}
+
+		// Обновляем пользователя, только если его не изменили после чтения
+		updatedUser, err = repo.UpdateUser(ctx, userToUpdate, existingUser.Version)
+		return err
+	})
 	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		if errors.Is(err, repository.ErrVersionConflict) {
+			return nil, errConcurrentUpdate
+		}
+		if errors.Is(err, repository.ErrTxConflict) {
+			return nil, errTxConflict
+		}
+		if alreadyExists := s.alreadyExistsError(ctx, err); alreadyExists != nil {
+			return nil, alreadyExists
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
 		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to update user with ID: %d", userID), slog.Any("error", err))
 		return nil, status.Error(codes.Internal, "failed to update user")
 	}
 
+	// Смена пароля завершает все сеансы пользователя
+	if req.Password != "" {
+		s.revokeAllSessions(ctx, uint(userID))
+	}
+
+	s.setUserVersion(ctx, updatedUser.Version)
 	s.logger.InfoContext(ctx, fmt.Sprintf("user updated successfully with ID: %d", userID))
-	return &userProto.UpdateUserResponse{User: updatedUser}, nil
+	return &userProto.UpdateUserResponse{User: updatedUser.User}, nil
 }
 
-// Delete удаляет пользователя по ID
+// Delete удаляет пользователя по ID; до истечения срока хранения его можно восстановить через RestoreUser
 func (s *UserService) Delete(ctx context.Context, req *userProto.DeleteUserRequest) (*userProto.DeleteUserResponse, error) {
 	if err := s.checkContextCancelled(ctx, "Delete"); err != nil {
 		return nil, status.Error(codes.Canceled, err.Error())
@@ -228,6 +318,28 @@ func (s *UserService) Delete(ctx context.Context, req *userProto.DeleteUserReque
 	return &userProto.DeleteUserResponse{Success: true}, nil
 }
 
+// RestoreUser восстанавливает удаленного пользователя
+func (s *UserService) RestoreUser(ctx context.Context, req *userProto.RestoreUserRequest) (*userProto.RestoreUserResponse, error) {
+	if err := s.checkContextCancelled(ctx, "RestoreUser"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	userID := uint(req.Id)
+	restoredUser, err := s.repo.RestoreUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("deleted user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "deleted user not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to restore user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to restore user")
+	}
+
+	s.setUserVersion(ctx, restoredUser.Version)
+	s.logger.InfoContext(ctx, fmt.Sprintf("user restored successfully with ID: %d", userID))
+	return &userProto.RestoreUserResponse{User: withoutCredentials(restoredUser.User), Version: restoredUser.Version}, nil
+}
+
 // CheckPass проверяет правильность пароля для заданного пользователя
 func (s *UserService) CheckPass(ctx context.Context, req *userProto.CheckPasswordRequest) (*userProto.CheckPasswordResponse, error) {
 	if err := s.checkContextCancelled(ctx, "CheckPass"); err != nil {
@@ -237,7 +349,8 @@ func (s *UserService) CheckPass(ctx context.Context, req *userProto.CheckPasswor
 	userID := req.UserId
 	s.logger.DebugContext(ctx, fmt.Sprintf("received request to check password for user with ID: %d", userID))
 
-	user, err := s.repo.GetUserByID(ctx, uint(req.UserId))
+	// Пароль проверяется по основной базе: реплика может еще хранить прежний хеш
+	user, err := s.repo.GetUserByID(repository.WithPrimary(ctx), uint(req.UserId))
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
 			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
@@ -247,8 +360,11 @@ func (s *UserService) CheckPass(ctx context.Context, req *userProto.CheckPasswor
 		return nil, status.Error(codes.Internal, "failed to check password")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Pwdhash), []byte(req.Password+user.Salt))
+	valid, err := s.checkCredentials(ctx, user, req.Password, otpCodeFromMetadata(ctx))
 	if err != nil {
+		return nil, err
+	}
+	if !valid {
 		s.logger.DebugContext(ctx, fmt.Sprintf("incorrect password for user with ID: %d", userID))
 		return &userProto.CheckPasswordResponse{Valid: false}, nil
 	}
@@ -256,3 +372,104 @@ func (s *UserService) CheckPass(ctx context.Context, req *userProto.CheckPasswor
 	s.logger.InfoContext(ctx, fmt.Sprintf("password check successful for user with ID: %d", userID))
 	return &userProto.CheckPasswordResponse{Valid: true}, nil
 }
+
+// alreadyExistsError переводит занятость имени или почты в AlreadyExists с указанием поля; для прочих ошибок возвращает nil
+func (s *UserService) alreadyExistsError(ctx context.Context, err error) error {
+	var field string
+	switch {
+	case errors.Is(err, repository.ErrUsernameTaken):
+		field = "username"
+	case errors.Is(err, repository.ErrEmailTaken):
+		field = "email"
+	default:
+		return nil
+	}
+	s.logger.WarnContext(ctx, field+" already exists")
+
+	st := status.New(codes.AlreadyExists, field+" already exists")
+	detailed, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "ALREADY_EXISTS",
+		Domain:   "user.watchlist-kata",
+		Metadata: map[string]string{"field": field},
+	})
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// checkCredentials проверяет блокировку, пароль, второй фактор, коллизию имени или почты и подтверждение почты.
+// Неверный пароль дает valid=false без ошибки, остальные отказы возвращаются статусами gRPC.
+// Попытка засчитывается как неудачная до проверки пароля и снимается только после успешной проверки
+// обоих факторов, поэтому запрос без кода второго фактора тоже расходует попытку.
+func (s *UserService) checkCredentials(ctx context.Context, user *repository.User, password, otpCode string) (bool, error) {
+	userID := uint(user.Id)
+	if err := s.reserveLoginAttempt(ctx, userID); err != nil {
+		return false, err
+	}
+
+	valid, err := s.verifyPassword(ctx, user, password)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to verify password for user with ID: %d", userID), slog.Any("error", err))
+		return false, status.Error(codes.Internal, "failed to check credentials")
+	}
+	if !valid {
+		return false, nil
+	}
+
+	if err := s.checkSecondFactor(ctx, userID, otpCode); err != nil {
+		return false, err
+	}
+	s.clearLoginAttempts(ctx, userID)
+
+	if user.PendingRename() {
+		return false, s.pendingRenameError(ctx, userID)
+	}
+	if err := s.checkEmailVerified(ctx, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pendingRenameError отказ во входе пользователю, имя или почта которого сохранены с суффиксом после коллизии
+func (s *UserService) pendingRenameError(ctx context.Context, userID uint) error {
+	s.logger.WarnContext(ctx, fmt.Sprintf("login rejected: username or email of user with ID: %d collided and must be changed", userID))
+	st := status.New(codes.FailedPrecondition, "username or email must be changed before login")
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: "IDENTITY_COLLISION", Domain: "user.watchlist-kata"})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// verifyPassword проверяет пароль пользователя и при успехе переводит хеш на текущий алгоритм
+func (s *UserService) verifyPassword(ctx context.Context, user *repository.User, password string) (bool, error) {
+	valid, rehash, err := s.passwords.Verify(password, user.Pwdhash, user.Salt)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, user, password)
+	}
+	return true, nil
+}
+
+// rehashPassword сохраняет хеш пароля, созданный текущим алгоритмом.
+// Ошибки только логируются: пароль уже проверен, и вход не должен от них зависеть.
+// Замена хеша не меняет версию пользователя, а в журнал изменений попадает без значений;
+// если пароль успели сменить, новый хеш не сохраняется.
+func (s *UserService) rehashPassword(ctx context.Context, user *repository.User, password string) {
+	hashedPassword, err := s.passwords.Hash(password)
+	if err != nil {
+		s.logger.WarnContext(ctx, fmt.Sprintf("failed to rehash password for user with ID: %d", user.Id), slog.Any("error", err))
+		return
+	}
+
+	if err := s.repo.RehashPassword(ctx, uint(user.Id), user.Pwdhash, hashedPassword); err != nil {
+		s.logger.WarnContext(ctx, fmt.Sprintf("failed to store rehashed password for user with ID: %d", user.Id), slog.Any("error", err))
+		return
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("password hash upgraded for user with ID: %d", user.Id))
+}
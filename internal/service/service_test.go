@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+)
+
+// createTestUser создает пользователя напрямую в хранилище
+func createTestUser(t *testing.T, repo repository.Repository, username string) *repository.User {
+	t.Helper()
+	user, err := repo.CreateUser(context.Background(), &userProto.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Pwdhash:  "$argon2id$unused",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
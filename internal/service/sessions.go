@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Ключи метаданных, из которых берутся сведения о клиенте сеанса
+const (
+	deviceLabelMetadataKey  = "x-device-label"
+	userAgentMetadataKey    = "user-agent"
+	forwardedForMetadataKey = "x-forwarded-for"
+)
+
+// Ограничения длины сведений о клиенте, совпадающие с размерами колонок
+const (
+	maxDeviceLabelLength = 255
+	maxUserAgentLength   = 512
+	maxPeerAddressLength = 255
+)
+
+// WithSessions включает учет сеансов входа; сеанс без активности дольше ttl считается завершенным
+func WithSessions(store repository.SessionRepository, ttl time.Duration) Option {
+	return func(s *UserService) {
+		s.sessions = store
+		s.sessionTTL = ttl
+	}
+}
+
+// WithTrustedProxies задает шлюзы, которым доверяется заголовок x-forwarded-for.
+// От остальных клиентов заголовок игнорируется, и адресом сеанса считается адрес соединения.
+func WithTrustedProxies(proxies []netip.Prefix) Option {
+	return func(s *UserService) {
+		s.trustedProxies = proxies
+	}
+}
+
+// ListSessions возвращает действующие сеансы пользователя
+func (s *UserService) ListSessions(ctx context.Context, req *userProto.ListSessionsRequest) (*userProto.ListSessionsResponse, error) {
+	if err := s.checkContextCancelled(ctx, "ListSessions"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.sessions == nil {
+		return nil, status.Error(codes.Unimplemented, "sessions are not configured")
+	}
+
+	userID := uint(req.UserId)
+	sessions, err := s.sessions.ListSessions(ctx, userID, time.Now())
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to list sessions for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to list sessions")
+	}
+
+	resp := &userProto.ListSessionsResponse{Sessions: make([]*userProto.Session, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &userProto.Session{
+			Id:          session.ID,
+			DeviceLabel: session.DeviceLabel,
+			UserAgent:   session.UserAgent,
+			PeerAddress: session.PeerAddress,
+			CreatedAt:   session.CreatedAt.Format(time.RFC3339),
+			LastSeenAt:  session.LastSeenAt.Format(time.RFC3339),
+			ExpiresAt:   session.ExpiresAt.Format(time.RFC3339),
+			Current:     req.CurrentSessionId != "" && session.ID == req.CurrentSessionId,
+		})
+	}
+	return resp, nil
+}
+
+// RevokeSession завершает сеанс пользователя и отзывает его refresh-токены
+func (s *UserService) RevokeSession(ctx context.Context, req *userProto.RevokeSessionRequest) (*userProto.RevokeSessionResponse, error) {
+	if err := s.checkContextCancelled(ctx, "RevokeSession"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.sessions == nil {
+		return nil, status.Error(codes.Unimplemented, "sessions are not configured")
+	}
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session ID is required")
+	}
+
+	userID := uint(req.UserId)
+	if err := s.sessions.RevokeSession(ctx, userID, req.SessionId); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke session for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to revoke session")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("session revoked for user with ID: %d", userID))
+	return &userProto.RevokeSessionResponse{Success: true}, nil
+}
+
+// RevokeAllOtherSessions завершает все сеансы пользователя, кроме текущего
+func (s *UserService) RevokeAllOtherSessions(ctx context.Context, req *userProto.RevokeAllOtherSessionsRequest) (*userProto.RevokeAllOtherSessionsResponse, error) {
+	if err := s.checkContextCancelled(ctx, "RevokeAllOtherSessions"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if s.sessions == nil {
+		return nil, status.Error(codes.Unimplemented, "sessions are not configured")
+	}
+	if req.CurrentSessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "current session ID is required")
+	}
+
+	userID := uint(req.UserId)
+	revoked, err := s.sessions.RevokeUserSessions(ctx, userID, req.CurrentSessionId)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke sessions for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to revoke sessions")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("revoked %d other sessions for user with ID: %d", revoked, userID))
+	return &userProto.RevokeAllOtherSessionsResponse{RevokedCount: revoked}, nil
+}
+
+// startSession создает сеанс для успешного входа; без учета сеансов возвращает пустой ID
+func (s *UserService) startSession(ctx context.Context, userID uint) (string, error) {
+	if s.sessions == nil {
+		return "", nil
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	deviceLabel, userAgent, peerAddress := s.clientInfo(ctx)
+	now := time.Now()
+	session, err := s.sessions.CreateSession(ctx, &repository.Session{
+		ID:          id,
+		UserID:      userID,
+		DeviceLabel: deviceLabel,
+		UserAgent:   userAgent,
+		PeerAddress: peerAddress,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.sessionTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// touchSession продлевает сеанс при обновлении токенов. Для отозванного или истекшего сеанса
+// возвращает errInvalidRefreshToken: его токены больше не обмениваются.
+func (s *UserService) touchSession(ctx context.Context, sessionID string) error {
+	now := time.Now()
+	if err := s.sessions.TouchSession(ctx, sessionID, now, now.Add(s.sessionTTL)); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			s.logger.DebugContext(ctx, fmt.Sprintf("refresh failed: session %s is revoked or expired", sessionID))
+			return errInvalidRefreshToken
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to update session: %s", sessionID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to refresh token")
+	}
+	return nil
+}
+
+// endSession помечает сеанс завершенным при выходе или отзыве его цепочки токенов
+func (s *UserService) endSession(ctx context.Context, userID uint, sessionID string) {
+	if s.sessions == nil {
+		return
+	}
+	if err := s.sessions.RevokeSession(ctx, userID, sessionID); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+		s.logger.WarnContext(ctx, fmt.Sprintf("failed to end session for user with ID: %d", userID), slog.Any("error", err))
+	}
+}
+
+// revokeAllSessions завершает все сеансы и отзывает refresh-токены пользователя после смены пароля
+func (s *UserService) revokeAllSessions(ctx context.Context, userID uint) {
+	if s.sessions != nil {
+		if _, err := s.sessions.RevokeUserSessions(ctx, userID, ""); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke sessions after password change for user with ID: %d", userID), slog.Any("error", err))
+		}
+		return
+	}
+
+	if s.refreshTokens != nil {
+		if err := s.refreshTokens.RevokeUserRefreshTokens(ctx, userID); err != nil {
+			s.logger.ErrorContext(ctx, fmt.Sprintf("failed to revoke refresh tokens after password change for user with ID: %d", userID), slog.Any("error", err))
+		}
+	}
+}
+
+// clientInfo извлекает название устройства, User-Agent и адрес клиента из метаданных и peer запроса.
+// Если запрос пришел от доверенного шлюза, адрес клиента берется из x-forwarded-for.
+func (s *UserService) clientInfo(ctx context.Context) (deviceLabel, userAgent, peerAddress string) {
+	var forwarded []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		deviceLabel = firstMetadataValue(md, deviceLabelMetadataKey)
+		userAgent = firstMetadataValue(md, userAgentMetadataKey)
+		forwarded = md.Get(forwardedForMetadataKey)
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerAddress = p.Addr.String()
+		if addrPort, err := netip.ParseAddrPort(peerAddress); err == nil && s.trustedProxy(addrPort.Addr()) {
+			if client := s.forwardedClient(forwarded); client != "" {
+				peerAddress = client
+			}
+		}
+	}
+	return truncate(deviceLabel, maxDeviceLabelLength), truncate(userAgent, maxUserAgentLength), truncate(peerAddress, maxPeerAddressLength)
+}
+
+// forwardedClient возвращает адрес клиента из значений x-forwarded-for. Каждый шлюз дописывает адрес
+// справа, поэтому список просматривается с конца до первого адреса, не принадлежащего доверенным шлюзам.
+func (s *UserService) forwardedClient(values []string) string {
+	var hops []string
+	for _, value := range values {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			// Непонятное значение мог подставить сам клиент, левее него доверять нечему
+			return ""
+		}
+		if !s.trustedProxy(addr) {
+			return addr.String()
+		}
+	}
+	return ""
+}
+
+// trustedProxy сообщает, что адрес принадлежит доверенному шлюзу
+func (s *UserService) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstMetadataValue возвращает первое значение ключа метаданных
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// truncate обрезает строку до max байт, не разрывая символы UTF-8
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	value = value[:max]
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"github.com/watchlist-kata/user/internal/token"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// stringAddr адрес соединения с произвольным строковым представлением
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestClientInfoPeerAddress(t *testing.T) {
+	s := NewUserService(nil, repositorytest.DiscardLogger(), WithTrustedProxies([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}))
+	tcp := func(addr string) net.Addr {
+		return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+	}
+
+	tests := []struct {
+		name      string
+		peer      net.Addr
+		forwarded []string
+		want      string
+	}{
+		{name: "direct client", peer: tcp("203.0.113.7:5000"), want: "203.0.113.7:5000"},
+		{name: "untrusted peer ignores header", peer: tcp("203.0.113.7:5000"), forwarded: []string{"198.51.100.1"}, want: "203.0.113.7:5000"},
+		{name: "trusted proxy", peer: tcp("10.0.0.2:5000"), forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted proxy chain", peer: tcp("10.0.0.2:5000"), forwarded: []string{"198.51.100.1, 10.1.1.1"}, want: "198.51.100.1"},
+		{name: "spoofed leftmost entry", peer: tcp("10.0.0.2:5000"), forwarded: []string{"1.1.1.1, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "repeated header", peer: tcp("10.0.0.2:5000"), forwarded: []string{"1.1.1.1", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "IPv6 proxy", peer: tcp("[fd00::1]:5000"), forwarded: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "only proxies", peer: tcp("10.0.0.2:5000"), forwarded: []string{"10.1.1.1"}, want: "10.0.0.2:5000"},
+		{name: "malformed entry", peer: tcp("10.0.0.2:5000"), forwarded: []string{"198.51.100.1, unknown"}, want: "10.0.0.2:5000"},
+		{name: "truncated", peer: stringAddr(strings.Repeat("a", 300)), want: strings.Repeat("a", maxPeerAddressLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: tt.peer})
+			md := metadata.MD{}
+			for _, value := range tt.forwarded {
+				md.Append(forwardedForMetadataKey, value)
+			}
+			ctx = metadata.NewIncomingContext(ctx, md)
+
+			if _, _, got := s.clientInfo(ctx); got != tt.want {
+				t.Errorf("peer address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Refresh-токен сеанса действует дольше самого сеанса, поэтому отказ вызван именно истекшим сеансом
+func TestRefreshRejectsExpiredSession(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	user := createTestUser(t, repo, "alice")
+
+	key, err := token.GenerateEd25519SigningKey("test")
+	if err != nil {
+		t.Fatalf("GenerateEd25519SigningKey: %v", err)
+	}
+	issuer, err := token.NewIssuer(token.Config{Issuer: "watchlist-user", TTL: time.Minute}, []*token.SigningKey{key}, "")
+	if err != nil {
+		t.Fatalf("NewIssuer: %v", err)
+	}
+	s := NewUserService(repo, repositorytest.DiscardLogger(),
+		WithTokenIssuer(issuer),
+		WithRefreshTokens(repo, time.Hour),
+		WithSessions(repo, time.Hour),
+	)
+
+	tests := []struct {
+		name    string
+		expires time.Duration
+		want    codes.Code
+	}{
+		{name: "active session", expires: time.Hour, want: codes.OK},
+		{name: "expired session", expires: -time.Minute, want: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID, err := randomID()
+			if err != nil {
+				t.Fatalf("randomID: %v", err)
+			}
+			now := time.Now()
+			_, err = repo.CreateSession(ctx, &repository.Session{
+				ID:         sessionID,
+				UserID:     uint(user.Id),
+				LastSeenAt: now,
+				ExpiresAt:  now.Add(tt.expires),
+			})
+			if err != nil {
+				t.Fatalf("CreateSession: %v", err)
+			}
+			rawToken, refreshToken, err := s.newRefreshToken(uint(user.Id), sessionID)
+			if err != nil {
+				t.Fatalf("newRefreshToken: %v", err)
+			}
+			if _, err := repo.CreateRefreshToken(ctx, refreshToken); err != nil {
+				t.Fatalf("CreateRefreshToken: %v", err)
+			}
+			_, err = s.Refresh(ctx, &userProto.RefreshRequest{RefreshToken: rawToken})
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("Refresh error = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
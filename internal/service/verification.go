@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/notify"
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidVerificationToken единый ответ на неизвестный, истекший или использованный токен подтверждения
+var errInvalidVerificationToken = status.Error(codes.InvalidArgument, "invalid or expired verification token")
+
+// WithNotifier задает способ доставки уведомлений с одноразовыми токенами
+func WithNotifier(notifier notify.Notifier) Option {
+	return func(s *UserService) {
+		s.notifier = notifier
+	}
+}
+
+// WithEmailVerification включает подтверждение почты; required запрещает вход с неподтвержденной почтой
+func WithEmailVerification(store repository.VerificationRepository, ttl time.Duration, required bool) Option {
+	return func(s *UserService) {
+		s.verifications = store
+		s.verificationTTL = ttl
+		s.requireVerifiedEmail = required
+	}
+}
+
+// SendVerification отправляет пользователю новый токен подтверждения почты, аннулируя предыдущие
+func (s *UserService) SendVerification(ctx context.Context, req *userProto.SendVerificationRequest) (*userProto.SendVerificationResponse, error) {
+	if err := s.checkContextCancelled(ctx, "SendVerification"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if !s.emailVerificationEnabled() || s.notifier == nil {
+		return nil, status.Error(codes.Unimplemented, "email verification is not configured")
+	}
+
+	userID := uint(req.UserId)
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("user not found with ID: %d", userID))
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get user for verification with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to send verification")
+	}
+
+	verifiedAt, err := s.verifications.GetEmailVerifiedAt(ctx, userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get email verification state for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to send verification")
+	}
+	if verifiedAt != nil {
+		return nil, status.Error(codes.FailedPrecondition, "email already verified")
+	}
+
+	expiresAt, err := s.sendVerification(ctx, user.User)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to send verification for user with ID: %d", userID), slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to send verification")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("verification sent for user with ID: %d", userID))
+	return &userProto.SendVerificationResponse{Success: true, ExpiresAt: expiresAt.Format(time.RFC3339)}, nil
+}
+
+// VerifyEmail подтверждает почту по одноразовому токену
+func (s *UserService) VerifyEmail(ctx context.Context, req *userProto.VerifyEmailRequest) (*userProto.VerifyEmailResponse, error) {
+	if err := s.checkContextCancelled(ctx, "VerifyEmail"); err != nil {
+		return nil, status.Error(codes.Canceled, err.Error())
+	}
+
+	if !s.emailVerificationEnabled() {
+		return nil, status.Error(codes.Unimplemented, "email verification is not configured")
+	}
+	if req.Token == "" {
+		return nil, status.Error(codes.InvalidArgument, "verification token is required")
+	}
+
+	// Токен сжигается в одной транзакции с подтверждением почты: если почта не подтвердится, токен останется действующим
+	now := time.Now()
+	var verification *repository.VerificationToken
+	err := s.repo.WithinTx(ctx, func(repo repository.Repository) error {
+		store := s.txVerifications(repo)
+		var err error
+		verification, err = store.ConsumeVerificationToken(ctx, repository.PurposeEmailVerification, hashToken(req.Token), now)
+		if err != nil {
+			return err
+		}
+		// Токен подтверждает только тот адрес, на который был отправлен
+		return store.MarkEmailVerified(ctx, verification.UserID, verification.Email, now)
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrVerificationTokenInvalid) {
+			s.logger.DebugContext(ctx, "email verification failed: invalid token")
+			return nil, errInvalidVerificationToken
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			s.logger.WarnContext(ctx, fmt.Sprintf("email verification failed: email changed for user with ID: %d", verification.UserID))
+			return nil, errInvalidVerificationToken
+		}
+		if errors.Is(err, repository.ErrTxConflict) {
+			return nil, errTxConflict
+		}
+		s.logger.ErrorContext(ctx, "failed to verify email", slog.Any("error", err))
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("email verified successfully for user with ID: %d", verification.UserID))
+	return &userProto.VerifyEmailResponse{Success: true, UserId: int64(verification.UserID)}, nil
+}
+
+// emailVerificationEnabled сообщает, что подтверждение почты настроено
+func (s *UserService) emailVerificationEnabled() bool {
+	return s.verifications != nil && s.verificationTTL > 0
+}
+
+// txVerifications возвращает хранилище токенов подтверждения в транзакции repo из WithinTx.
+// Если токены хранятся отдельно от пользователей, общей транзакции нет и используется s.verifications.
+func (s *UserService) txVerifications(repo repository.Repository) repository.VerificationRepository {
+	if store, ok := repository.TxVerifications(repo); ok {
+		return store
+	}
+	return s.verifications
+}
+
+// issuedVerification выданный токен подтверждения почты, который еще нужно отправить пользователю
+type issuedVerification struct {
+	rawToken  string
+	expiresAt time.Time
+}
+
+// sendVerification аннулирует прежние токены подтверждения, создает новый и отправляет его пользователю
+func (s *UserService) sendVerification(ctx context.Context, user *userProto.User) (time.Time, error) {
+	verification, err := s.issueVerification(ctx, s.verifications, user)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := s.notifyVerification(ctx, user, verification); err != nil {
+		return time.Time{}, err
+	}
+	return verification.expiresAt, nil
+}
+
+// issueVerification аннулирует прежние токены подтверждения в store и создает новый
+func (s *UserService) issueVerification(ctx context.Context, store repository.VerificationRepository, user *userProto.User) (*issuedVerification, error) {
+	userID := uint(user.Id)
+	if err := store.InvalidateVerificationTokens(ctx, userID, repository.PurposeEmailVerification); err != nil {
+		return nil, err
+	}
+
+	rawToken, tokenHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.verificationTTL)
+	_, err = store.CreateVerificationToken(ctx, &repository.VerificationToken{
+		UserID:    userID,
+		Purpose:   repository.PurposeEmailVerification,
+		TokenHash: tokenHash,
+		Email:     user.Email,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &issuedVerification{rawToken: rawToken, expiresAt: expiresAt}, nil
+}
+
+// notifyVerification отправляет пользователю выданный токен подтверждения
+func (s *UserService) notifyVerification(ctx context.Context, user *userProto.User, verification *issuedVerification) error {
+	return s.notifier.Send(ctx, notify.Message{
+		Type:      notify.TypeEmailVerification,
+		UserID:    user.Id,
+		Email:     user.Email,
+		Token:     verification.rawToken,
+		ExpiresAt: verification.expiresAt,
+	})
+}
+
+// checkEmailVerified возвращает FailedPrecondition, если вход требует подтвержденной почты, а она не подтверждена
+func (s *UserService) checkEmailVerified(ctx context.Context, userID uint) error {
+	if !s.emailVerificationEnabled() || !s.requireVerifiedEmail {
+		return nil
+	}
+
+	verifiedAt, err := s.verifications.GetEmailVerifiedAt(ctx, userID)
+	if err != nil {
+		s.logger.ErrorContext(ctx, fmt.Sprintf("failed to get email verification state for user with ID: %d", userID), slog.Any("error", err))
+		return status.Error(codes.Internal, "failed to check email verification")
+	}
+	if verifiedAt != nil {
+		return nil
+	}
+
+	s.logger.InfoContext(ctx, fmt.Sprintf("login rejected: email not verified for user with ID: %d", userID))
+	st := status.New(codes.FailedPrecondition, "email not verified")
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: "EMAIL_NOT_VERIFIED", Domain: "user.watchlist-kata"})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	userProto "github.com/watchlist-kata/protos/user"
+	"github.com/watchlist-kata/user/internal/notify"
+	"github.com/watchlist-kata/user/internal/repository"
+	"github.com/watchlist-kata/user/internal/repository/repositorytest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recordingNotifier запоминает отправленные уведомления
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Send(_ context.Context, msg notify.Message) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// failingTokensRepository не создает токены подтверждения внутри WithinTx
+type failingTokensRepository struct {
+	*repository.SQLRepository
+}
+
+func (r failingTokensRepository) WithinTx(ctx context.Context, fn func(repo repository.Repository) error, opts ...repository.TxOption) error {
+	return r.SQLRepository.WithinTx(ctx, func(repo repository.Repository) error {
+		return fn(failingTokensRepository{SQLRepository: repo.(*repository.SQLRepository)})
+	}, opts...)
+}
+
+func (r failingTokensRepository) CreateVerificationToken(context.Context, *repository.VerificationToken) (*repository.VerificationToken, error) {
+	return nil, errors.New("token store is unavailable")
+}
+
+// txConflictRepository выполняет WithinTx так, будто транзакция не сериализовалась ни с одной попытки
+type txConflictRepository struct {
+	repository.Repository
+}
+
+func (r txConflictRepository) WithinTx(context.Context, func(repo repository.Repository) error, ...repository.TxOption) error {
+	return fmt.Errorf("%w after 3 attempts", repository.ErrTxConflict)
+}
+
+func TestCreateIssuesVerificationWithUser(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	notifier := &recordingNotifier{}
+	req := &userProto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "correct horse battery"}
+
+	// Если токен не создан, пользователь тоже не создается
+	failing := NewUserService(failingTokensRepository{SQLRepository: repo}, repositorytest.DiscardLogger(),
+		WithEmailVerification(repo, time.Hour, false), WithNotifier(notifier))
+	if _, err := failing.Create(ctx, req); status.Code(err) != codes.Internal {
+		t.Fatalf("Create with a failing token store error = %v, want code %v", err, codes.Internal)
+	}
+	if _, err := repo.GetUserByUsername(ctx, "alice"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Fatalf("GetUserByUsername after a failed Create error = %v, want %v", err, repository.ErrUserNotFound)
+	}
+	if len(notifier.messages) != 0 {
+		t.Fatalf("Create with a failing token store sent %d notifications", len(notifier.messages))
+	}
+
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithEmailVerification(repo, time.Hour, false), WithNotifier(notifier))
+	created, err := s.Create(ctx, req)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(notifier.messages) != 1 || notifier.messages[0].UserID != created.User.Id {
+		t.Fatalf("notifications = %+v, want one for the created user", notifier.messages)
+	}
+	if _, err := s.VerifyEmail(ctx, &userProto.VerifyEmailRequest{Token: notifier.messages[0].Token}); err != nil {
+		t.Errorf("VerifyEmail with the issued token: %v", err)
+	}
+}
+
+func TestVerifyEmailKeepsTokenWhenEmailChanged(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	user := createTestUser(t, repo, "alice")
+	s := NewUserService(repo, repositorytest.DiscardLogger(), WithEmailVerification(repo, time.Hour, false))
+
+	issue := func(email string) (string, string) {
+		t.Helper()
+		rawToken, tokenHash, err := generateToken()
+		if err != nil {
+			t.Fatalf("generateToken: %v", err)
+		}
+		_, err = repo.CreateVerificationToken(ctx, &repository.VerificationToken{
+			UserID:    uint(user.Id),
+			Purpose:   repository.PurposeEmailVerification,
+			TokenHash: tokenHash,
+			Email:     email,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("CreateVerificationToken: %v", err)
+		}
+		return rawToken, tokenHash
+	}
+
+	// Токен для прежнего адреса не подтверждает почту и не сжигается вместе с неудачным подтверждением
+	rawToken, tokenHash := issue("old@example.com")
+	if _, err := s.VerifyEmail(ctx, &userProto.VerifyEmailRequest{Token: rawToken}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("VerifyEmail for an old email error = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if _, err := repo.GetVerificationToken(ctx, repository.PurposeEmailVerification, tokenHash, time.Now()); err != nil {
+		t.Errorf("token must stay unused after a failed verification: %v", err)
+	}
+
+	rawToken, _ = issue(user.Email)
+	if _, err := s.VerifyEmail(ctx, &userProto.VerifyEmailRequest{Token: rawToken}); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if verifiedAt, err := repo.GetEmailVerifiedAt(ctx, uint(user.Id)); err != nil || verifiedAt == nil {
+		t.Errorf("GetEmailVerifiedAt = %v, %v; want the email verified", verifiedAt, err)
+	}
+}
+
+func TestTxConflictIsAborted(t *testing.T) {
+	ctx := context.Background()
+	repo := repositorytest.NewSQLiteRepository(t)
+	user := createTestUser(t, repo, "alice")
+	s := NewUserService(txConflictRepository{Repository: repo}, repositorytest.DiscardLogger(), WithEmailVerification(repo, time.Hour, false))
+
+	_, err := s.Create(ctx, &userProto.CreateUserRequest{Username: "bob", Email: "bob@example.com", Password: "correct horse battery"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Create error = %v, want code %v", err, codes.Aborted)
+	}
+	_, err = s.Update(ctx, &userProto.UpdateUserRequest{Id: user.Id, Username: "alice2"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Update error = %v, want code %v", err, codes.Aborted)
+	}
+	_, err = s.VerifyEmail(ctx, &userProto.VerifyEmailRequest{Token: "token"})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("VerifyEmail error = %v, want code %v", err, codes.Aborted)
+	}
+}
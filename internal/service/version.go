@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/watchlist-kata/user/internal/repository"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ключи метаданных версии пользователя: сервис возвращает текущую версию в заголовке ответа,
+// клиент может передать ожидаемую версию при обновлении, чтобы не затереть чужие изменения
+const (
+	userVersionHeader          = "x-user-version"
+	expectedVersionMetadataKey = "x-expected-version"
+)
+
+// errConcurrentUpdate ответ на изменение пользователя параллельным запросом между чтением и записью
+var errConcurrentUpdate = status.Error(codes.Aborted, "user was modified concurrently, retry the request")
+
+// errTxConflict ответ на запрос, транзакция которого не сериализовалась с параллельными за все попытки
+var errTxConflict = status.Error(codes.Aborted, "request conflicts with concurrent requests, retry the request")
+
+// setUserVersion передает текущую версию пользователя в заголовке ответа
+func (s *UserService) setUserVersion(ctx context.Context, version int64) {
+	header := metadata.Pairs(userVersionHeader, strconv.FormatInt(version, 10))
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		// Вне gRPC-вызова, например при прямом вызове метода сервиса, заголовки недоступны
+		s.logger.DebugContext(ctx, "failed to set user version header: "+err.Error())
+	}
+}
+
+// expectedVersionFromMetadata возвращает ожидаемую версию из метаданных запроса или AnyVersion, если она не передана
+func expectedVersionFromMetadata(ctx context.Context) (int64, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return repository.AnyVersion, nil
+	}
+	value := firstMetadataValue(md, expectedVersionMetadataKey)
+	if value == "" {
+		return repository.AnyVersion, nil
+	}
+
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, status.Error(codes.InvalidArgument, expectedVersionMetadataKey+" must be a positive integer")
+	}
+	return version, nil
+}
+
+// staleVersionError сообщает клиенту, что его копия пользователя устарела, и передает текущую версию
+func staleVersionError(current int64) error {
+	st := status.New(codes.FailedPrecondition, "user version does not match expected version")
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   "VERSION_MISMATCH",
+		Domain:   "user.watchlist-kata",
+		Metadata: map[string]string{"current_version": strconv.FormatInt(current, 10)},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,173 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrInvalidToken возвращается для токенов с неверной подписью, истекших или выпущенных другим издателем
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims утверждения access-токена
+type Claims struct {
+	jwt.RegisteredClaims
+	Username string   `json:"username"`        // Имя пользователя
+	Roles    []string `json:"roles,omitempty"` // Роли пользователя
+	Session  string   `json:"sid,omitempty"`   // Идентификатор сеанса входа
+}
+
+// UserID возвращает ID пользователя из subject
+func (c *Claims) UserID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed subject", ErrInvalidToken)
+	}
+	return id, nil
+}
+
+// Subject данные пользователя, на которого выпускается токен
+type Subject struct {
+	UserID   int64    // ID пользователя
+	Username string   // Имя пользователя
+	Roles    []string // Роли пользователя
+	Session  string   // Идентификатор сеанса входа (пусто, если сеансы не ведутся)
+}
+
+// Config параметры выпуска access-токенов
+type Config struct {
+	Issuer   string        // Издатель (iss)
+	Audience []string      // Получатели (aud)
+	TTL      time.Duration // Время жизни токена
+}
+
+// Issuer выпускает и проверяет подписанные access-токены.
+// Подписывает активным ключом, а проверяет любым из загруженных, что позволяет ротировать ключи без простоя.
+type Issuer struct {
+	cfg    Config
+	active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+	now    func() time.Time
+}
+
+// NewIssuer создает Issuer; activeKeyID выбирает ключ подписи, пустое значение означает первый ключ
+func NewIssuer(cfg Config, keys []*SigningKey, activeKeyID string) (*Issuer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if cfg.TTL <= 0 {
+		return nil, errors.New("access token TTL must be positive")
+	}
+
+	i := &Issuer{
+		cfg:  cfg,
+		keys: make(map[string]*SigningKey, len(keys)),
+		now:  time.Now,
+	}
+	for _, key := range keys {
+		if _, ok := i.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id: %s", key.ID)
+		}
+		i.keys[key.ID] = key
+		i.order = append(i.order, key.ID)
+	}
+
+	if activeKeyID == "" {
+		activeKeyID = keys[0].ID
+	}
+	active, ok := i.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %s is not loaded", activeKeyID)
+	}
+	i.active = active
+
+	return i, nil
+}
+
+// TTL возвращает время жизни access-токена
+func (i *Issuer) TTL() time.Duration {
+	return i.cfg.TTL
+}
+
+// Issue выпускает access-токен и возвращает его вместе с моментом истечения
+func (i *Issuer) Issue(subject Subject) (string, time.Time, error) {
+	now := i.now()
+	expiresAt := now.Add(i.cfg.TTL)
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.cfg.Issuer,
+			Subject:   strconv.FormatInt(subject.UserID, 10),
+			Audience:  i.cfg.Audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Username: subject.Username,
+		Roles:    subject.Roles,
+		Session:  subject.Session,
+	}
+
+	tok := jwt.NewWithClaims(i.active.Method, claims)
+	tok.Header["kid"] = i.active.ID
+
+	signed, err := tok.SignedString(i.active.sign)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify проверяет подпись, срок действия, издателя и получателей токена и возвращает его утверждения.
+// Токен должен быть адресован всем получателям из конфигурации, как токены, выпущенные Issue.
+func (i *Issuer) Verify(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmRS256, AlgorithmHS256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(i.cfg.Issuer),
+		jwt.WithTimeFunc(i.now),
+	}
+	if len(i.cfg.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(i.cfg.Audience[0]))
+	}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := i.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("signing method %s does not match key %s", t.Method.Alg(), kid)
+		}
+		return key.verify, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	// WithAudience проверяет только одного получателя, поэтому наличие остальных проверяем отдельно
+	for _, aud := range i.cfg.Audience {
+		if !slices.Contains(claims.Audience, aud) {
+			return nil, fmt.Errorf("%w: token is not issued for audience %s", ErrInvalidToken, aud)
+		}
+	}
+
+	return claims, nil
+}
+
+// JWKS возвращает открытые ключи для проверки токенов другими сервисами
+func (i *Issuer) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range i.order {
+		if jwk, ok := i.keys[id].jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
@@ -0,0 +1,99 @@
+package token
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestIssuer(t *testing.T, audience ...string) *Issuer {
+	t.Helper()
+	key, err := GenerateEd25519SigningKey("test")
+	if err != nil {
+		t.Fatalf("GenerateEd25519SigningKey: %v", err)
+	}
+	issuer, err := NewIssuer(Config{Issuer: "watchlist-user", Audience: audience, TTL: time.Minute}, []*SigningKey{key}, "")
+	if err != nil {
+		t.Fatalf("NewIssuer: %v", err)
+	}
+	return issuer
+}
+
+// sign подписывает произвольные утверждения активным ключом issuer
+func sign(t *testing.T, issuer *Issuer, claims jwt.Claims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(issuer.active.Method, claims)
+	tok.Header["kid"] = issuer.active.ID
+	signed, err := tok.SignedString(issuer.active.sign)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestIssuerVerify(t *testing.T) {
+	issuer := newTestIssuer(t, "watchlist-api", "watchlist-web")
+	now := time.Now()
+
+	valid, _, err := issuer.Issue(Subject{UserID: 42, Username: "alice", Session: "s1"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	registered := func(modify func(*jwt.RegisteredClaims)) string {
+		claims := jwt.RegisteredClaims{
+			Issuer:    "watchlist-user",
+			Subject:   "42",
+			Audience:  jwt.ClaimStrings{"watchlist-api", "watchlist-web"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}
+		modify(&claims)
+		return sign(t, issuer, &Claims{RegisteredClaims: claims, Username: "alice"})
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "issued token", token: valid},
+		{name: "no expiration", token: registered(func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }), wantErr: true},
+		{name: "expired", token: registered(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }), wantErr: true},
+		{name: "other issuer", token: registered(func(c *jwt.RegisteredClaims) { c.Issuer = "other" }), wantErr: true},
+		{name: "no audience", token: registered(func(c *jwt.RegisteredClaims) { c.Audience = nil }), wantErr: true},
+		{name: "other audience", token: registered(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }), wantErr: true},
+		{name: "partial audience", token: registered(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"watchlist-api"} }), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := issuer.Verify(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("Verify error = %v, want %v", err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if id, err := claims.UserID(); err != nil || id != 42 {
+				t.Errorf("UserID = %d, %v; want 42", id, err)
+			}
+			if claims.Username != "alice" || claims.Session != "s1" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestIssuerVerifyWithoutAudience(t *testing.T) {
+	issuer := newTestIssuer(t)
+	tok, _, err := issuer.Issue(Subject{UserID: 1, Username: "bob"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := issuer.Verify(tok); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
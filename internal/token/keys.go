@@ -0,0 +1,139 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Поддерживаемые алгоритмы подписи
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+	AlgorithmHS256 = "HS256"
+)
+
+// minHMACKeyLength минимальная длина секрета HS256 в байтах
+const minHMACKeyLength = 32
+
+// SigningKey ключ подписи токенов с идентификатором kid
+type SigningKey struct {
+	ID     string            // Идентификатор ключа (kid)
+	Method jwt.SigningMethod // Алгоритм подписи
+	sign   interface{}       // Закрытый ключ или секрет HMAC
+	verify interface{}       // Открытый ключ или секрет HMAC
+	public crypto.PublicKey  // Открытый ключ для публикации; nil для симметричных ключей
+}
+
+// LoadSigningKey читает ключ из файла: PEM для EdDSA и RS256, сырой секрет для HS256
+func LoadSigningKey(id, algorithm, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %w", id, err)
+	}
+	return ParseSigningKey(id, algorithm, data)
+}
+
+// ParseSigningKey разбирает ключ подписи из содержимого файла
+func ParseSigningKey(id, algorithm string, data []byte) (*SigningKey, error) {
+	if id == "" {
+		return nil, errors.New("signing key id cannot be empty")
+	}
+
+	switch algorithm {
+	case AlgorithmEdDSA:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EdDSA key %s: %w", id, err)
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not an Ed25519 key", id)
+		}
+		return NewEd25519SigningKey(id, private), nil
+	case AlgorithmRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RS256 key %s: %w", id, err)
+		}
+		if private.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RS256 key %s must be at least 2048 bits", id)
+		}
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, sign: private, verify: &private.PublicKey, public: &private.PublicKey}, nil
+	case AlgorithmHS256:
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) < minHMACKeyLength {
+			return nil, fmt.Errorf("HS256 key %s must be at least %d bytes", id, minHMACKeyLength)
+		}
+		return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, sign: secret, verify: secret}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q for key %s", algorithm, id)
+	}
+}
+
+// NewEd25519SigningKey создает ключ EdDSA из закрытого ключа Ed25519
+func NewEd25519SigningKey(id string, private ed25519.PrivateKey) *SigningKey {
+	public := private.Public()
+	return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, sign: private, verify: public, public: public}
+}
+
+// GenerateEd25519SigningKey создает случайный ключ EdDSA, который живет только в памяти процесса
+func GenerateEd25519SigningKey(id string) (*SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+	return NewEd25519SigningKey(id, private), nil
+}
+
+// JWK открытый ключ в формате JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`           // Тип ключа
+	Kid string `json:"kid"`           // Идентификатор ключа
+	Use string `json:"use"`           // Назначение ключа
+	Alg string `json:"alg"`           // Алгоритм подписи
+	Crv string `json:"crv,omitempty"` // Кривая (OKP)
+	X   string `json:"x,omitempty"`   // Открытый ключ (OKP)
+	N   string `json:"n,omitempty"`   // Модуль (RSA)
+	E   string `json:"e,omitempty"`   // Экспонента (RSA)
+}
+
+// JWKS набор открытых ключей
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwk возвращает открытую часть ключа; симметричные ключи не публикуются
+func (k *SigningKey) jwk() (JWK, bool) {
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Method.Alg(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}, true
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.Method.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
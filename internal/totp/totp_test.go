@@ -0,0 +1,119 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret ключ "12345678901234567890" из приложения B RFC 6238 в base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// Векторы SHA1 из приложения B RFC 6238, сокращенные до шести последних цифр
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	got, err := Code(strings.ToLower(rfc6238Secret), Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("Code with a lowercase secret = %s, %v; want 287082", got, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: code(step), wantStep: step, wantOK: true},
+		{name: "previous step within skew", code: code(step - 1), wantStep: step - 1, wantOK: true},
+		{name: "next step within skew", code: code(step + 1), wantStep: step + 1, wantOK: true},
+		{name: "surrounding spaces", code: " " + code(step) + " ", wantStep: step, wantOK: true},
+		{name: "outside skew", code: code(step - 2)},
+		{name: "wrong code", code: "000000"},
+		{name: "wrong length", code: "12345"},
+		{name: "empty", code: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok, err := Validate(rfc6238Secret, tt.code, now, 1)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if ok != tt.wantOK || (ok && gotStep != tt.wantStep) {
+				t.Errorf("Validate = step %d, %v; want step %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	key, err := b32.DecodeString(secret)
+	if err != nil || len(key) != secretSize {
+		t.Errorf("GenerateSecret = %q, decodes to %d bytes, %v", secret, len(key), err)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Watchlist", "alice", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("URI is not a valid URL: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Watchlist:alice" {
+		t.Errorf("URI = %s", uri)
+	}
+	query := uri.Query()
+	for key, want := range map[string]string{
+		"secret": rfc6238Secret, "issuer": "Watchlist", "algorithm": "SHA1", "digits": "6", "period": "30",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("URI %s = %q, want %q", key, got, want)
+		}
+	}
+}
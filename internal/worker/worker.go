@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job периодическая фоновая задача; Run возвращает количество обработанных записей
+type Job struct {
+	Name     string                                   // Имя задачи для логов
+	Interval time.Duration                            // Период запуска
+	Run      func(ctx context.Context) (int64, error) // Тело задачи
+}
+
+// Run запускает задачи, каждую в своем цикле, и блокируется до отмены контекста
+func Run(ctx context.Context, logger *slog.Logger, jobs ...Job) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		if job.Interval <= 0 {
+			logger.Warn(fmt.Sprintf("background job %s disabled: non-positive interval", job.Name))
+			continue
+		}
+
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			runLoop(ctx, logger, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// runLoop выполняет задачу с заданным периодом
+func runLoop(ctx context.Context, logger *slog.Logger, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := job.Run(ctx)
+			if err != nil {
+				logger.Error(fmt.Sprintf("background job %s failed", job.Name), slog.Any("error", err))
+				continue
+			}
+			if n > 0 {
+				logger.Info(fmt.Sprintf("background job %s processed %d records", job.Name, n))
+			}
+		}
+	}
+}
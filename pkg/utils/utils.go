@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"log"
+	"time"
+
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"log"
 
 	"github.com/watchlist-kata/user/internal/config"
 )
@@ -21,3 +24,37 @@ func ConnectToDatabase(cfg *config.Config) (*gorm.DB, error) {
 
 	return db, nil
 }
+
+// ConnectToReplicas открывает подключения к репликам только для чтения.
+// Соединение не проверяется при открытии, чтобы недоступная реплика не мешала запуску.
+func ConnectToReplicas(cfg *config.Config) ([]*gorm.DB, error) {
+	var replicas []*gorm.DB
+	for i, dsn := range cfg.DBReplicaDSNs {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			log.Printf("failed to connect to read replica %d: %v", i+1, err)
+			return nil, err
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas, nil
+}
+
+// sqliteParams параметры подключения к SQLite: внешние ключи, ожидание блокировки вместо ошибки,
+// журнал WAL для чтения во время записи и немедленная блокировка записи в начале транзакции,
+// чтобы транзакции чтения-изменения не завершались ошибкой SQLITE_BUSY
+const sqliteParams = "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+// ConnectToSQLite открывает базу SQLite, создавая файл при необходимости.
+// SQLite хранит время строкой и сравнивает как текст, поэтому время, проставляемое GORM, записывается в UTC.
+func ConnectToSQLite(cfg *config.Config) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(cfg.DBSQLitePath+sqliteParams), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		log.Printf("failed to open SQLite database: %v", err)
+		return nil, err
+	}
+
+	return db, nil
+}